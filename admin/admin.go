@@ -0,0 +1,305 @@
+package admin
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"lsat/config"
+	"lsat/service"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var ErrUnknownService = errors.New("unknown service")
+
+// Admin serves the API managing the services.
+//
+// Every change is validated, recorded as a new version of the Store, and then applied to
+// the service manager, so that the next tokens are sold with it.
+type Admin struct {
+	store   *Store
+	manager *service.Config
+	token   string
+
+	mu sync.Mutex // Serializes the changes.
+}
+
+// Summary describes a version without its services.
+type Summary struct {
+	Number int       `json:"version"`
+	Time   time.Time `json:"time"`
+	Change string    `json:"change"`
+}
+
+func summarize(version Version) Summary {
+	return Summary{Number: version.Number, Time: version.Time, Change: version.Change}
+}
+
+// Create a new Admin and apply the current version of the store to the manager.
+//
+// The requests must carry the token as a bearer token.
+func New(store *Store, manager *service.Config, token string) (*Admin, error) {
+	admin := &Admin{store: store, manager: manager, token: token}
+	if err := admin.apply(store.Current().Services); err != nil {
+		return nil, fmt.Errorf("invalid version %d: %w", store.Current().Number, err)
+	}
+	return admin, nil
+}
+
+// apply validates the services and replaces those of the manager.
+//
+// The disabled services are kept, flagged, so that their tokens are still verified.
+func (a *Admin) apply(entries []Entry) error {
+	services, err := build(entries)
+	if err != nil {
+		return err
+	}
+	a.manager.Reset(services...)
+	return nil
+}
+
+func build(entries []Entry) ([]service.Service, error) {
+	var services []service.Service
+	for _, entry := range entries {
+		built, err := entry.Service()
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", entry.Name, entry.Tier, err)
+		}
+		built.Disabled = entry.Disabled
+		services = append(services, built)
+	}
+	return services, nil
+}
+
+// Register the routes of the API on a group, e.g. /admin.
+func (a *Admin) Register(group *gin.RouterGroup) {
+	group.Use(a.authenticate)
+	group.GET("/services", a.HandleList)
+	group.GET("/services/:service", a.HandleGet)
+	group.PUT("/services/:service", a.HandlePut)
+	group.DELETE("/services/:service", a.HandleDelete)
+	group.POST("/services/:service/disable", a.HandleDisable)
+	group.POST("/services/:service/enable", a.HandleEnable)
+	group.GET("/versions", a.HandleVersions)
+	group.GET("/versions/:version", a.HandleVersion)
+	group.POST("/versions/:version/rollback", a.HandleRollback)
+}
+
+// Reject the requests without the token.
+func (a *Admin) authenticate(c *gin.Context) {
+	token, found := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if !found || a.token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(a.token)) != 1 {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid admin token"})
+		return
+	}
+	c.Next()
+}
+
+// Find a service in the entries.
+func find(entries []Entry, id service.ServiceID) int {
+	for i, entry := range entries {
+		if entry.Name == id.Name && service.Tier(entry.Tier) == id.Tier {
+			return i
+		}
+	}
+	return -1
+}
+
+// change applies a change to the current services and records it.
+func (a *Admin) change(description string, update func([]Entry) ([]Entry, error)) (Version, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	current := a.store.Current().Services
+	entries, err := update(append([]Entry(nil), current...))
+	if err != nil {
+		return Version{}, err
+	}
+
+	// Validate before recording, so that the store only holds valid versions.
+	if _, err := build(entries); err != nil {
+		return Version{}, err
+	}
+
+	version, err := a.store.Commit(description, entries)
+	if err != nil {
+		return Version{}, err
+	}
+	return version, a.apply(entries)
+}
+
+// Respond with the version of a change, or its error.
+func respond(c *gin.Context, status int, version Version, err error) {
+	var errs config.Errors
+	switch {
+	case errors.Is(err, ErrUnknownService), errors.Is(err, ErrUnknownVersion):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.As(err, &errs):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	default:
+		c.JSON(status, summarize(version))
+	}
+}
+
+func serviceParam(c *gin.Context) (service.ServiceID, bool) {
+	id, err := service.ParseServiceID(c.Param("service"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return service.ServiceID{}, false
+	}
+	return id, true
+}
+
+// List the services, with the current version.
+func (a *Admin) HandleList(c *gin.Context) {
+	current := a.store.Current()
+	c.JSON(http.StatusOK, gin.H{"version": current.Number, "services": current.Services})
+}
+
+// Get a service.
+func (a *Admin) HandleGet(c *gin.Context) {
+	id, ok := serviceParam(c)
+	if !ok {
+		return
+	}
+
+	entries := a.store.Current().Services
+	i := find(entries, id)
+	if i < 0 {
+		respond(c, 0, Version{}, fmt.Errorf("%w %s", ErrUnknownService, id))
+		return
+	}
+	c.JSON(http.StatusOK, entries[i])
+}
+
+// Create or update a service.
+//
+// The name and the tier are those of the path. An updated service keeps its state.
+func (a *Admin) HandlePut(c *gin.Context) {
+	id, ok := serviceParam(c)
+	if !ok {
+		return
+	}
+
+	var file config.ServiceFile
+	if err := c.ShouldBindJSON(&file); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if (file.Name != "" && file.Name != id.Name) || (file.Tier != 0 && service.Tier(file.Tier) != id.Tier) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("the service of the body does not match %s", id)})
+		return
+	}
+	file.Name, file.Tier = id.Name, int(id.Tier)
+
+	status := http.StatusOK
+	version, err := a.change("update "+id.String(), func(entries []Entry) ([]Entry, error) {
+		if i := find(entries, id); i >= 0 {
+			entries[i].ServiceFile = file
+			return entries, nil
+		}
+		status = http.StatusCreated
+		return append(entries, Entry{ServiceFile: file}), nil
+	})
+	respond(c, status, version, err)
+}
+
+// Delete a service.
+func (a *Admin) HandleDelete(c *gin.Context) {
+	id, ok := serviceParam(c)
+	if !ok {
+		return
+	}
+
+	version, err := a.change("delete "+id.String(), func(entries []Entry) ([]Entry, error) {
+		i := find(entries, id)
+		if i < 0 {
+			return nil, fmt.Errorf("%w %s", ErrUnknownService, id)
+		}
+		return append(entries[:i], entries[i+1:]...), nil
+	})
+	respond(c, http.StatusOK, version, err)
+}
+
+// Disable a service, which stops selling new tokens.
+func (a *Admin) HandleDisable(c *gin.Context) {
+	a.setDisabled(c, true)
+}
+
+// Enable a disabled service.
+func (a *Admin) HandleEnable(c *gin.Context) {
+	a.setDisabled(c, false)
+}
+
+func (a *Admin) setDisabled(c *gin.Context, disabled bool) {
+	id, ok := serviceParam(c)
+	if !ok {
+		return
+	}
+
+	action := "enable "
+	if disabled {
+		action = "disable "
+	}
+	version, err := a.change(action+id.String(), func(entries []Entry) ([]Entry, error) {
+		i := find(entries, id)
+		if i < 0 {
+			return nil, fmt.Errorf("%w %s", ErrUnknownService, id)
+		}
+		entries[i].Disabled = disabled
+		return entries, nil
+	})
+	respond(c, http.StatusOK, version, err)
+}
+
+// List the versions.
+func (a *Admin) HandleVersions(c *gin.Context) {
+	versions := a.store.Versions()
+	summaries := make([]Summary, len(versions))
+	for i, version := range versions {
+		summaries[i] = summarize(version)
+	}
+	c.JSON(http.StatusOK, summaries)
+}
+
+func (a *Admin) versionParam(c *gin.Context) (Version, bool) {
+	number, err := strconv.Atoi(c.Param("version"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid version"})
+		return Version{}, false
+	}
+
+	version, err := a.store.Version(number)
+	if err != nil {
+		respond(c, 0, Version{}, err)
+		return Version{}, false
+	}
+	return version, true
+}
+
+// Get a version with its services.
+func (a *Admin) HandleVersion(c *gin.Context) {
+	if version, ok := a.versionParam(c); ok {
+		c.JSON(http.StatusOK, version)
+	}
+}
+
+// Roll back to a version, recorded as a new version.
+func (a *Admin) HandleRollback(c *gin.Context) {
+	target, ok := a.versionParam(c)
+	if !ok {
+		return
+	}
+
+	version, err := a.change(fmt.Sprintf("rollback to %d", target.Number), func([]Entry) ([]Entry, error) {
+		return append([]Entry(nil), target.Services...), nil
+	})
+	respond(c, http.StatusOK, version, err)
+}
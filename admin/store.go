@@ -0,0 +1,147 @@
+// Package admin manages the services of a server at runtime, through an authenticated API.
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"lsat/config"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+var ErrUnknownVersion = errors.New("unknown version")
+
+// Entry is a service of the catalog.
+type Entry struct {
+	config.ServiceFile
+	Disabled bool `json:"disabled,omitempty"` // A disabled service does not sell new tokens.
+}
+
+// Version is a state of the catalog, recorded on each change.
+type Version struct {
+	Number   int       `json:"version"`
+	Time     time.Time `json:"time"`
+	Change   string    `json:"change"` // A description of the change, e.g. update image:0.
+	Services []Entry   `json:"services"`
+}
+
+// Store records the versions of the catalog in a JSON file.
+//
+// The file is rewritten atomically on each change, so that it is never left half written.
+type Store struct {
+	Clock func() time.Time // Defaults to time.Now.
+
+	mu       sync.Mutex
+	path     string
+	versions []Version
+}
+
+// Open the store of a file.
+//
+// A missing file is created with the seed services as the first version.
+func Open(path string, seed []config.ServiceFile) (*Store, error) {
+	store := &Store{path: path}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		services := make([]Entry, len(seed))
+		for i, s := range seed {
+			services[i] = Entry{ServiceFile: s}
+		}
+		if _, err := store.Commit("seed", services); err != nil {
+			return nil, err
+		}
+		return store, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, &store.versions); err != nil {
+		return nil, fmt.Errorf("invalid state file %s: %w", path, err)
+	}
+	if len(store.versions) == 0 {
+		return nil, fmt.Errorf("invalid state file %s: no version", path)
+	}
+	return store, nil
+}
+
+func (s *Store) now() time.Time {
+	if s.Clock == nil {
+		return time.Now()
+	}
+	return s.Clock()
+}
+
+// Current returns the last version.
+func (s *Store) Current() Version {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.versions[len(s.versions)-1]
+}
+
+// Versions returns every version, from the first.
+func (s *Store) Versions() []Version {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Version(nil), s.versions...)
+}
+
+// Version returns a version by number.
+func (s *Store) Version(number int) (Version, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, version := range s.versions {
+		if version.Number == number {
+			return version, nil
+		}
+	}
+	return Version{}, fmt.Errorf("%w %d", ErrUnknownVersion, number)
+}
+
+// Commit records the services as a new version and writes the file.
+func (s *Store) Commit(change string, services []Entry) (Version, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	version := Version{Number: 1, Time: s.now().UTC(), Change: change, Services: services}
+	if len(s.versions) > 0 {
+		version.Number = s.versions[len(s.versions)-1].Number + 1
+	}
+
+	versions := append(s.versions[:len(s.versions):len(s.versions)], version)
+	if err := s.write(versions); err != nil {
+		return Version{}, err
+	}
+	s.versions = versions
+	return version, nil
+}
+
+// write replaces the file with the versions.
+func (s *Store) write(versions []Version) error {
+	data, err := json.MarshalIndent(versions, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
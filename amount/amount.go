@@ -0,0 +1,133 @@
+// Package amount provides the unit used for every Lightning amount.
+package amount
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/lightningnetwork/lnd/lnwire"
+)
+
+// MilliSatoshi is an amount in thousandths of a satoshi, the smallest unit of the Lightning Network.
+type MilliSatoshi uint64
+
+const (
+	MilliSat MilliSatoshi = 1
+	Satoshi               = 1000 * MilliSat
+	Bitcoin               = 100_000_000 * Satoshi
+)
+
+// Rounding is a policy to convert millisatoshis to whole satoshis.
+type Rounding int8
+
+const (
+	// RoundUp rounds to the next satoshi, so that a payee is never undercharged.
+	RoundUp Rounding = iota
+	// RoundDown truncates the millisatoshis.
+	RoundDown
+	// RoundNearest rounds to the nearest satoshi, half away from zero.
+	RoundNearest
+	// RoundExact refuses to convert amounts that are not whole satoshis.
+	RoundExact
+)
+
+// ErrNotWholeSatoshi is returned when converting an amount with RoundExact.
+var ErrNotWholeSatoshi = errors.New("the amount is not a whole number of satoshis")
+
+// The units accepted by Parse.
+var units = map[string]MilliSatoshi{
+	"msat":  MilliSat,
+	"msats": MilliSat,
+	"sat":   Satoshi,
+	"sats":  Satoshi,
+	"btc":   Bitcoin,
+}
+
+// FromSatoshis converts an amount of satoshis.
+func FromSatoshis(sats uint64) MilliSatoshi {
+	return MilliSatoshi(sats) * Satoshi
+}
+
+// FromLnwire converts an lnwire amount.
+func FromLnwire(amt lnwire.MilliSatoshi) MilliSatoshi {
+	return MilliSatoshi(amt)
+}
+
+// ToSatoshis converts the amount to satoshis with the rounding policy.
+func (m MilliSatoshi) ToSatoshis(rounding Rounding) (uint64, error) {
+	sats, rest := uint64(m/Satoshi), uint64(m%Satoshi)
+
+	if rest == 0 {
+		return sats, nil
+	}
+
+	switch rounding {
+	case RoundUp:
+		return sats + 1, nil
+	case RoundDown:
+		return sats, nil
+	case RoundNearest:
+		if rest >= 500 {
+			return sats + 1, nil
+		}
+		return sats, nil
+	case RoundExact:
+		return 0, fmt.Errorf("%w: %s", ErrNotWholeSatoshi, m)
+	default:
+		return 0, fmt.Errorf("unknown rounding policy: %d", rounding)
+	}
+}
+
+// ToLnwire converts the amount to the lnwire type.
+func (m MilliSatoshi) ToLnwire() lnwire.MilliSatoshi {
+	return lnwire.MilliSatoshi(m)
+}
+
+// String formats the amount so that it can be read back with Parse.
+func (m MilliSatoshi) String() string {
+	if m%Satoshi == 0 {
+		return fmt.Sprintf("%dsat", uint64(m/Satoshi))
+	}
+	return fmt.Sprintf("%dmsat", uint64(m))
+}
+
+// Parse reads an amount with its unit, e.g. "100sat", "2500msat" or "0.001 btc".
+//
+// The unit is mandatory to avoid mixing satoshis and millisatoshis. Decimal values
+// are accepted as long as they resolve to a whole number of millisatoshis.
+func Parse(s string) (MilliSatoshi, error) {
+	value := strings.ToLower(strings.TrimSpace(s))
+
+	// Split the number from its unit.
+	i := strings.IndexFunc(value, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.'
+	})
+	if i <= 0 {
+		return 0, fmt.Errorf("invalid amount: %q, expected a number followed by a unit", s)
+	}
+
+	number, unitName := value[:i], strings.TrimSpace(value[i:])
+
+	unit, ok := units[unitName]
+	if !ok {
+		return 0, fmt.Errorf("invalid amount unit: %q", unitName)
+	}
+
+	rat, ok := new(big.Rat).SetString(number)
+	if !ok {
+		return 0, fmt.Errorf("invalid amount: %q", s)
+	}
+
+	rat.Mul(rat, new(big.Rat).SetUint64(uint64(unit)))
+	if !rat.IsInt() {
+		return 0, fmt.Errorf("invalid amount: %q is not a whole number of millisatoshis", s)
+	}
+
+	if !rat.Num().IsUint64() {
+		return 0, fmt.Errorf("invalid amount: %q overflows", s)
+	}
+
+	return MilliSatoshi(rat.Num().Uint64()), nil
+}
@@ -0,0 +1,309 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"lsat/challenge"
+	"lsat/macaroon"
+	"strings"
+
+	"github.com/lightningnetwork/lnd/lntypes"
+)
+
+const (
+	// SchemeL402 is the authentication scheme of L402.
+	SchemeL402 = "L402"
+	// SchemeLSAT is the legacy name of the scheme, still accepted.
+	SchemeLSAT = "LSAT"
+)
+
+var (
+	ErrNoChallenge      = errors.New("no L402 challenge in the header")
+	ErrInvalidScheme    = errors.New("the authentication scheme is not L402 or LSAT")
+	ErrInvalidChallenge = errors.New("invalid L402 challenge")
+	ErrInvalidAuth      = errors.New("invalid L402 credentials")
+)
+
+// Challenge is the content of a WWW-Authenticate header asking for a payment.
+//
+//	L402 macaroon="<base64>", invoice="<bolt11>"
+type Challenge struct {
+	Scheme   string // L402 or LSAT.
+	Macaroon string // The macaroon, base64 encoded.
+	Invoice  string // The invoice to pay.
+}
+
+// Authorization is the content of an Authorization header carrying a paid token.
+//
+//	L402 <base64>[,<base64>...]:<preimage>
+type Authorization struct {
+	Scheme    string   // L402 or LSAT.
+	Macaroons []string // The macaroons, base64 encoded.
+	Preimage  string   // The preimage of the payment, hex encoded.
+}
+
+// Whether the scheme is L402 or LSAT, ignoring the case.
+func isScheme(scheme string) bool {
+	return strings.EqualFold(scheme, SchemeL402) || strings.EqualFold(scheme, SchemeLSAT)
+}
+
+// HasScheme reports whether an Authorization header carries credentials of the L402 or LSAT
+// scheme, rather than of another scheme or none.
+func HasScheme(header string) bool {
+	scheme, _, _ := strings.Cut(strings.TrimSpace(header), " ")
+	return isScheme(scheme)
+}
+
+// Create the challenge of a pre-token.
+func NewChallenge(token macaroon.PreToken) Challenge {
+	return Challenge{
+		Scheme:   SchemeL402,
+		Macaroon: token.Macaroon.String(),
+		Invoice:  token.InvoiceResponse.Invoice,
+	}
+}
+
+func (c Challenge) String() string {
+	scheme := c.Scheme
+	if scheme == "" {
+		scheme = SchemeL402
+	}
+	return fmt.Sprintf("%s macaroon=%s, invoice=%s", scheme, quote(c.Macaroon), quote(c.Invoice))
+}
+
+// PreToken decodes the macaroon and the invoice of the challenge.
+func (c Challenge) PreToken() (macaroon.PreToken, error) {
+	mac, err := macaroon.DecodeBase64(c.Macaroon)
+	if err != nil {
+		return macaroon.PreToken{}, fmt.Errorf("%w: %v", ErrInvalidChallenge, err)
+	}
+
+	return macaroon.PreToken{
+		Macaroon:        mac,
+		InvoiceResponse: challenge.InvoiceResponse{Invoice: c.Invoice},
+	}, nil
+}
+
+// ParseChallenges reads the L402 and LSAT challenges of WWW-Authenticate headers.
+//
+// A header may hold several challenges, and the challenges of other schemes are skipped.
+// The names of the parameters are case-insensitive and their values may be quoted.
+func ParseChallenges(headers ...string) ([]Challenge, error) {
+	var challenges []Challenge
+	for _, header := range headers {
+		parsed, err := parseAuthParams(header)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, p := range parsed {
+			if !isScheme(p.scheme) {
+				continue
+			}
+
+			c := Challenge{Scheme: strings.ToUpper(p.scheme), Macaroon: p.params["macaroon"], Invoice: p.params["invoice"]}
+			if c.Macaroon == "" || c.Invoice == "" {
+				return nil, fmt.Errorf("%w: the macaroon and the invoice are required", ErrInvalidChallenge)
+			}
+			challenges = append(challenges, c)
+		}
+	}
+
+	if len(challenges) == 0 {
+		return nil, ErrNoChallenge
+	}
+	return challenges, nil
+}
+
+// Create the authorization of a token.
+func NewAuthorization(token macaroon.Token) Authorization {
+	return Authorization{
+		Scheme:    SchemeL402,
+		Macaroons: []string{token.Macaroon.String()},
+		Preimage:  token.Preimage.String(),
+	}
+}
+
+func (a Authorization) String() string {
+	scheme := a.Scheme
+	if scheme == "" {
+		scheme = SchemeL402
+	}
+	return fmt.Sprintf("%s %s:%s", scheme, strings.Join(a.Macaroons, ","), a.Preimage)
+}
+
+// Token decodes the token of the authorization.
+//
+// The tokens carry a single macaroon, an authorization with several macaroons, such as
+// discharge macaroons, fails with ErrInvalidAuth rather than ignoring them.
+func (a Authorization) Token() (macaroon.Token, error) {
+	if len(a.Macaroons) != 1 {
+		return macaroon.Token{}, fmt.Errorf("%w: expected a single macaroon, got %d", ErrInvalidAuth, len(a.Macaroons))
+	}
+
+	mac, err := macaroon.DecodeBase64(a.Macaroons[0])
+	if err != nil {
+		return macaroon.Token{}, fmt.Errorf("%w: %v", ErrInvalidAuth, err)
+	}
+
+	preimage, err := lntypes.MakePreimageFromStr(a.Preimage)
+	if err != nil {
+		return macaroon.Token{}, fmt.Errorf("%w: %v", ErrInvalidAuth, err)
+	}
+
+	return macaroon.Token{Macaroon: mac, Preimage: preimage}, nil
+}
+
+// ParseAuthorization reads an Authorization header.
+func ParseAuthorization(header string) (Authorization, error) {
+	scheme, credentials, _ := strings.Cut(strings.TrimSpace(header), " ")
+	if !isScheme(scheme) {
+		return Authorization{}, ErrInvalidScheme
+	}
+
+	credentials = strings.TrimSpace(credentials)
+	separator := strings.LastIndex(credentials, ":")
+	if separator < 0 {
+		return Authorization{}, fmt.Errorf("%w: expected <macaroons>:<preimage>", ErrInvalidAuth)
+	}
+
+	var macaroons []string
+	for _, mac := range strings.Split(credentials[:separator], ",") {
+		if mac = strings.TrimSpace(mac); mac != "" {
+			macaroons = append(macaroons, mac)
+		}
+	}
+
+	preimage := strings.TrimSpace(credentials[separator+1:])
+	if len(macaroons) == 0 || preimage == "" {
+		return Authorization{}, fmt.Errorf("%w: expected <macaroons>:<preimage>", ErrInvalidAuth)
+	}
+
+	return Authorization{Scheme: strings.ToUpper(scheme), Macaroons: macaroons, Preimage: preimage}, nil
+}
+
+// A challenge of any scheme, with its parameters by lowercase name.
+type authChallenge struct {
+	scheme string
+	params map[string]string
+}
+
+// parseAuthParams splits a WWW-Authenticate header into its challenges, following RFC 7235.
+func parseAuthParams(header string) ([]authChallenge, error) {
+	var challenges []authChallenge
+	s := &scanner{input: header}
+
+	for {
+		s.skip(" \t,")
+		if s.done() {
+			return challenges, nil
+		}
+
+		scheme := s.token()
+		if scheme == "" {
+			return nil, fmt.Errorf("%w: unexpected %q", ErrInvalidChallenge, s.input[s.pos])
+		}
+		current := authChallenge{scheme: scheme, params: make(map[string]string)}
+
+		for {
+			s.skip(" \t")
+			start := s.pos
+			name := s.token()
+			s.skip(" \t")
+
+			// A token without a value starts the next challenge.
+			if name == "" || !s.consume('=') {
+				s.pos = start
+				break
+			}
+			s.skip(" \t")
+
+			value, err := s.value()
+			if err != nil {
+				return nil, err
+			}
+			current.params[strings.ToLower(name)] = value
+
+			s.skip(" \t")
+			if !s.consume(',') {
+				break
+			}
+		}
+
+		challenges = append(challenges, current)
+	}
+}
+
+// A scanner reading the tokens and quoted strings of a header.
+type scanner struct {
+	input string
+	pos   int
+}
+
+func (s *scanner) done() bool {
+	return s.pos >= len(s.input)
+}
+
+func (s *scanner) skip(chars string) {
+	for !s.done() && strings.IndexByte(chars, s.input[s.pos]) >= 0 {
+		s.pos++
+	}
+}
+
+func (s *scanner) consume(c byte) bool {
+	if !s.done() && s.input[s.pos] == c {
+		s.pos++
+		return true
+	}
+	return false
+}
+
+// token reads the characters allowed in a token.
+func (s *scanner) token() string {
+	start := s.pos
+	for !s.done() && isTokenChar(s.input[s.pos]) {
+		s.pos++
+	}
+	return s.input[start:s.pos]
+}
+
+// value reads a token or a quoted string.
+func (s *scanner) value() (string, error) {
+	if !s.consume('"') {
+		// Base64 values may end with = padding.
+		start := s.pos
+		for !s.done() && (isTokenChar(s.input[s.pos]) || s.input[s.pos] == '=') {
+			s.pos++
+		}
+		return s.input[start:s.pos], nil
+	}
+
+	var value strings.Builder
+	for !s.done() {
+		c := s.input[s.pos]
+		s.pos++
+		switch {
+		case c == '"':
+			return value.String(), nil
+		case c == '\\' && !s.done():
+			value.WriteByte(s.input[s.pos])
+			s.pos++
+		default:
+			value.WriteByte(c)
+		}
+	}
+	return "", fmt.Errorf("%w: unterminated quoted string", ErrInvalidChallenge)
+}
+
+func isTokenChar(c byte) bool {
+	switch {
+	case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		return true
+	}
+	return strings.IndexByte("!#$%&'*+-.^_`|~/", c) >= 0
+}
+
+// quote writes a value as a quoted string.
+func quote(value string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(value) + `"`
+}
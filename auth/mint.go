@@ -1,11 +1,18 @@
 package auth
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"lsat/amount"
 	"lsat/challenge"
 	"lsat/macaroon"
+	"lsat/rates"
 	"lsat/secrets"
 	"lsat/service"
+	"net/http"
+	"slices"
+	"time"
 )
 
 const (
@@ -14,16 +21,71 @@ const (
 	sigErr  = "the macaroon has an invalid signature"
 )
 
+var (
+	ErrPaymentHash = fmt.Errorf("%w: %s", macaroon.ErrUnpaid, hashErr)
+	ErrSignature   = errors.New(sigErr)
+	// ErrNotGranted is returned when a valid token does not grant access to the requested service.
+	ErrNotGranted = errors.New("the token does not grant access to the service")
+	// ErrChallenge is returned when the invoice of a challenge cannot be created, e.g. when the
+	// Lightning node or the rate provider is unavailable.
+	ErrChallenge = errors.New("the challenge could not be issued")
+)
+
+// Observer is notified of the challenges issued and the tokens verified, e.g. to record metrics.
+type Observer interface {
+	// Challenged is called when a challenge is issued for a service.
+	Challenged(id service.ServiceID, invoice challenge.InvoiceResponse)
+	// Authorized is called when a token is verified for a service, with a nil error if it is accepted.
+	Authorized(id service.ServiceID, err error)
+}
+
+type noObserver struct{}
+
+func (noObserver) Challenged(service.ServiceID, challenge.InvoiceResponse) {}
+func (noObserver) Authorized(service.ServiceID, error)                     {}
+
 // Minter is a struct that contains the necessary information to mint a new macaroon.
 type Minter struct {
 	service    service.ServiceManager
 	secrets    secrets.SecretStore
 	challenger challenge.Challenger
+	rates      rates.RateProvider
+	observer   Observer
+	replay     ReplayStore
 }
 
 // NewMinter creates a new Minter.
+//
+// The tokens of the services paid per request are spent in a MemoryReplayStore, unless
+// another store is set.
 func NewMinter(service service.ServiceManager, secrets secrets.SecretStore, challenger challenge.Challenger) Minter {
-	return Minter{service, secrets, challenger}
+	return Minter{service: service, secrets: secrets, challenger: challenger, replay: NewMemoryReplayStore()}
+}
+
+// Sets the provider used to convert the prices of services stated in a currency.
+func (minter Minter) WithRateProvider(provider rates.RateProvider) Minter {
+	minter.rates = provider
+	return minter
+}
+
+// Sets the observer notified of the challenges issued and the tokens verified.
+func (minter Minter) WithObserver(observer Observer) Minter {
+	minter.observer = observer
+	return minter
+}
+
+// Sets the store recording the tokens spent on the services paid per request.
+func (minter Minter) WithReplayStore(store ReplayStore) Minter {
+	minter.replay = store
+	return minter
+}
+
+// Observer returns the observer of the minter, which does nothing if none was set.
+func (minter *Minter) Observer() Observer {
+	if minter.observer == nil {
+		return noObserver{}
+	}
+	return minter.observer
 }
 
 // ServiceManager returns the service manager.
@@ -32,12 +94,36 @@ func (minter *Minter) SecretStore() secrets.SecretStore {
 }
 
 // totalPrice calculates the total price of the requested services.
-func totalPrice(services ...service.Service) uint64 {
-	var total uint64 = 0
+//
+// Prices stated in a currency are converted with the rate provider, and the quoted
+// rates are returned as caveats so that the token records the rate it was sold at.
+func (minter *Minter) totalPrice(ctx context.Context, services ...service.Service) (amount.MilliSatoshi, []macaroon.Caveat, error) {
+	var total amount.MilliSatoshi = 0
+	var caveats []macaroon.Caveat
 	for _, s := range services {
-		total += s.Price
+		if s.FiatPrice.IsZero() {
+			total += s.Price
+			continue
+		}
+
+		if minter.rates == nil {
+			return 0, nil, fmt.Errorf("no rate provider to convert the price of %s from %s", s.Id(), s.FiatPrice.Currency)
+		}
+
+		rate, err := minter.rates.Rate(ctx, s.FiatPrice.Currency)
+		if err != nil {
+			return 0, nil, fmt.Errorf("%w: %w", ErrChallenge, err)
+		}
+
+		price, err := rate.ToMilliSatoshis(s.FiatPrice.Amount)
+		if err != nil {
+			return 0, nil, err
+		}
+
+		total += price
+		caveats = append(caveats, macaroon.NewCaveat(macaroon.ExchangeRateKey, rate.String()))
 	}
-	return total
+	return total, caveats, nil
 }
 
 // ServiceManager returns the service manager.
@@ -45,8 +131,48 @@ func (minter *Minter) ServiceManager() service.ServiceManager {
 	return minter.service
 }
 
+// Challenge issues a challenge for the price of a service, without a token.
+//
+// It is used to extend what a token paid for, e.g. the allowance of a metered stream.
+func (minter *Minter) Challenge(ctx context.Context, id service.ServiceID) (challenge.InvoiceResponse, error) {
+	s, err := minter.service.GetService(id)
+	if err != nil {
+		return challenge.InvoiceResponse{}, err
+	}
+	invoice, _, err := minter.challenge(ctx, s)
+	return invoice, err
+}
+
+// challenge issues a challenge for the price of a service, with the caveats of the rates quoted.
+//
+// The disabled services are not sold, and fail with service.ErrDisabled.
+func (minter *Minter) challenge(ctx context.Context, s service.Service) (challenge.InvoiceResponse, []macaroon.Caveat, error) {
+	if s.Disabled {
+		return challenge.InvoiceResponse{}, nil, fmt.Errorf("%w: %s", service.ErrDisabled, s.Id())
+	}
+
+	// Convert the price of the service to satoshi.
+	price, rateCaveats, err := minter.totalPrice(ctx, s)
+	if err != nil {
+		return challenge.InvoiceResponse{}, nil, err
+	}
+
+	invoice, err := minter.challenger.Challenge(ctx, price)
+	if err != nil {
+		return challenge.InvoiceResponse{}, nil, fmt.Errorf("%w: %w", ErrChallenge, err)
+	}
+
+	minter.Observer().Challenged(s.Id(), invoice)
+	return invoice, rateCaveats, nil
+}
+
 // MintToken generates a new pre-token for the user.
 func (minter *Minter) MintToken(uid secrets.UserID, service_id service.ServiceID) (macaroon.PreToken, error) {
+	return minter.MintTokenContext(context.Background(), uid, service_id)
+}
+
+// MintTokenContext generates a new pre-token for the user, the context bounds the challenge.
+func (minter *Minter) MintTokenContext(ctx context.Context, uid secrets.UserID, service_id service.ServiceID) (macaroon.PreToken, error) {
 	// Initialize an empty pre-token.
 	token := macaroon.PreToken{}
 
@@ -56,8 +182,8 @@ func (minter *Minter) MintToken(uid secrets.UserID, service_id service.ServiceID
 		return token, err
 	}
 
-	// Initiate a payment challenge using the total price of the requested services.
-	result, err := minter.challenger.Challenge(totalPrice(service))
+	// Initiate a payment challenge for the price of the requested services.
+	result, rateCaveats, err := minter.challenge(ctx, service)
 	if err != nil {
 		return token, err
 	}
@@ -66,7 +192,7 @@ func (minter *Minter) MintToken(uid secrets.UserID, service_id service.ServiceID
 	token.InvoiceResponse = result
 
 	// Retrieve the capabilities (caveats) associated with the requested services.
-	caveats := service.Caveats()
+	caveats := append(service.Caveats(), rateCaveats...)
 
 	// Get or create a secret associated with the user ID.
 	secret, err := minter.secrets.GetSecret(uid)
@@ -94,37 +220,128 @@ func (minter *Minter) MintToken(uid secrets.UserID, service_id service.ServiceID
 }
 
 // AuthorizeToken returns an error if the token is invalid.
+//
+// The caveats restricting the requests, such as route and method, fail without a request,
+// see AuthRequest.
 func (minter *Minter) AuthToken(token *macaroon.Token) error {
+	return minter.AuthRequest(token, nil)
+}
+
+// AuthRequest returns an error if the token is invalid for a request.
+func (minter *Minter) AuthRequest(token *macaroon.Token, req *http.Request) error {
 	// Verify the preimage
 	paymentHashIter := token.Macaroon.GetValue(macaroon.PaymentHashKey)
 	if !paymentHashIter.HasNext() {
-		return errors.New(hashErr)
+		return ErrPaymentHash
 	}
 
 	if token.Preimage.Hash().String() != paymentHashIter.Next() {
-		return errors.New(hashErr)
+		return ErrPaymentHash
 	}
 
 	// Validate the LSAT's Macaroon using the authentication service.
-	return minter.AuthMacaroon(&token.Macaroon)
+	return minter.authMacaroon(&token.Macaroon, req)
 }
 
 // Verifies that signature and caveats are valid.
 func (minter *Minter) AuthMacaroon(mac *macaroon.Macaroon) error {
+	return minter.authMacaroon(mac, nil)
+}
+
+func (minter *Minter) authMacaroon(mac *macaroon.Macaroon, req *http.Request) error {
 	secret, _ := minter.secrets.GetSecret(mac.UserId())
 	oven := macaroon.NewOven(secret)
 	nmac, _ := oven.WithThirdPartyCaveats(mac.Caveats()...).Bake()
 
 	// Verify the signature.
 	if mac.Signature() != nmac.Signature() {
-		return errors.New(sigErr)
+		return ErrSignature
 	}
 
 	// Verify the caveats.
-	err := minter.service.VerifyCaveats(mac.Caveats()...)
+	err := minter.service.Verify(service.NewVerificationContext(req, mac.Caveats()...))
 	if err != nil {
 		return err
 	}
 
 	return nil
 }
+
+// Redeem spends a verified token on a request.
+//
+// The tokens sold per request, with the per_request caveat, are accepted once and fail with
+// ErrSpent when presented again, while the other tokens are reusable.
+func (minter *Minter) Redeem(token *macaroon.Token) error {
+	perRequest := token.Macaroon.GetValue(macaroon.PerRequestKey)
+	if !perRequest.HasNext() {
+		return nil
+	}
+
+	fresh, err := minter.replay.Spend(token.Preimage.Hash(), minter.expiryOf(token.Macaroon))
+	if err != nil {
+		return err
+	}
+	if !fresh {
+		return ErrSpent
+	}
+	return nil
+}
+
+// Release gives back a token spent on a request that failed, so that it can be used again.
+func (minter *Minter) Release(token *macaroon.Token) error {
+	perRequest := token.Macaroon.GetValue(macaroon.PerRequestKey)
+	if !perRequest.HasNext() {
+		return nil
+	}
+	return minter.replay.Release(token.Preimage.Hash())
+}
+
+// expiryOf returns the time after which a token is rejected, zero if it does not expire.
+//
+// It is the expiry issued by the minter, before the payment_hash caveat, and only when the
+// service of the token enforces it: the caveats added by the holder cannot shorten how long
+// a spent token is remembered.
+func (minter *Minter) expiryOf(mac macaroon.Macaroon) time.Time {
+	if !minter.enforcesExpiry(mac) {
+		return time.Time{}
+	}
+
+	var earliest time.Time
+	for _, caveat := range mac.Caveats() {
+		if caveat.Key == macaroon.PaymentHashKey {
+			break
+		}
+		if caveat.Key != macaroon.ExpiryDateKey {
+			continue
+		}
+		expiry, err := time.Parse(time.RFC3339, caveat.Value)
+		if err == nil && (earliest.IsZero() || expiry.Before(earliest)) {
+			earliest = expiry
+		}
+	}
+	return earliest
+}
+
+// Whether the service of a macaroon, granted by its first service caveat, has the Expire condition.
+func (minter *Minter) enforcesExpiry(mac macaroon.Macaroon) bool {
+	iter := mac.GetValue(macaroon.ServiceKey)
+	if !iter.HasNext() {
+		return false
+	}
+	id, err := service.ParseServiceID(iter.Next())
+	if err != nil {
+		return false
+	}
+	s, err := minter.service.GetService(id)
+	if err != nil {
+		return false
+	}
+
+	for _, condition := range append(slices.Clone(service.BuiltinConditions), s.Conditions...) {
+		switch condition.(type) {
+		case service.Expire, *service.Expire:
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,203 @@
+package auth
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lightningnetwork/lnd/lntypes"
+)
+
+// ErrSpent is returned when the token of a service paid per request was already used.
+var ErrSpent = errors.New("the token was already spent")
+
+// The minimum time between two prunings of the expired hashes of a store.
+const replayPruneInterval = time.Minute
+
+// ReplayStore records the payment hashes of the tokens spent, so that a token of a service
+// paid per request is accepted only once.
+type ReplayStore interface {
+	// Spend marks a payment hash spent until the expiry of its token, zero if it does not
+	// expire, and returns false if it already was.
+	Spend(hash lntypes.Hash, expiry time.Time) (bool, error)
+	// Release forgets a spent payment hash, so that its token can be used again, e.g. when
+	// the request it was spent on failed.
+	Release(hash lntypes.Hash) error
+}
+
+// The spent hashes of a store, with the expiry of their tokens.
+type spentHashes struct {
+	Clock func() time.Time // The time of the expiries, time.Now by default.
+
+	spent     map[lntypes.Hash]time.Time
+	lastPrune time.Time
+}
+
+func (s *spentHashes) now() time.Time {
+	if s.Clock != nil {
+		return s.Clock()
+	}
+	return time.Now()
+}
+
+// spend marks a hash spent, and forgets the hashes of the expired tokens, which cannot be
+// used again anyway.
+func (s *spentHashes) spend(hash lntypes.Hash, expiry time.Time) bool {
+	now := s.now()
+	if now.Sub(s.lastPrune) >= replayPruneInterval {
+		for spent, spentExpiry := range s.spent {
+			if !spentExpiry.IsZero() && now.After(spentExpiry) {
+				delete(s.spent, spent)
+			}
+		}
+		s.lastPrune = now
+	}
+
+	if _, ok := s.spent[hash]; ok {
+		return false
+	}
+	s.spent[hash] = expiry
+	return true
+}
+
+// MemoryReplayStore is a ReplayStore in memory, which forgets the spent tokens on restart.
+//
+// The hashes are kept until their tokens expire, and forever for the tokens without expiry.
+type MemoryReplayStore struct {
+	spentHashes
+	mu sync.Mutex
+}
+
+// Create an empty MemoryReplayStore.
+func NewMemoryReplayStore() *MemoryReplayStore {
+	return &MemoryReplayStore{spentHashes: spentHashes{spent: make(map[lntypes.Hash]time.Time)}}
+}
+
+func (s *MemoryReplayStore) Spend(hash lntypes.Hash, expiry time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.spend(hash, expiry), nil
+}
+
+func (s *MemoryReplayStore) Release(hash lntypes.Hash) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.spent, hash)
+	return nil
+}
+
+// FileReplayStore is a ReplayStore appending the spent payment hashes to a file, so that the
+// tokens stay spent across restarts.
+//
+// Each line is a spent hash, followed by the expiry of its token in Unix seconds if any, or a
+// released hash prefixed with "-". The hashes of the expired tokens are not loaded.
+type FileReplayStore struct {
+	spentHashes
+	mu   sync.Mutex
+	path string
+}
+
+// Open the FileReplayStore of a file, which is created on the first token spent if missing.
+func OpenFileReplayStore(path string) (*FileReplayStore, error) {
+	store := &FileReplayStore{path: path, spentHashes: spentHashes{spent: make(map[lntypes.Hash]time.Time)}}
+
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return store, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	now := store.now()
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+
+		text, released := strings.CutPrefix(fields[0], "-")
+		hash, err := lntypes.MakeHashFromStr(text)
+		if err != nil {
+			return nil, fmt.Errorf("invalid replay store %s:%d: %w", path, line, err)
+		}
+		if released {
+			delete(store.spent, hash)
+			continue
+		}
+
+		var expiry time.Time
+		if len(fields) > 1 {
+			seconds, err := strconv.ParseInt(fields[1], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid replay store %s:%d: %w", path, line, err)
+			}
+			if expiry = time.Unix(seconds, 0); now.After(expiry) {
+				continue
+			}
+		}
+		store.spent[hash] = expiry
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+// append writes a line to the file, and syncs it before the change is accepted.
+func (s *FileReplayStore) append(line string) error {
+	file, err := os.OpenFile(s.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := file.WriteString(line + "\n"); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// Spend marks a payment hash spent, and writes it to the file before accepting the token.
+func (s *FileReplayStore) Spend(hash lntypes.Hash, expiry time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.spent[hash]; ok {
+		return false, nil
+	}
+
+	line := hash.String()
+	if !expiry.IsZero() {
+		line += " " + strconv.FormatInt(expiry.Unix(), 10)
+	}
+	if err := s.append(line); err != nil {
+		return false, err
+	}
+
+	return s.spend(hash, expiry), nil
+}
+
+// Release forgets a spent payment hash, and writes it to the file.
+func (s *FileReplayStore) Release(hash lntypes.Hash) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.spent[hash]; !ok {
+		return nil
+	}
+	if err := s.append("-" + hash.String()); err != nil {
+		return err
+	}
+	delete(s.spent, hash)
+	return nil
+}
@@ -2,12 +2,12 @@ package challenge
 
 import (
 	"context"
+	"lsat/amount"
 )
 
 // Issues challenges in the form of invoices.
 type Challenger interface {
-	// The price is in satoshi.
-	Challenge(price uint64) (InvoiceResponse, error) // Create a challenge.
+	Challenge(ctx context.Context, price amount.MilliSatoshi) (InvoiceResponse, error) // Create a challenge.
 }
 
 // A simple Challenger.
@@ -16,15 +16,17 @@ type ChallengeFactory struct {
 }
 
 // Challenge generates a payment challenge for the specified price by creating a Lightning invoice
-func (challenger *ChallengeFactory) Challenge(price uint64) (InvoiceResponse, error) {
+//
+// The context of the caller bounds the call to the node.
+func (challenger *ChallengeFactory) Challenge(ctx context.Context, price amount.MilliSatoshi) (InvoiceResponse, error) {
 	// Build an invoice with the generated preimage, price, and other details.
 	invoice := CreateInvoiceRequest{
-		Amount:      uint64(price),
+		Amount:      price,
 		Description: "L402", // Ideally, we would have the service name.
 	}
 
 	// Create a Lightning invoice using the built parameters.
-	response, err := challenger.LightningNode.CreateInvoice(context.Background(), invoice)
+	response, err := challenger.LightningNode.CreateInvoice(ctx, invoice)
 
 	if err != nil {
 		return InvoiceResponse{}, err
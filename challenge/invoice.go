@@ -0,0 +1,131 @@
+package challenge
+
+import (
+	"fmt"
+	"lsat/amount"
+	"strings"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/lightningnetwork/lnd/lntypes"
+	"github.com/lightningnetwork/lnd/zpay32"
+)
+
+// InvoiceCheck names a check performed on an invoice before paying it.
+type InvoiceCheck string
+
+const (
+	CheckDecoding    InvoiceCheck = "decoding"
+	CheckNetwork     InvoiceCheck = "network"
+	CheckAmount      InvoiceCheck = "amount"
+	CheckPaymentHash InvoiceCheck = "payment_hash"
+	CheckExpiry      InvoiceCheck = "expiry"
+)
+
+// InvoiceError is returned when an invoice fails one of the checks.
+type InvoiceError struct {
+	Check  InvoiceCheck // The check that failed.
+	Reason string       // A human readable explanation.
+}
+
+func (e *InvoiceError) Error() string {
+	return fmt.Sprintf("invoice rejected by the %s check: %s", e.Check, e.Reason)
+}
+
+func invoiceError(check InvoiceCheck, format string, args ...any) *InvoiceError {
+	return &InvoiceError{Check: check, Reason: fmt.Sprintf(format, args...)}
+}
+
+// InvoicePolicy is the set of rules an invoice must follow to be paid.
+type InvoicePolicy struct {
+	// MaxAmount is the maximum amount accepted, zero disables the limit.
+	MaxAmount amount.MilliSatoshi
+	// Network is the network of the node paying the invoice, mainnet if nil.
+	Network *chaincfg.Params
+	// Clock is used to check the expiry of the invoice, defaults to time.Now.
+	Clock func() time.Time
+}
+
+func (policy InvoicePolicy) network() *chaincfg.Params {
+	if policy.Network != nil {
+		return policy.Network
+	}
+	return &chaincfg.MainNetParams
+}
+
+func (policy InvoicePolicy) now() time.Time {
+	if policy.Clock != nil {
+		return policy.Clock()
+	}
+	return time.Now()
+}
+
+// The networks that can be recognized from the prefix of an invoice, longest prefix first.
+var invoiceNetworks = []struct {
+	prefix string
+	params *chaincfg.Params
+}{
+	{"lnbcrt", &chaincfg.RegressionNetParams},
+	{"lntbs", &chaincfg.SigNetParams},
+	{"lntb", &chaincfg.TestNet3Params},
+	{"lnsb", &chaincfg.SimNetParams},
+	{"lnbc", &chaincfg.MainNetParams},
+}
+
+// InvoiceNetwork returns the network an invoice was issued for.
+func InvoiceNetwork(invoice string) (*chaincfg.Params, error) {
+	invoice = strings.ToLower(invoice)
+	for _, network := range invoiceNetworks {
+		if strings.HasPrefix(invoice, network.prefix) {
+			return network.params, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown invoice network")
+}
+
+// DecodeInvoice decodes a BOLT11 invoice issued for the given network.
+func DecodeInvoice(invoice string, network *chaincfg.Params) (*zpay32.Invoice, error) {
+	invoiceNetwork, err := InvoiceNetwork(invoice)
+	if err != nil {
+		return nil, invoiceError(CheckDecoding, "%v", err)
+	}
+
+	if invoiceNetwork.Name != network.Name {
+		return nil, invoiceError(CheckNetwork, "the invoice is for %s, expected %s", invoiceNetwork.Name, network.Name)
+	}
+
+	decoded, err := zpay32.Decode(invoice, network)
+	if err != nil {
+		return nil, invoiceError(CheckDecoding, "%v", err)
+	}
+
+	return decoded, nil
+}
+
+// VerifyInvoice decodes a BOLT11 invoice and checks it against the policy and the expected payment hash.
+func VerifyInvoice(invoice string, paymentHash lntypes.Hash, policy InvoicePolicy) (*zpay32.Invoice, error) {
+	decoded, err := DecodeInvoice(invoice, policy.network())
+	if err != nil {
+		return nil, err
+	}
+
+	if decoded.PaymentHash == nil || lntypes.Hash(*decoded.PaymentHash) != paymentHash {
+		return nil, invoiceError(CheckPaymentHash, "the invoice does not pay for the payment hash %s", paymentHash)
+	}
+
+	if decoded.MilliSat == nil {
+		return nil, invoiceError(CheckAmount, "the invoice has no amount")
+	}
+
+	price := amount.FromLnwire(*decoded.MilliSat)
+	if policy.MaxAmount > 0 && price > policy.MaxAmount {
+		return nil, invoiceError(CheckAmount, "the amount %s exceeds the maximum of %s", price, policy.MaxAmount)
+	}
+
+	expiry := decoded.Timestamp.Add(decoded.Expiry())
+	if !policy.now().Before(expiry) {
+		return nil, invoiceError(CheckExpiry, "the invoice expired at %s", expiry.Format(time.RFC3339))
+	}
+
+	return decoded, nil
+}
@@ -2,19 +2,25 @@ package challenge
 
 import (
 	"context"
+	"errors"
+	"lsat/amount"
 
 	"github.com/lightningnetwork/lnd/lntypes"
 )
 
+// ErrPaymentInFlight is returned, wrapped, when the outcome of a payment is unknown, e.g. when
+// the response of the node was lost. The payment may still settle.
+var ErrPaymentInFlight = errors.New("the outcome of the payment is unknown")
+
 type CreateInvoiceRequest struct {
 	Description     string
 	DescriptionHash lntypes.Hash
-	Amount          uint64
+	Amount          amount.MilliSatoshi
 	Udata           string
 }
 
 type PayInvoiceRequest struct {
-	Amount  uint64
+	Amount  amount.MilliSatoshi // Overrides the amount of the invoice if set.
 	Invoice string
 }
 
@@ -22,6 +28,7 @@ type InvoiceResponse struct {
 	// Preimage    lntypes.Preimage
 	PaymentHash lntypes.Hash
 	Invoice     string
+	Amount      amount.MilliSatoshi
 }
 
 type PayInvoiceResponse struct {
@@ -38,3 +45,15 @@ type LightningNode interface {
 	// CreateInvoice creates an invoice for receiving payments on the Lightning Network.
 	CreateInvoice(context.Context, CreateInvoiceRequest) (InvoiceResponse, error)
 }
+
+// An invoice paid to the node.
+type SettledInvoice struct {
+	PaymentHash lntypes.Hash
+	Amount      amount.MilliSatoshi
+}
+
+// A Lightning Network node notifying the invoices it settles.
+type InvoiceSubscriber interface {
+	// SubscribeInvoices delivers the settled invoices until the context is done.
+	SubscribeInvoices(context.Context) (<-chan SettledInvoice, error)
+}
@@ -0,0 +1,182 @@
+package challenge
+
+import (
+	"context"
+	"errors"
+	"lsat/amount"
+	"sync"
+	"time"
+
+	"github.com/lightningnetwork/lnd/lntypes"
+)
+
+var (
+	ErrUnknownChallenge = errors.New("no pending challenge for this payment hash")
+	ErrUnderpaid        = errors.New("the amount received is lower than the amount of the challenge")
+)
+
+const (
+	// The time the challenges are kept by default.
+	defaultChallengeTTL = 24 * time.Hour
+	// The minimum time between two prunings of the challenges when one is tracked.
+	pruneInterval = time.Minute
+)
+
+// The state of a challenge issued to a client.
+type ChallengeStatus struct {
+	PaymentHash lntypes.Hash
+	Amount      amount.MilliSatoshi
+	IssuedAt    time.Time
+	Paid        bool
+	PaidAt      time.Time
+	Received    amount.MilliSatoshi
+}
+
+// PendingChallenges keeps track of the challenges issued until they are settled.
+//
+// Settlement events, such as the payment_received events of phoenixd, mark the
+// challenges paid without polling the Lightning node.
+type PendingChallenges struct {
+	// Clock returns the current time, time.Now by default.
+	Clock func() time.Time
+	// OnSettle is called once for each challenge settled, if set, e.g. to record metrics.
+	OnSettle func(ChallengeStatus)
+	// TTL is how long the challenges are kept after they are issued, 24 hours by default.
+	// The older challenges are pruned as new ones are tracked.
+	TTL time.Duration
+
+	mu         sync.Mutex
+	challenges map[lntypes.Hash]*ChallengeStatus
+	lastPrune  time.Time
+}
+
+// Create an empty PendingChallenges.
+func NewPendingChallenges() *PendingChallenges {
+	return &PendingChallenges{challenges: make(map[lntypes.Hash]*ChallengeStatus)}
+}
+
+func (p *PendingChallenges) now() time.Time {
+	if p.Clock != nil {
+		return p.Clock()
+	}
+	return time.Now()
+}
+
+func (p *PendingChallenges) ttl() time.Duration {
+	if p.TTL > 0 {
+		return p.TTL
+	}
+	return defaultChallengeTTL
+}
+
+// Track records a challenge that was issued, and prunes the expired ones.
+func (p *PendingChallenges) Track(invoice InvoiceResponse) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := p.now()
+	if now.Sub(p.lastPrune) >= pruneInterval {
+		p.prune(now.Add(-p.ttl()))
+		p.lastPrune = now
+	}
+
+	p.challenges[invoice.PaymentHash] = &ChallengeStatus{
+		PaymentHash: invoice.PaymentHash,
+		Amount:      invoice.Amount,
+		IssuedAt:    now,
+	}
+}
+
+// Settle marks a challenge paid.
+//
+// Settling a challenge twice is not an error, the first settlement is kept.
+func (p *PendingChallenges) Settle(paymentHash lntypes.Hash, received amount.MilliSatoshi) error {
+	p.mu.Lock()
+	status, ok := p.challenges[paymentHash]
+	if !ok {
+		p.mu.Unlock()
+		return ErrUnknownChallenge
+	}
+	if status.Paid {
+		p.mu.Unlock()
+		return nil
+	}
+	if received < status.Amount {
+		p.mu.Unlock()
+		return ErrUnderpaid
+	}
+
+	status.Paid = true
+	status.PaidAt = p.now()
+	status.Received = received
+	settled := *status
+	p.mu.Unlock()
+
+	// The hook is called without the lock, so that it may query the challenges.
+	if p.OnSettle != nil {
+		p.OnSettle(settled)
+	}
+	return nil
+}
+
+// Status returns the state of a challenge, and false if it is not tracked.
+func (p *PendingChallenges) Status(paymentHash lntypes.Hash) (ChallengeStatus, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	status, ok := p.challenges[paymentHash]
+	if !ok {
+		return ChallengeStatus{}, false
+	}
+	return *status, true
+}
+
+// IsPaid reports whether a challenge was settled.
+func (p *PendingChallenges) IsPaid(paymentHash lntypes.Hash) bool {
+	status, ok := p.Status(paymentHash)
+	return ok && status.Paid
+}
+
+// Prune forgets the challenges issued before a time, and returns how many were removed.
+func (p *PendingChallenges) Prune(before time.Time) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.prune(before)
+}
+
+func (p *PendingChallenges) prune(before time.Time) int {
+	removed := 0
+	for hash, status := range p.challenges {
+		if status.IssuedAt.Before(before) {
+			delete(p.challenges, hash)
+			removed++
+		}
+	}
+	return removed
+}
+
+// Follow settles the challenges as the invoices are settled, until the channel is closed.
+func (p *PendingChallenges) Follow(invoices <-chan SettledInvoice) {
+	for invoice := range invoices {
+		// Invoices that are not challenges are ignored.
+		p.Settle(invoice.PaymentHash, invoice.Amount)
+	}
+}
+
+// A Challenger recording the challenges it issues as pending.
+type TrackingChallenger struct {
+	Challenger
+	Pending *PendingChallenges
+}
+
+// Challenge issues a challenge with the wrapped Challenger and tracks it.
+func (challenger *TrackingChallenger) Challenge(ctx context.Context, price amount.MilliSatoshi) (InvoiceResponse, error) {
+	response, err := challenger.Challenger.Challenge(ctx, price)
+	if err != nil {
+		return InvoiceResponse{}, err
+	}
+
+	challenger.Pending.Track(response)
+	return response, nil
+}
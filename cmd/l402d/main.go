@@ -0,0 +1,70 @@
+// l402d serves an L402 proxy from a configuration file.
+//
+//	l402d -config l402.yaml -listen :8080 [-tls-cert cert.pem -tls-key key.pem] [-log-level debug]
+//
+// It shuts down gracefully on SIGTERM or SIGINT, and reloads the configuration on SIGHUP.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"lsat/daemon"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func main() {
+	listen := flag.String("listen", ":8080", "The address to listen on")
+	configPath := flag.String("config", "l402.yaml", "The path of the YAML or TOML configuration file")
+	certFile := flag.String("tls-cert", "", "The TLS certificate, served over HTTP if empty")
+	keyFile := flag.String("tls-key", "", "The key of the TLS certificate")
+	logLevel := flag.String("log-level", "info", "The log level: debug, info, warn or error")
+	shutdownTimeout := flag.Duration("shutdown-timeout", 30*time.Second, "The time given to the requests in flight on shutdown")
+	flag.Parse()
+
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(*logLevel)); err != nil {
+		fmt.Fprintln(os.Stderr, "invalid log level:", *logLevel)
+		os.Exit(2)
+	}
+	if (*certFile == "") != (*keyFile == "") {
+		fmt.Fprintln(os.Stderr, "-tls-cert and -tls-key must be set together")
+		os.Exit(2)
+	}
+
+	logger := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level}))
+	slog.SetDefault(logger)
+	if level > slog.LevelDebug {
+		gin.SetMode(gin.ReleaseMode)
+	}
+
+	d := daemon.New(*configPath)
+	d.CertFile, d.KeyFile = *certFile, *keyFile
+	d.ShutdownTimeout = *shutdownTimeout
+	d.Logger = logger
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+
+	// Reload the configuration on SIGHUP, keeping the previous one if it is invalid.
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	go func() {
+		for range hangup {
+			if err := d.Load(); err != nil {
+				logger.Error("reload failed, keeping the previous configuration", "error", err)
+			}
+		}
+	}()
+
+	if err := d.ListenAndServe(ctx, *listen); err != nil {
+		logger.Error("server stopped", "error", err)
+		os.Exit(1)
+	}
+}
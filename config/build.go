@@ -0,0 +1,483 @@
+package config
+
+import (
+	"encoding/hex"
+	"fmt"
+	"lsat/amount"
+	"lsat/auth"
+	"lsat/challenge"
+	"lsat/macaroon"
+	"lsat/metrics"
+	"lsat/mock"
+	"lsat/phoenixd"
+	"lsat/phoenixd/webhook"
+	"lsat/proxy"
+	"lsat/rates"
+	"lsat/secrets"
+	"lsat/service"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// How long an exchange rate is reused by default.
+const defaultRatesTTL = time.Minute
+
+// Config is a validated configuration, ready to serve.
+type Config struct {
+	Services []service.Service
+	// ServiceFiles are the configurations the Services were created from.
+	ServiceFiles []ServiceFile
+	// Manager holds the Services, and is shared by the minters of the configuration.
+	Manager    *service.Config
+	Routes     []proxy.Route
+	Challenger challenge.Challenger
+	// Rates convert the fiat prices of the Services, if set.
+	Rates   rates.RateProvider
+	Secrets secrets.SecretStore
+	// SecretsFile is the configuration the Secrets were created from.
+	SecretsFile SecretsFile
+	// Webhook receives the settlements of phoenixd, if a webhook secret is set.
+	Webhook *webhook.Handler
+	// Pending tracks the challenges issued, when the settlements are received.
+	Pending *challenge.PendingChallenges
+	// AdminToken is the bearer token of the admin API, which is disabled if empty.
+	AdminToken string
+	// AdminState is the file recording the versions of the services changed by the admin API.
+	AdminState string
+	// Metrics record the activity of the server, if enabled.
+	Metrics *metrics.Metrics
+	// MetricsToken is the bearer token to read the Metrics.
+	MetricsToken string
+	// Replay records the tokens spent on the services paid per request.
+	Replay auth.ReplayStore
+	// ReplayFile is the file of the Replay store, which is in memory if empty.
+	ReplayFile string
+}
+
+// Minter creates the minter of the configuration.
+func (c *Config) Minter() auth.Minter {
+	manager := c.Manager
+	if manager == nil {
+		manager = service.NewConfig(c.Services...)
+	}
+	minter := auth.NewMinter(manager, c.Secrets, c.Challenger)
+	if c.Metrics != nil {
+		minter = minter.WithObserver(c.Metrics)
+	}
+	if c.Replay != nil {
+		minter = minter.WithReplayStore(c.Replay)
+	}
+	if c.Rates != nil {
+		minter = minter.WithRateProvider(c.Rates)
+	}
+	return minter
+}
+
+// Server creates the proxy server of the configuration.
+func (c *Config) Server() *proxy.L402ProxyServer {
+	minter := c.Minter()
+	return &proxy.L402ProxyServer{Minter: &minter, Pending: c.Pending, Webhook: c.Webhook, Routes: c.Routes, Metrics: c.Metrics, MetricsToken: c.MetricsToken}
+}
+
+// The errors found while building a configuration.
+type validator struct {
+	positions positions
+	errs      Errors
+}
+
+func (v *validator) fail(path string, format string, args ...any) {
+	v.errs = append(v.errs, &Error{Line: v.positions.line(path), Path: path, Err: fmt.Errorf(format, args...)})
+}
+
+// build validates the file and creates its configuration, with the state of the previous one if any.
+func (file File) build(p positions, previous *Config) (*Config, error) {
+	v := &validator{positions: p}
+	config := &Config{}
+	config.Rates = v.rates("rates", file.Rates)
+
+	ids := make(map[service.ServiceID]bool)
+	for i, s := range file.Services {
+		path := index("services", i)
+		built, ok := v.service(path, s)
+		if !ok {
+			continue
+		}
+		if s.FiatPrice != "" && file.Rates == (RatesFile{}) {
+			v.fail(field(path, "fiat_price"), "the fiat price requires a rates section")
+			continue
+		}
+		if ids[built.Id()] {
+			v.fail(path, "duplicate service %s", built.Id())
+			continue
+		}
+		ids[built.Id()] = true
+		config.Services = append(config.Services, built)
+		config.ServiceFiles = append(config.ServiceFiles, s)
+	}
+	config.Manager = service.NewConfig(config.Services...)
+
+	for i, r := range file.Routes {
+		if route, ok := v.route(index("routes", i), r, ids); ok {
+			config.Routes = append(config.Routes, route)
+		}
+	}
+
+	if file.Metrics.Enabled {
+		config.Metrics = metrics.New()
+		if previous != nil && previous.Metrics != nil {
+			config.Metrics = previous.Metrics
+		}
+	}
+
+	config.Challenger, config.Webhook = v.lightning("lightning", file.Lightning)
+	if factory, ok := config.Challenger.(*challenge.ChallengeFactory); ok && config.Metrics != nil {
+		factory.LightningNode = metrics.NewNode(factory.LightningNode, config.Metrics)
+	}
+	if config.Webhook != nil {
+		if previous != nil && previous.Pending != nil {
+			// The carried challenges keep their hook, recording into the carried metrics if any.
+			config.Pending = previous.Pending
+		} else {
+			config.Pending = challenge.NewPendingChallenges()
+			if config.Metrics != nil {
+				config.Pending.OnSettle = config.Metrics.Settled
+			}
+		}
+		config.Challenger = &challenge.TrackingChallenger{Challenger: config.Challenger, Pending: config.Pending}
+	}
+	config.SecretsFile = file.Secrets
+	if previous != nil && previous.Secrets != nil && previous.SecretsFile == config.SecretsFile {
+		// The tokens sold before the reload stay valid, even with generated secrets.
+		config.Secrets = previous.Secrets
+	} else {
+		config.Secrets = v.secrets("secrets", file.Secrets)
+	}
+	config.ReplayFile = file.Replay.File
+	if previous != nil && previous.Replay != nil && previous.ReplayFile == config.ReplayFile {
+		// The tokens spent before the reload stay spent.
+		config.Replay = previous.Replay
+	} else {
+		config.Replay = v.replay(field("replay", "file"), file.Replay)
+	}
+
+	config.AdminToken, config.AdminState = os.ExpandEnv(file.Admin.Token), file.Admin.State
+	if config.AdminState != "" && config.AdminToken == "" {
+		v.fail(field("admin", "token"), "the admin API requires a token")
+	} else if config.AdminToken != "" && config.AdminState == "" {
+		v.fail(field("admin", "state"), "the admin API requires a state file")
+	}
+
+	if config.MetricsToken = os.ExpandEnv(file.Metrics.Token); config.MetricsToken == "" {
+		config.MetricsToken = config.AdminToken
+	}
+	if config.Metrics != nil && config.MetricsToken == "" {
+		v.fail(field("metrics", "token"), "the metrics require a token, or the admin token")
+	}
+
+	if len(v.errs) > 0 {
+		return nil, v.errs
+	}
+	return config, nil
+}
+
+// Service validates the configuration of a service and creates it.
+func (s ServiceFile) Service() (service.Service, error) {
+	v := &validator{positions: positions{}}
+	built, ok := v.service("", s)
+	if !ok {
+		return service.Service{}, v.errs
+	}
+	return built, nil
+}
+
+func (v *validator) service(path string, s ServiceFile) (service.Service, bool) {
+	valid := true
+	if s.Name == "" || strings.ContainsAny(s.Name, ": ") {
+		v.fail(field(path, "name"), "the name is required and cannot contain spaces or colons")
+		valid = false
+	}
+	if s.Tier < math.MinInt8 || s.Tier > math.MaxInt8 {
+		v.fail(field(path, "tier"), "the tier must be between %d and %d", math.MinInt8, math.MaxInt8)
+		valid = false
+	}
+
+	var price amount.MilliSatoshi
+	var fiatPrice rates.Price
+	var err error
+	if s.FiatPrice != "" {
+		if s.Price != "" {
+			v.fail(path, "price and fiat_price are exclusive")
+			valid = false
+		} else if fiatPrice, err = parseFiatPrice(s.FiatPrice); err != nil {
+			v.fail(field(path, "fiat_price"), "%v", err)
+			valid = false
+		}
+	} else if price, err = amount.Parse(s.Price); err != nil {
+		v.fail(field(path, "price"), "%v", err)
+		valid = false
+	}
+
+	built := service.NewService(s.Name, price)
+	built.FiatPrice = fiatPrice
+	built.Tier = service.Tier(s.Tier)
+	built.PerRequest = s.PerRequest
+
+	for i, c := range s.Caveats {
+		if caveat, ok := v.caveat(index(field(path, "caveats"), i), c); ok {
+			built.FirstPartyCaveats = append(built.FirstPartyCaveats, caveat)
+		} else {
+			valid = false
+		}
+	}
+
+	for i, c := range s.Conditions {
+		if condition, ok := v.condition(index(field(path, "conditions"), i), c); ok {
+			built.Conditions = append(built.Conditions, condition)
+		} else {
+			valid = false
+		}
+	}
+
+	return built, valid
+}
+
+// parseFiatPrice parses an amount in the major unit of a currency, e.g. 0.05 USD.
+func parseFiatPrice(s string) (rates.Price, error) {
+	value, code, ok := strings.Cut(strings.TrimSpace(s), " ")
+	if !ok || len(code) != 3 || strings.ToUpper(code) != code || strings.Trim(code, "ABCDEFGHIJKLMNOPQRSTUVWXYZ") != "" {
+		return rates.Price{}, fmt.Errorf("invalid fiat price %q, expected an amount and a currency code, e.g. 0.05 USD", s)
+	}
+
+	currency := rates.Currency(code)
+	whole, fraction, _ := strings.Cut(value, ".")
+	if len(fraction) > currency.Decimals() {
+		return rates.Price{}, fmt.Errorf("invalid fiat price %q, %s has %d decimals", s, currency, currency.Decimals())
+	}
+
+	minor, err := strconv.ParseUint(whole+fraction+strings.Repeat("0", currency.Decimals()-len(fraction)), 10, 64)
+	if err != nil || whole == "" {
+		return rates.Price{}, fmt.Errorf("invalid fiat price %q, expected an amount and a currency code, e.g. 0.05 USD", s)
+	}
+	return rates.Price{Amount: minor, Currency: currency}, nil
+}
+
+func (v *validator) caveat(path string, c CaveatFile) (service.Caveat, bool) {
+	switch c.Type {
+	case "expire", "not_before":
+		delay, err := time.ParseDuration(c.Delay)
+		if err != nil || delay <= 0 {
+			v.fail(field(path, "delay"), "the %s caveat requires a positive delay, e.g. 1h", c.Type)
+			return nil, false
+		}
+		if c.Type == "expire" {
+			return service.Expire{Delay: delay}, true
+		}
+		return service.NotBefore{Delay: delay}, true
+	case "generate_id":
+		if c.Key == "" {
+			v.fail(field(path, "key"), "the generate_id caveat requires a key")
+			return nil, false
+		}
+		return service.GenerateID{Name: c.Key}, true
+	case "static":
+		if c.Key == "" || c.Value == "" {
+			v.fail(path, "the static caveat requires a key and a value")
+			return nil, false
+		}
+		return macaroon.NewCaveat(c.Key, c.Value), true
+	case "route", "method":
+		if c.Value == "" {
+			v.fail(field(path, "value"), "the %s caveat requires a value", c.Type)
+			return nil, false
+		}
+		if c.Type == "route" {
+			return service.Route{Pattern: c.Value}, true
+		}
+		return service.Methods{Methods: strings.Split(c.Value, ",")}, true
+	}
+
+	v.fail(field(path, "type"), "unknown caveat type %q, expected expire, not_before, generate_id, static, route or method", c.Type)
+	return nil, false
+}
+
+func (v *validator) condition(path string, c ConditionFile) (service.Condition, bool) {
+	switch c.Type {
+	case "expire":
+		return service.Expire{}, true
+	case "not_before":
+		return service.NotBefore{}, true
+	case "capabilities", "unique":
+		if c.Key == "" {
+			v.fail(field(path, "key"), "the %s condition requires a key", c.Type)
+			return nil, false
+		}
+		if c.Type == "capabilities" {
+			return service.Capabilities{Key: c.Key}, true
+		}
+		return service.UniqueKey{Key: c.Key}, true
+	}
+
+	v.fail(field(path, "type"), "unknown condition type %q, expected expire, not_before, capabilities or unique", c.Type)
+	return nil, false
+}
+
+func (v *validator) route(path string, r RouteFile, services map[service.ServiceID]bool) (proxy.Route, bool) {
+	id, err := service.ParseServiceID(r.Service)
+	if err != nil {
+		v.fail(field(path, "service"), "%v", err)
+		return proxy.Route{}, false
+	}
+	if !services[id] {
+		v.fail(field(path, "service"), "unknown service %s", id)
+		return proxy.Route{}, false
+	}
+
+	route, err := proxy.NewRoute(id, r.Path, r.Upstream)
+	if err != nil {
+		v.fail(path, "%v", err)
+		return proxy.Route{}, false
+	}
+
+	if r.Timeout != "" {
+		if route.Timeout, err = time.ParseDuration(r.Timeout); err != nil {
+			v.fail(field(path, "timeout"), "%v", err)
+			return proxy.Route{}, false
+		}
+	}
+
+	if r.Metered.Bytes < 0 {
+		v.fail(field(field(path, "metered"), "bytes"), "the bytes must be positive")
+		return proxy.Route{}, false
+	}
+	route.Metered.Bytes = r.Metered.Bytes
+	if r.Metered.Duration != "" {
+		if route.Metered.Duration, err = time.ParseDuration(r.Metered.Duration); err != nil || route.Metered.Duration <= 0 {
+			v.fail(field(field(path, "metered"), "duration"), "the duration must be positive, e.g. 1m")
+			return proxy.Route{}, false
+		}
+	}
+
+	if len(r.Headers) > 0 {
+		route.Headers = make(http.Header)
+		for key, value := range r.Headers {
+			route.Headers.Set(key, os.ExpandEnv(value))
+		}
+	}
+	return route, true
+}
+
+func (v *validator) lightning(path string, l LightningFile) (challenge.Challenger, *webhook.Handler) {
+	switch l.Backend {
+	case "mock":
+		return mock.NewChallenger(), nil
+	case "phoenixd":
+		if l.URL == "" {
+			v.fail(field(path, "url"), "the phoenixd backend requires a url")
+			return nil, nil
+		}
+
+		client := phoenixd.NewPhoenixClient(l.URL, os.ExpandEnv(l.Password))
+		challenger := &challenge.ChallengeFactory{LightningNode: &phoenixd.PhoenixNode{Client: client}}
+		if secret := os.ExpandEnv(l.WebhookSecret); secret != "" {
+			return challenger, webhook.NewHandler(secret)
+		}
+		return challenger, nil
+	}
+
+	v.fail(field(path, "backend"), "unknown lightning backend %q, expected mock or phoenixd", l.Backend)
+	return nil, nil
+}
+
+func (v *validator) secrets(path string, s SecretsFile) secrets.SecretStore {
+	root, rootPath := os.ExpandEnv(s.Root), field(path, "root")
+	if s.RootFile != "" {
+		if root != "" {
+			v.fail(path, "root and root_file are exclusive")
+			return nil
+		}
+
+		content, err := os.ReadFile(s.RootFile)
+		if err != nil {
+			v.fail(field(path, "root_file"), "%v", err)
+			return nil
+		}
+		root, rootPath = strings.TrimSpace(string(content)), field(path, "root_file")
+	}
+
+	if root == "" {
+		return secrets.NewSecretFactory()
+	}
+
+	decoded, err := hex.DecodeString(root)
+	if err == nil {
+		var secret secrets.Secret
+		if secret, err = secrets.MakeSecret(decoded); err == nil {
+			store := secrets.NewStoreFromSecret(secret)
+			return &store
+		}
+	}
+
+	v.fail(rootPath, "the root secret must be %d hex encoded bytes", secrets.SecretSize)
+	return nil
+}
+
+func (v *validator) rates(path string, r RatesFile) rates.RateProvider {
+	if r == (RatesFile{}) {
+		return nil
+	}
+
+	var provider rates.RateProvider
+	switch {
+	case r.URL != "" && r.File != "":
+		v.fail(path, "url and file are exclusive")
+		return nil
+	case r.URL != "":
+		if r.Field == "" {
+			v.fail(field(path, "field"), "the rates url requires the field of the price, e.g. data.amount")
+			return nil
+		}
+		provider = rates.NewHTTPProvider(r.URL, r.Field)
+	case r.File != "":
+		if _, err := os.Stat(r.File); err != nil {
+			v.fail(field(path, "file"), "%v", err)
+			return nil
+		}
+		provider = rates.NewFileProvider(r.File)
+	default:
+		v.fail(path, "the rates require a url or a file")
+		return nil
+	}
+
+	ttl, maxAge := defaultRatesTTL, time.Duration(0)
+	var err error
+	if r.TTL != "" {
+		if ttl, err = time.ParseDuration(r.TTL); err != nil || ttl <= 0 {
+			v.fail(field(path, "ttl"), "the ttl must be positive, e.g. 1m")
+			return nil
+		}
+	}
+	if r.MaxAge != "" {
+		if maxAge, err = time.ParseDuration(r.MaxAge); err != nil || maxAge <= 0 {
+			v.fail(field(path, "max_age"), "the max_age must be positive, e.g. 10m")
+			return nil
+		}
+	}
+	return rates.NewCachedProvider(provider, ttl, maxAge)
+}
+
+func (v *validator) replay(path string, r ReplayFile) auth.ReplayStore {
+	if r.File == "" {
+		return auth.NewMemoryReplayStore()
+	}
+
+	store, err := auth.OpenFileReplayStore(r.File)
+	if err != nil {
+		v.fail(path, "%v", err)
+		return nil
+	}
+	return store
+}
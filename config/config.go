@@ -0,0 +1,275 @@
+// Package config loads the services, routes, Lightning backend, exchange rates and secret
+// store of a server from a YAML or TOML file.
+//
+//	services:
+//	  - name: image
+//	    price: 100sat
+//	    caveats:
+//	      - { type: expire, delay: 1h }
+//	    conditions:
+//	      - { type: expire }
+//	  - name: video
+//	    fiat_price: 0.05 USD
+//	routes:
+//	  - { service: "image:0", path: /images, upstream: "http://localhost:3000/v1" }
+//	lightning:
+//	  backend: phoenixd
+//	  url: http://localhost:9740
+//	  password: ${PHOENIXD_PASSWORD}
+//	rates:
+//	  url: https://api.coinbase.com/v2/prices/BTC-{currency}/spot
+//	  field: data.amount
+//	secrets:
+//	  root_file: /var/lib/l402/root.key
+//
+// The values of the passwords and secrets may reference environment variables.
+package config
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// Format is the format of a configuration file.
+type Format string
+
+const (
+	YAML Format = "yaml"
+	TOML Format = "toml"
+)
+
+var ErrUnknownFormat = errors.New("unknown configuration format, expected .yaml, .yml or .toml")
+
+// File is the content of a configuration file.
+type File struct {
+	Services  []ServiceFile `yaml:"services" toml:"services"`
+	Routes    []RouteFile   `yaml:"routes" toml:"routes"`
+	Lightning LightningFile `yaml:"lightning" toml:"lightning"`
+	Secrets   SecretsFile   `yaml:"secrets" toml:"secrets"`
+	Admin     AdminFile     `yaml:"admin" toml:"admin"`
+	Metrics   MetricsFile   `yaml:"metrics" toml:"metrics"`
+	Replay    ReplayFile    `yaml:"replay" toml:"replay"`
+	Rates     RatesFile     `yaml:"rates" toml:"rates"`
+}
+
+// ServiceFile is the configuration of a service.
+type ServiceFile struct {
+	Name       string          `yaml:"name" toml:"name" json:"name"`
+	Tier       int             `yaml:"tier" toml:"tier" json:"tier"`
+	Price      string          `yaml:"price" toml:"price" json:"price"` // An amount with its unit, e.g. 100sat.
+	Caveats    []CaveatFile    `yaml:"caveats" toml:"caveats" json:"caveats,omitempty"`
+	Conditions []ConditionFile `yaml:"conditions" toml:"conditions" json:"conditions,omitempty"`
+	PerRequest bool            `yaml:"per_request" toml:"per_request" json:"per_request,omitempty"` // Each token pays for a single request.
+	// FiatPrice is a price in a currency instead, e.g. 0.05 USD, converted with the rates.
+	FiatPrice string `yaml:"fiat_price" toml:"fiat_price" json:"fiat_price,omitempty"`
+}
+
+// CaveatFile is a first-party caveat added to the tokens of a service.
+//
+// The types are expire and not_before, with a delay, generate_id, with a key, static, with a
+// key and a value, and route and method, with a value, e.g. /images/public/* or GET,HEAD.
+type CaveatFile struct {
+	Type  string `yaml:"type" toml:"type" json:"type,omitempty"`
+	Key   string `yaml:"key" toml:"key" json:"key,omitempty"`
+	Value string `yaml:"value" toml:"value" json:"value,omitempty"`
+	Delay string `yaml:"delay" toml:"delay" json:"delay,omitempty"` // A duration, e.g. 1h30m.
+}
+
+// ConditionFile is a condition checked on the caveats of the tokens of a service.
+//
+// The types are expire, not_before, and capabilities and unique, with a key.
+type ConditionFile struct {
+	Type string `yaml:"type" toml:"type" json:"type,omitempty"`
+	Key  string `yaml:"key" toml:"key" json:"key,omitempty"`
+}
+
+// RouteFile is a route to an upstream backend.
+type RouteFile struct {
+	Service  string            `yaml:"service" toml:"service"` // The ID of the service, e.g. image:0.
+	Path     string            `yaml:"path" toml:"path"`
+	Upstream string            `yaml:"upstream" toml:"upstream"`
+	Timeout  string            `yaml:"timeout" toml:"timeout"`
+	Headers  map[string]string `yaml:"headers" toml:"headers"`
+	Metered  MeteredFile       `yaml:"metered" toml:"metered"`
+}
+
+// MeteredFile charges the event streams of a route by time or bytes.
+//
+// Each payment is the price of the service, and buys the bytes, the duration, or both.
+type MeteredFile struct {
+	Bytes    int64  `yaml:"bytes" toml:"bytes"`
+	Duration string `yaml:"duration" toml:"duration"` // A duration, e.g. 1m.
+}
+
+// LightningFile is the Lightning backend issuing the invoices.
+type LightningFile struct {
+	Backend       string `yaml:"backend" toml:"backend"` // mock or phoenixd.
+	URL           string `yaml:"url" toml:"url"`
+	Password      string `yaml:"password" toml:"password"`
+	WebhookSecret string `yaml:"webhook_secret" toml:"webhook_secret"`
+}
+
+// SecretsFile is the secret store of the macaroons.
+//
+// Without a root secret, a random one is generated and the tokens are lost on restart.
+type SecretsFile struct {
+	Root     string `yaml:"root" toml:"root"`           // The root secret, hex encoded.
+	RootFile string `yaml:"root_file" toml:"root_file"` // A file holding the root secret, hex encoded.
+}
+
+// AdminFile enables the admin API, managing the services at runtime.
+type AdminFile struct {
+	Token string `yaml:"token" toml:"token"` // The bearer token of the API.
+	State string `yaml:"state" toml:"state"` // The file recording the versions of the services.
+}
+
+// MetricsFile enables the metrics, served on /metrics in the Prometheus text format.
+type MetricsFile struct {
+	Enabled bool   `yaml:"enabled" toml:"enabled"`
+	Token   string `yaml:"token" toml:"token"` // The bearer token to read the metrics, the admin token by default.
+}
+
+// ReplayFile is the store of the tokens spent on the services paid per request.
+//
+// Without a file, the spent tokens are kept in memory and can be used again after a restart.
+type ReplayFile struct {
+	File string `yaml:"file" toml:"file"` // The file recording the payment hashes spent.
+}
+
+// RatesFile is the provider of the exchange rates converting the fiat prices of the services.
+//
+// The rates are fetched from a JSON endpoint, e.g. the spot price of Coinbase, or read from a
+// file mapping each currency to the price of one bitcoin, e.g. {"USD": 65000.5}.
+type RatesFile struct {
+	URL    string `yaml:"url" toml:"url"`         // The endpoint, {currency} is replaced by the currency code.
+	Field  string `yaml:"field" toml:"field"`     // The dotted path to the price in the response, e.g. data.amount.
+	File   string `yaml:"file" toml:"file"`       // A file of rates, instead of an endpoint.
+	TTL    string `yaml:"ttl" toml:"ttl"`         // How long a rate is reused, 1m by default.
+	MaxAge string `yaml:"max_age" toml:"max_age"` // The maximum age of a rate, unlimited if empty.
+}
+
+// FormatOf returns the format of a file from its extension.
+func FormatOf(path string) (Format, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return YAML, nil
+	case ".toml":
+		return TOML, nil
+	}
+	return "", ErrUnknownFormat
+}
+
+// Load reads and validates a configuration file.
+//
+// The format is chosen from the extension of the file. The errors of the file are
+// reported as Errors, with their lines.
+func Load(path string) (*Config, error) {
+	return Reload(path, nil)
+}
+
+// Reload reads and validates a configuration file replacing a previous configuration.
+//
+// The state of the previous configuration is carried over: the challenges tracked for the
+// settlement webhook and the metrics, when they are still enabled, the tokens spent on the
+// services paid per request, when their store is the same, and the secrets of the tokens,
+// when the secrets section is unchanged.
+func Reload(path string, previous *Config) (*Config, error) {
+	format, err := FormatOf(path)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	config, err := parse(data, format, previous)
+	var errs Errors
+	if errors.As(err, &errs) {
+		for _, e := range errs {
+			e.File = path
+		}
+	}
+	return config, err
+}
+
+// Parse reads and validates a configuration.
+func Parse(data []byte, format Format) (*Config, error) {
+	return parse(data, format, nil)
+}
+
+func parse(data []byte, format Format, previous *Config) (*Config, error) {
+	var file File
+	var p positions
+
+	switch format {
+	case YAML:
+		p = yamlPositions(data)
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		if err := decoder.Decode(&file); err != nil && !errors.Is(err, io.EOF) {
+			return nil, yamlErrors(err)
+		}
+	case TOML:
+		p = tomlPositions(data)
+		decoder := toml.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&file); err != nil {
+			return nil, tomlErrors(err)
+		}
+	default:
+		return nil, ErrUnknownFormat
+	}
+
+	return file.build(p, previous)
+}
+
+// The errors of yaml.v3 start with their line.
+var yamlLine = regexp.MustCompile(`^(?:yaml: )?line (\d+): (.*)$`)
+
+func yamlErrors(err error) Errors {
+	messages := []string{err.Error()}
+	var typeErr *yaml.TypeError
+	if errors.As(err, &typeErr) {
+		messages = typeErr.Errors
+	}
+
+	errs := make(Errors, len(messages))
+	for i, message := range messages {
+		errs[i] = &Error{Err: errors.New(message)}
+		if match := yamlLine.FindStringSubmatch(message); match != nil {
+			errs[i].Line, _ = strconv.Atoi(match[1])
+			errs[i].Err = errors.New(match[2])
+		}
+	}
+	return errs
+}
+
+func tomlErrors(err error) Errors {
+	var strictErr *toml.StrictMissingError
+	if errors.As(err, &strictErr) {
+		errs := make(Errors, len(strictErr.Errors))
+		for i, e := range strictErr.Errors {
+			line, _ := e.Position()
+			errs[i] = &Error{Line: line, Path: strings.Join(e.Key(), "."), Err: errors.New("unknown field")}
+		}
+		return errs
+	}
+
+	var decodeErr *toml.DecodeError
+	if errors.As(err, &decodeErr) {
+		line, _ := decodeErr.Position()
+		return Errors{{Line: line, Err: errors.New(strings.TrimPrefix(decodeErr.Error(), "toml: "))}}
+	}
+	return Errors{{Err: err}}
+}
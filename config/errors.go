@@ -0,0 +1,55 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Error is an invalid value of a configuration file.
+type Error struct {
+	File string // The path of the file, if loaded from disk.
+	Line int    // The line of the value, 0 if unknown.
+	Path string // The path of the value, e.g. services[0].price.
+	Err  error
+}
+
+func (e *Error) Error() string {
+	var location string
+	switch {
+	case e.File != "" && e.Line > 0:
+		location = fmt.Sprintf("%s:%d: ", e.File, e.Line)
+	case e.File != "":
+		location = e.File + ": "
+	case e.Line > 0:
+		location = fmt.Sprintf("line %d: ", e.Line)
+	}
+
+	if e.Path == "" {
+		return location + e.Err.Error()
+	}
+	return fmt.Sprintf("%s%s: %v", location, e.Path, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Errors are all the invalid values of a configuration file, by line.
+type Errors []*Error
+
+func (errs Errors) Error() string {
+	messages := make([]string, len(errs))
+	for i, err := range errs {
+		messages[i] = err.Error()
+	}
+	return strings.Join(messages, "\n")
+}
+
+// Unwrap gives access to each error with errors.Is and errors.As.
+func (errs Errors) Unwrap() []error {
+	unwrapped := make([]error, len(errs))
+	for i, err := range errs {
+		unwrapped[i] = err
+	}
+	return unwrapped
+}
@@ -0,0 +1,143 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pelletier/go-toml/v2/unstable"
+	"gopkg.in/yaml.v3"
+)
+
+// positions are the lines of the values of a file, by path.
+type positions map[string]int
+
+// line returns the line of a path, or of its closest parent.
+func (p positions) line(path string) int {
+	for path != "" {
+		if line, ok := p[path]; ok {
+			return line
+		}
+		i := strings.LastIndexAny(path, ".[")
+		if i < 0 {
+			return 0
+		}
+		path = path[:i]
+	}
+	return 0
+}
+
+func field(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func index(path string, i int) string {
+	return fmt.Sprintf("%s[%d]", path, i)
+}
+
+// yamlPositions indexes the lines of a YAML document.
+func yamlPositions(data []byte) positions {
+	var document yaml.Node
+	p := positions{}
+	if yaml.Unmarshal(data, &document) == nil {
+		p.addYAML("", &document)
+	}
+	return p
+}
+
+func (p positions) addYAML(path string, node *yaml.Node) {
+	switch node.Kind {
+	case yaml.DocumentNode:
+		for _, child := range node.Content {
+			p.addYAML(path, child)
+		}
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key := field(path, node.Content[i].Value)
+			p[key] = node.Content[i].Line
+			p.addYAML(key, node.Content[i+1])
+		}
+	case yaml.SequenceNode:
+		for i, child := range node.Content {
+			p[index(path, i)] = child.Line
+			p.addYAML(index(path, i), child)
+		}
+	}
+}
+
+// tomlPositions indexes the lines of a TOML document.
+//
+// The tables of arrays are numbered in their order of appearance, so that
+// [[services]] followed by [[services.caveats]] is indexed as services[0].caveats[0].
+func tomlPositions(data []byte) positions {
+	p := positions{}
+	counts := make(map[string]int)
+
+	parser := unstable.Parser{}
+	parser.Reset(data)
+
+	table := ""
+	for parser.NextExpression() {
+		expr := parser.Expression()
+		switch expr.Kind {
+		case unstable.Table, unstable.ArrayTable:
+			table = ""
+			keys := expr.Key()
+			for keys.Next() {
+				table = field(table, string(keys.Node().Data))
+				line := parser.Shape(keys.Node().Raw).Start.Line
+				if _, ok := p[table]; !ok {
+					p[table] = line
+				}
+
+				if keys.IsLast() && expr.Kind == unstable.ArrayTable {
+					array := table
+					table = index(array, counts[array])
+					counts[array]++
+					p[table] = line
+				} else if n, ok := counts[table]; ok {
+					// A table of the last element of an array.
+					table = index(table, n-1)
+				}
+			}
+		case unstable.KeyValue:
+			p.addTOMLKeyValue(&parser, table, expr)
+		}
+	}
+	return p
+}
+
+func (p positions) addTOMLKeyValue(parser *unstable.Parser, table string, expr *unstable.Node) {
+	path := table
+	line := 0
+	keys := expr.Key()
+	for keys.Next() {
+		path = field(path, string(keys.Node().Data))
+		line = parser.Shape(keys.Node().Raw).Start.Line
+		p[path] = line
+	}
+	p.addTOMLValue(parser, path, line, expr.Value())
+}
+
+func (p positions) addTOMLValue(parser *unstable.Parser, path string, line int, value *unstable.Node) {
+	switch value.Kind {
+	case unstable.InlineTable:
+		children := value.Children()
+		for children.Next() {
+			p.addTOMLKeyValue(parser, path, children.Node())
+		}
+	case unstable.Array:
+		children := value.Children()
+		for i := 0; children.Next(); i++ {
+			child := children.Node()
+			childLine := line
+			if child.Raw.Length > 0 {
+				childLine = parser.Shape(child.Raw).Start.Line
+			}
+			p[index(path, i)] = childLine
+			p.addTOMLValue(parser, index(path, i), childLine, child)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+// Package daemon runs a proxy server from a configuration file, with graceful
+// shutdown and reloading.
+package daemon
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"lsat/admin"
+	"lsat/config"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultShutdownTimeout   = 30 * time.Second
+	defaultReadHeaderTimeout = 10 * time.Second
+)
+
+// Daemon serves the proxy of a configuration file.
+//
+// The configuration can be reloaded while serving: the requests in flight complete with
+// the previous configuration and the connections are kept.
+type Daemon struct {
+	ConfigPath      string        // The path of the configuration file.
+	CertFile        string        // The TLS certificate, served over HTTP if empty.
+	KeyFile         string        // The key of the TLS certificate.
+	ShutdownTimeout time.Duration // The time given to the requests in flight on shutdown, 30s by default.
+	Logger          *slog.Logger  // The logger, slog.Default() if nil.
+
+	handler atomic.Pointer[http.Handler]
+	mu      sync.Mutex
+	config  *config.Config // The configuration loaded, whose state is carried over on reload.
+}
+
+// Create a new Daemon.
+func New(configPath string) *Daemon {
+	return &Daemon{ConfigPath: configPath}
+}
+
+func (d *Daemon) logger() *slog.Logger {
+	if d.Logger == nil {
+		return slog.Default()
+	}
+	return d.Logger
+}
+
+// Load reads the configuration file and serves it for the next requests.
+//
+// When the admin API is enabled, the services are those of its state file, seeded with the
+// services of the configuration on the first load.
+//
+// An invalid configuration is not loaded, and the previous one is kept. The challenges
+// tracked for the settlement webhook, the metrics, the spent tokens and the secrets are carried
+// over to the new configuration.
+func (d *Daemon) Load() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	cfg, err := config.Reload(d.ConfigPath, d.config)
+	if err != nil {
+		return err
+	}
+
+	router := cfg.Server().Router()
+	if cfg.AdminToken != "" {
+		store, err := admin.Open(cfg.AdminState, cfg.ServiceFiles)
+		if err != nil {
+			return err
+		}
+		api, err := admin.New(store, cfg.Manager, cfg.AdminToken)
+		if err != nil {
+			return err
+		}
+		api.Register(router.Group("/admin"))
+	}
+
+	var handler http.Handler = router
+	d.handler.Store(&handler)
+	d.config = cfg
+	d.logger().Info("configuration loaded", "path", d.ConfigPath, "services", len(cfg.Services), "routes", len(cfg.Routes))
+	return nil
+}
+
+// ServeHTTP serves a request with the current configuration.
+func (d *Daemon) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	handler := d.handler.Load()
+	if handler == nil {
+		http.Error(w, "no configuration loaded", http.StatusServiceUnavailable)
+		return
+	}
+	(*handler).ServeHTTP(w, r)
+}
+
+// ListenAndServe serves on an address until the context is done.
+func (d *Daemon) ListenAndServe(ctx context.Context, addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return d.Serve(ctx, listener)
+}
+
+// Serve serves on a listener until the context is done, then shuts down gracefully.
+//
+// The configuration is loaded first if it was not. On shutdown, the listener is closed and
+// the requests in flight are given the ShutdownTimeout to complete.
+func (d *Daemon) Serve(ctx context.Context, listener net.Listener) error {
+	if d.handler.Load() == nil {
+		if err := d.Load(); err != nil {
+			listener.Close()
+			return err
+		}
+	}
+
+	server := &http.Server{
+		Handler:           d,
+		ReadHeaderTimeout: defaultReadHeaderTimeout,
+		ErrorLog:          slog.NewLogLogger(d.logger().Handler(), slog.LevelWarn),
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		d.logger().Info("listening", "address", listener.Addr().String(), "tls", d.CertFile != "")
+		if d.CertFile != "" {
+			done <- server.ServeTLS(listener, d.CertFile, d.KeyFile)
+		} else {
+			done <- server.Serve(listener)
+		}
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+	}
+
+	timeout := d.ShutdownTimeout
+	if timeout <= 0 {
+		timeout = defaultShutdownTimeout
+	}
+	d.logger().Info("shutting down", "timeout", timeout)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	err := server.Shutdown(shutdownCtx)
+	if serveErr := <-done; !errors.Is(serveErr, http.ErrServerClosed) {
+		return serveErr
+	}
+	return err
+}
@@ -5,13 +5,14 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"lsat/amount"
 	"lsat/auth"
 	"lsat/challenge"
 	"lsat/macaroon"
 	"lsat/mock"
+	"lsat/phoenixd"
 	"net/http"
 	"os"
-	"strings"
 	"time"
 )
 
@@ -41,33 +42,24 @@ func main() {
 	}
 }
 
-var lightningNode = mock.TestLightningNode{Balance: 10000}
+var lightningNode = newLightningNode()
 
-// Connect to the phoenix node
-// var lightningClient = phoenixd.NewPhoenixClient("http://127.0.0.1:9740", "")
-// var lightningNode = phoenixd.PhoenixNode{Client: lightningClient}
-
-func parsePreToken(mac string, invoice string) (macaroon.PreToken, error) {
-	Macaroon, err := decodeMacaroon(mac)
-	if err != nil {
-		return macaroon.PreToken{}, fmt.Errorf("error decoding macaroon: %v", err)
-	}
-	return macaroon.PreToken{
-		Macaroon: Macaroon,
-		InvoiceResponse: challenge.InvoiceResponse{
-			Invoice: strings.Split(invoice, "\"")[1],
-		},
-	}, nil
+// The invoices sent by the server are checked against this policy before being paid.
+var invoicePolicy = challenge.InvoicePolicy{
+	MaxAmount: 1000 * amount.Satoshi,
+	Network:   mock.Network,
 }
 
-func decodeMacaroon(input string) (macaroon.Macaroon, error) {
-	parts := strings.Split(input, "\"")
-	if len(parts) < 2 {
-		return macaroon.Macaroon{}, fmt.Errorf("invalid macaroon string")
+// Connect to the phoenix node at PHOENIXD_URL if set, such as the fake nodes of
+// ./examples/phoenixd, otherwise use a mocked node.
+func newLightningNode() challenge.LightningNode {
+	phoenixURL, ok := os.LookupEnv("PHOENIXD_URL")
+	if !ok {
+		return mock.NewLightningNode(10_000 * amount.Satoshi)
 	}
 
-	macaroonStr := parts[1]
-	return macaroon.DecodeBase64(macaroonStr)
+	lightningClient := phoenixd.NewPhoenixClient(phoenixURL, os.Getenv("PHOENIXD_PASSWORD"))
+	return &phoenixd.PhoenixNode{Client: lightningClient}
 }
 
 func (c *TestClient) sendTokenRequest() {
@@ -80,8 +72,6 @@ func (c *TestClient) sendTokenRequest() {
 		return
 	}
 
-	req.Header.Set("Authorization", "L402")
-
 	resp, err := client.Do(req)
 	if err != nil {
 		fmt.Println("Error sending request:", err)
@@ -90,28 +80,26 @@ func (c *TestClient) sendTokenRequest() {
 	defer resp.Body.Close()
 
 	if resp.StatusCode == http.StatusPaymentRequired {
-		wwwAuthenticateHeader := resp.Header.Get("WWW-Authenticate")
-		parts := strings.Split(wwwAuthenticateHeader, " ")
-
-		if len(parts) > 2 && parts[0] == "L402" {
-			macaroon := parts[1]
-			invoice := parts[2]
-
-			preToken, err := parsePreToken(macaroon, invoice)
-			if err != nil {
-				fmt.Println(err)
-				return
-			}
-
-			token, err := preToken.Pay(&lightningNode)
-			if err != nil {
-				fmt.Println(err)
-				return
-			}
-
-			fmt.Println(token.Macaroon.ToJSON())
-			c.sendAuthorizationRequest(serviceURL, token)
+		challenges, err := auth.ParseChallenges(resp.Header.Values("WWW-Authenticate")...)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+
+		preToken, err := challenges[0].PreToken()
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+
+		token, err := preToken.PayWithPolicy(lightningNode, invoicePolicy)
+		if err != nil {
+			fmt.Println(err)
+			return
 		}
+
+		fmt.Println(token.Macaroon.ToJSON())
+		c.sendAuthorizationRequest(serviceURL, token)
 	} else {
 		body, _ := io.ReadAll(resp.Body)
 		fmt.Printf("(%s) Unexpected response status: %s\n", resp.Status, string(body))
@@ -128,7 +116,7 @@ func (c *TestClient) sendAuthorizationRequest(url string, token macaroon.Token)
 		return
 	}
 
-	req.Header.Set("Authorization", fmt.Sprintf("L402 %s", token.String()))
+	req.Header.Set("Authorization", auth.NewAuthorization(token).String())
 
 	resp, err := client.Do(req)
 	if err != nil {
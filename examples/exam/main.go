@@ -2,6 +2,7 @@ package main
 
 import (
 	"errors"
+	"lsat/amount"
 	"lsat/auth"
 	"lsat/macaroon"
 	"lsat/mock"
@@ -39,7 +40,7 @@ func main() {
 		service.Service{
 			Name:  "test",
 			Tier:  service.BaseTier,
-			Price: 1000,
+			Price: 1000 * amount.Satoshi,
 			FirstPartyCaveats: []service.Caveat{
 				service.Expire{Delay: time.Hour * 24},
 				service.GenerateID{Name: "test_id"},
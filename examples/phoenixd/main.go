@@ -0,0 +1,37 @@
+// Runs two fake phoenixd nodes in one process, so that the example server and client
+// can run separately while paying each other.
+package main
+
+import (
+	"log"
+	"lsat/amount"
+	"lsat/mock"
+	"net/http"
+	"os"
+)
+
+const (
+	serverAddr = "localhost:9740"
+	clientAddr = "localhost:9741"
+)
+
+func main() {
+	password := getEnv("PHOENIXD_PASSWORD", "phoenixd")
+
+	server := mock.NewFakePhoenixd(password, 0)
+	client := mock.NewFakePhoenixd(password, 10_000*amount.Satoshi)
+
+	go func() {
+		log.Fatal(http.ListenAndServe(clientAddr, client))
+	}()
+
+	log.Printf("Server node at http://%s, client node at http://%s", serverAddr, clientAddr)
+	log.Fatal(http.ListenAndServe(serverAddr, server))
+}
+
+func getEnv(key, defaultVal string) string {
+	if value, exists := os.LookupEnv(key); exists {
+		return value
+	}
+	return defaultVal
+}
@@ -1,34 +1,46 @@
 package main
 
 import (
+	"lsat/amount"
 	"lsat/auth"
+	"lsat/challenge"
 	"lsat/mock"
+	"lsat/phoenixd"
 	"lsat/proxy"
 	"lsat/secrets"
 	"lsat/service"
 	"net/http"
+	"os"
 	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
 var (
-	// Connect to the phoenix node
-	// lightningClient = phoenixd.NewPhoenixClient("http://127.0.0.1:9740", "")
-	// lightningNode = phoenixd.PhoenixNode{Client: lightningClient}
-	// challenger = &challenge.ChallengeFactory{
-	// 	LightningNode: &lightningNode,
-	// }
 	secretStore = secrets.NewSecretFactory()
-	challenger  = mock.NewChallenger()
+	challenger  = newChallenger()
 )
 
+// Connect to the phoenix node at PHOENIXD_URL if set, such as the fake nodes of
+// ./examples/phoenixd, otherwise use a mocked node.
+func newChallenger() challenge.Challenger {
+	phoenixURL, ok := os.LookupEnv("PHOENIXD_URL")
+	if !ok {
+		return mock.NewChallenger()
+	}
+
+	lightningClient := phoenixd.NewPhoenixClient(phoenixURL, os.Getenv("PHOENIXD_PASSWORD"))
+	return &challenge.ChallengeFactory{
+		LightningNode: &phoenixd.PhoenixNode{Client: lightningClient},
+	}
+}
+
 func main() {
 	config := service.NewConfig(
 		service.Service{
 			Name:  "image",
 			Tier:  service.BaseTier,
-			Price: 100,
+			Price: 100 * amount.Satoshi,
 			FirstPartyCaveats: []service.Caveat{
 				service.Expire{Delay: time.Hour},
 			},
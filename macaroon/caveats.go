@@ -7,10 +7,14 @@ import (
 )
 
 const (
-	ServiceKey     string = "service"
-	ExpiryDateKey  string = "expiry_date"
-	NotBeforeKey   string = "not_before"
-	PaymentHashKey string = "payment_hash"
+	ServiceKey      string = "service"
+	ExpiryDateKey   string = "expiry_date"
+	NotBeforeKey    string = "not_before"
+	PaymentHashKey  string = "payment_hash"
+	ExchangeRateKey string = "exchange_rate"
+	PerRequestKey   string = "per_request"
+	RouteKey        string = "route"
+	MethodKey       string = "method"
 )
 
 // Caveat represents a condition or restriction associated with a macaroon.
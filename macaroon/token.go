@@ -2,6 +2,7 @@ package macaroon
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"lsat/challenge"
 	"lsat/secrets"
@@ -13,6 +14,9 @@ const (
 	BaseVersion = iota
 )
 
+// ErrUnpaid is returned when the preimage of a token does not match its payment_hash.
+var ErrUnpaid = errors.New("the token is not paid")
+
 // A service token.
 //
 // It holds the macaroon and its premiage.
@@ -46,8 +50,15 @@ type PreToken struct {
 
 // Pay a token.
 //
-// This creates a valid Token.
+// The invoice is paid without any check: neither its amount, its network, its expiry nor its
+// payment hash, so a malicious server can charge any amount for a token that does not work.
+//
+// Deprecated: use PayWithPolicy, which checks the invoice before paying it.
 func (token PreToken) Pay(node challenge.LightningNode) (Token, error) {
+	return token.pay(node)
+}
+
+func (token PreToken) pay(node challenge.LightningNode) (Token, error) {
 	cx := context.Background()
 	// cx = context.WithValue(cx, "macaroon", token.Macaroon) // Enrich the context with a macaroon
 	response, err := node.PayInvoice(cx, challenge.PayInvoiceRequest{Invoice: token.InvoiceResponse.Invoice})
@@ -58,6 +69,48 @@ func (token PreToken) Pay(node challenge.LightningNode) (Token, error) {
 	}
 }
 
+// PaymentHash returns the payment hash the macaroon is bound to.
+func (token PreToken) PaymentHash() (lntypes.Hash, error) {
+	iter := token.Macaroon.GetValue(PaymentHashKey)
+	if !iter.HasNext() {
+		return lntypes.Hash{}, fmt.Errorf("the macaroon has no %s", PaymentHashKey)
+	}
+	return lntypes.MakeHashFromStr(iter.Next())
+}
+
+// Verify decodes the invoice and checks it against the policy and the payment_hash of the macaroon.
+func (token PreToken) Verify(policy challenge.InvoicePolicy) error {
+	paymentHash, err := token.PaymentHash()
+	if err != nil {
+		return &challenge.InvoiceError{Check: challenge.CheckPaymentHash, Reason: err.Error()}
+	}
+
+	_, err = challenge.VerifyInvoice(token.InvoiceResponse.Invoice, paymentHash, policy)
+	return err
+}
+
+// PayWithPolicy verifies the invoice before paying it.
+//
+// This creates a valid Token.
+func (token PreToken) PayWithPolicy(node challenge.LightningNode, policy challenge.InvoicePolicy) (Token, error) {
+	if err := token.Verify(policy); err != nil {
+		return Token{}, err
+	}
+
+	paid, err := token.pay(node)
+	if err != nil {
+		return Token{}, err
+	}
+
+	// The preimage returned by the node must unlock the macaroon.
+	paymentHash, _ := token.PaymentHash()
+	if paid.Preimage.Hash() != paymentHash {
+		return Token{}, fmt.Errorf("%w: the preimage %s does not match the %s", ErrUnpaid, paid.Preimage, PaymentHashKey)
+	}
+
+	return paid, nil
+}
+
 func (token PreToken) String() string {
 	// Encode the Macaroon(s) as base64
 	macaroonBase64 := token.Macaroon.String()
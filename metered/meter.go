@@ -0,0 +1,178 @@
+// Package metered charges long-lived responses, such as server-sent events and WebSockets,
+// by time or by bytes.
+//
+// The token of the request pays for a first allowance. When it runs out, the stream is paused
+// and a payment-required event carrying a fresh invoice is sent in-band. The stream resumes
+// once the client sends the preimage of the invoice, on the same connection for WebSockets and
+// to the Payments of the meter for event streams.
+package metered
+
+import (
+	"context"
+	"errors"
+	"lsat/auth"
+	"lsat/service"
+	"time"
+
+	"github.com/lightningnetwork/lnd/lntypes"
+)
+
+const (
+	// PaymentRequiredEvent is sent with a fresh invoice when the allowance runs out.
+	PaymentRequiredEvent = "payment-required"
+	// PaymentAcceptedEvent is sent when the preimage of the invoice is received.
+	PaymentAcceptedEvent = "payment-accepted"
+
+	defaultPaymentTimeout = 2 * time.Minute
+)
+
+var (
+	// ErrPaymentTimeout is returned when the preimage of an invoice is not received in time.
+	ErrPaymentTimeout = errors.New("the payment of the stream timed out")
+	// ErrClosed is returned when the client stops sending while a payment is required.
+	ErrClosed = errors.New("the client closed the stream")
+)
+
+// Allowance is what a payment buys on a stream.
+type Allowance struct {
+	Bytes    int64         // The bytes sent, unlimited if zero.
+	Duration time.Duration // The time, unlimited if zero.
+}
+
+// Whether the allowance is unlimited.
+func (a Allowance) IsZero() bool {
+	return a.Bytes <= 0 && a.Duration <= 0
+}
+
+// Meter charges the streams of a service.
+//
+// Each payment is the price of the service, and buys the Allowance. The token of the request
+// pays for the first allowance, so the service should be sold per request for each stream to
+// require a new token.
+type Meter struct {
+	Minter         *auth.Minter
+	Service        service.ServiceID
+	Allowance      Allowance
+	Payments       *Payments        // Receives the preimages of the event streams, see SSE.
+	PaymentTimeout time.Duration    // The time given to the client to pay, 2 minutes by default.
+	Clock          func() time.Time // Defaults to time.Now.
+}
+
+// Create a new Meter.
+func NewMeter(minter *auth.Minter, id service.ServiceID, allowance Allowance) *Meter {
+	return &Meter{Minter: minter, Service: id, Allowance: allowance, Payments: &Payments{}}
+}
+
+func (m *Meter) now() time.Time {
+	if m.Clock == nil {
+		return time.Now()
+	}
+	return m.Clock()
+}
+
+// PaymentRequired is the payload of a payment-required event.
+type PaymentRequired struct {
+	Invoice     string `json:"invoice"`
+	PaymentHash string `json:"payment_hash"`
+	AmountMsat  uint64 `json:"amount_msat"`
+}
+
+// PaymentAccepted is the payload of a payment-accepted event.
+type PaymentAccepted struct {
+	PaymentHash string `json:"payment_hash"`
+}
+
+// A session is the allowance left on a stream.
+type session struct {
+	meter     *Meter
+	bytes     int64
+	until     time.Time
+	preimages <-chan lntypes.Preimage
+	notify    func(event string, payload any) error // Sends an event to the client.
+	// Registers the invoice awaited until the returned function is called, if the preimages
+	// are not sent on the stream.
+	expect func(hash lntypes.Hash) func()
+}
+
+// start opens a session with the first allowance, paid by the token of the request.
+func (m *Meter) start(preimages <-chan lntypes.Preimage, notify func(string, any) error) *session {
+	s := &session{meter: m, preimages: preimages, notify: notify}
+	s.extend()
+	return s
+}
+
+func (s *session) extend() {
+	allowance := s.meter.Allowance
+	if allowance.Bytes > 0 {
+		s.bytes = max(s.bytes, 0) + allowance.Bytes
+	}
+	if allowance.Duration > 0 {
+		s.until = s.meter.now().Add(allowance.Duration)
+	}
+}
+
+func (s *session) exhausted() bool {
+	allowance := s.meter.Allowance
+	return (allowance.Bytes > 0 && s.bytes <= 0) || (allowance.Duration > 0 && !s.meter.now().Before(s.until))
+}
+
+// take consumes the allowance of n bytes about to be sent, after asking for the payments
+// needed if it ran out.
+//
+// A message is sent as long as some allowance is left, so the allowance can go below zero.
+func (s *session) take(ctx context.Context, n int) error {
+	for s.exhausted() {
+		if err := s.pay(ctx); err != nil {
+			return err
+		}
+	}
+	s.bytes -= int64(n)
+	return nil
+}
+
+// pay sends a fresh invoice and waits for its preimage.
+//
+// The preimages of other invoices are ignored.
+func (s *session) pay(ctx context.Context) error {
+	invoice, err := s.meter.Minter.Challenge(ctx, s.meter.Service)
+	if err != nil {
+		return err
+	}
+
+	if s.expect != nil {
+		defer s.expect(invoice.PaymentHash)()
+	}
+	err = s.notify(PaymentRequiredEvent, PaymentRequired{
+		Invoice:     invoice.Invoice,
+		PaymentHash: invoice.PaymentHash.String(),
+		AmountMsat:  uint64(invoice.Amount),
+	})
+	if err != nil {
+		return err
+	}
+
+	timeout := s.meter.PaymentTimeout
+	if timeout <= 0 {
+		timeout = defaultPaymentTimeout
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for {
+		select {
+		case preimage, ok := <-s.preimages:
+			if !ok {
+				return ErrClosed
+			}
+			if preimage.Hash() != invoice.PaymentHash {
+				continue
+			}
+			s.extend()
+			return s.notify(PaymentAcceptedEvent, PaymentAccepted{PaymentHash: invoice.PaymentHash.String()})
+		case <-timer.C:
+			return ErrPaymentTimeout
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
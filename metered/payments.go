@@ -0,0 +1,85 @@
+package metered
+
+import (
+	"encoding/json"
+	"fmt"
+	"lsat/auth"
+	"lsat/challenge"
+	"lsat/problem"
+	"net/http"
+	"sync"
+
+	"github.com/lightningnetwork/lnd/lntypes"
+)
+
+// Payments receives the preimages of the invoices sent on event streams, which the clients
+// cannot answer on the stream itself.
+//
+// It serves the requests posting a preimage, {"preimage":"<hex>"}, and hands it to the stream
+// waiting for the payment of its invoice. The zero value is ready to use.
+type Payments struct {
+	mu      sync.Mutex
+	waiting map[lntypes.Hash]chan<- lntypes.Preimage
+}
+
+// expect registers a stream waiting for the payment of an invoice, until the returned
+// function is called.
+func (p *Payments) expect(hash lntypes.Hash, preimages chan<- lntypes.Preimage) func() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.waiting == nil {
+		p.waiting = make(map[lntypes.Hash]chan<- lntypes.Preimage)
+	}
+	p.waiting[hash] = preimages
+	return func() {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		delete(p.waiting, hash)
+	}
+}
+
+// Pay hands a preimage to the stream waiting for the payment of its invoice.
+//
+// It fails with challenge.ErrUnknownChallenge when no stream is waiting for it.
+func (p *Payments) Pay(preimage lntypes.Preimage) error {
+	hash := preimage.Hash()
+
+	p.mu.Lock()
+	preimages, ok := p.waiting[hash]
+	delete(p.waiting, hash)
+	p.mu.Unlock()
+
+	if !ok {
+		return fmt.Errorf("%w: no stream is waiting for the payment %s", challenge.ErrUnknownChallenge, hash)
+	}
+
+	// The stream may have just stopped waiting, it then ignores the preimage.
+	select {
+	case preimages <- preimage:
+	default:
+	}
+	return nil
+}
+
+// ServeHTTP receives a preimage, and answers with 204 No Content once it is handed to its stream.
+func (p *Payments) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Preimage string `json:"preimage"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		problem.WriteError(w, fmt.Errorf("%w: %w", auth.ErrInvalidAuth, err))
+		return
+	}
+
+	preimage, err := lntypes.MakePreimageFromStr(body.Preimage)
+	if err != nil {
+		problem.WriteError(w, fmt.Errorf("%w: %w", auth.ErrInvalidAuth, err))
+		return
+	}
+
+	if err := p.Pay(preimage); err != nil {
+		problem.WriteError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
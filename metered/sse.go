@@ -0,0 +1,177 @@
+package metered
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+
+	"github.com/lightningnetwork/lnd/lntypes"
+)
+
+// SSE meters a handler streaming server-sent events, such as the reverse proxy of a route.
+//
+// The events of the handler are sent whole, and the events of the meter between them:
+//
+//	event: payment-required
+//	data: {"invoice":"lnbc...","payment_hash":"...","amount_msat":1000}
+//
+// The client pays the invoice and posts its preimage to the Payments of the meter, in a
+// separate request, while it reads the events. The request is passed on to the handler as is,
+// with its body.
+//
+// The responses that are not event streams are not metered.
+func (m *Meter) SSE(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		writer := &sseWriter{ResponseWriter: w, ctx: ctx}
+		preimages := make(chan lntypes.Preimage, 1)
+		writer.session = m.start(preimages, writer.event)
+		writer.session.expect = func(hash lntypes.Hash) func() {
+			return m.Payments.expect(hash, preimages)
+		}
+
+		// The reverse proxy aborts the handler when a write fails, e.g. when the client
+		// does not pay, which ends the stream as expected.
+		defer func() {
+			if recovered := recover(); recovered != nil {
+				if recovered != http.ErrAbortHandler || writer.err == nil {
+					panic(recovered)
+				}
+			}
+		}()
+
+		next.ServeHTTP(writer, r)
+		writer.finish()
+	})
+}
+
+// sseWriter meters the events written by a handler.
+type sseWriter struct {
+	http.ResponseWriter
+	ctx     context.Context
+	session *session
+
+	wroteHeader bool
+	metered     bool   // Whether the response is an event stream.
+	buf         []byte // The start of the next event.
+	err         error  // The error stopping the stream.
+}
+
+func (w *sseWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		mediaType, _, _ := mime.ParseMediaType(w.Header().Get("Content-Type"))
+		w.metered = mediaType == "text/event-stream"
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// Write sends the complete events once their allowance is paid, and keeps the rest.
+func (w *sseWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if !w.metered {
+		return w.ResponseWriter.Write(p)
+	}
+	if w.err != nil {
+		return 0, w.err
+	}
+
+	w.buf = append(w.buf, p...)
+	for {
+		end := eventEnd(w.buf)
+		if end < 0 {
+			break
+		}
+		if err := w.send(w.buf[:end]); err != nil {
+			return 0, err
+		}
+		w.buf = w.buf[end:]
+	}
+
+	w.Flush()
+	return len(p), nil
+}
+
+// eventEnd returns the end of the first event of buf, after the blank line terminating it, or -1
+// if it is not complete.
+//
+// The lines end with "\r\n", "\n" or "\r", mixed or not. A blank line ending with "\r" at the
+// end of buf is not complete yet, since the "\n" of a "\r\n" may follow.
+func eventEnd(buf []byte) int {
+	for i := 0; i < len(buf); {
+		n := lineEnding(buf[i:])
+		if n == 0 {
+			i++
+			continue
+		}
+		end := i + n
+		if m := lineEnding(buf[end:]); m > 0 {
+			if end+m == len(buf) && buf[end+m-1] == '\r' {
+				return -1
+			}
+			return end + m
+		}
+		i = end
+	}
+	return -1
+}
+
+// lineEnding returns the length of the line ending at the start of b, or 0 if there is none.
+func lineEnding(b []byte) int {
+	switch {
+	case len(b) == 0:
+		return 0
+	case b[0] == '\n':
+		return 1
+	case b[0] == '\r' && len(b) > 1 && b[1] == '\n':
+		return 2
+	case b[0] == '\r':
+		return 1
+	}
+	return 0
+}
+
+// send an event once its allowance is paid.
+func (w *sseWriter) send(event []byte) error {
+	if w.err = w.session.take(w.ctx, len(event)); w.err != nil {
+		return w.err
+	}
+	_, w.err = w.ResponseWriter.Write(event)
+	return w.err
+}
+
+// finish sends the last event, if the handler did not terminate it.
+func (w *sseWriter) finish() {
+	if w.metered && w.err == nil && len(w.buf) > 0 {
+		if w.send(w.buf) == nil {
+			w.Flush()
+		}
+	}
+}
+
+// event sends an event of the meter.
+func (w *sseWriter) event(name string, payload any) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(w.ResponseWriter, "event: %s\ndata: %s\n\n", name, data); err != nil {
+		return err
+	}
+	w.Flush()
+	return nil
+}
+
+// Flush sends the buffered data to the client.
+func (w *sseWriter) Flush() {
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+// Unwrap returns the wrapped writer, for http.ResponseController.
+func (w *sseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
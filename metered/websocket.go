@@ -0,0 +1,98 @@
+package metered
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+
+	"github.com/lightningnetwork/lnd/lntypes"
+	"golang.org/x/net/websocket"
+)
+
+// Event is a message of the meter on a WebSocket.
+type Event struct {
+	Event string          `json:"event"` // payment-required or payment-accepted.
+	Data  json.RawMessage `json:"data"`  // A PaymentRequired or a PaymentAccepted.
+}
+
+// Conn meters the messages sent on a WebSocket.
+//
+// The events of the meter are sent as JSON text messages, e.g.
+//
+//	{"event":"payment-required","data":{"invoice":"lnbc...","payment_hash":"...","amount_msat":1000}}
+//
+// and the client sends the preimages as JSON text messages, {"preimage":"<hex>"}. The other
+// messages of the client are ignored.
+type Conn struct {
+	ws      *websocket.Conn
+	session *session
+	mu      sync.Mutex // Serializes the sends.
+}
+
+// WebSocket meters the messages sent on a WebSocket, e.g. in a websocket.Handler.
+func (m *Meter) WebSocket(ws *websocket.Conn) *Conn {
+	conn := &Conn{ws: ws}
+	conn.session = m.start(receivePreimages(ws), conn.event)
+	return conn
+}
+
+// receivePreimages reads the preimages sent on a WebSocket until it is closed.
+func receivePreimages(ws *websocket.Conn) <-chan lntypes.Preimage {
+	preimages := make(chan lntypes.Preimage, 1)
+	go func() {
+		defer close(preimages)
+		for {
+			var message struct {
+				Preimage string `json:"preimage"`
+			}
+			if err := websocket.JSON.Receive(ws, &message); err != nil {
+				var syntaxErr *json.SyntaxError
+				var typeErr *json.UnmarshalTypeError
+				if errors.As(err, &syntaxErr) || errors.As(err, &typeErr) {
+					continue
+				}
+				return
+			}
+
+			preimage, err := lntypes.MakePreimageFromStr(message.Preimage)
+			if err != nil {
+				continue
+			}
+			select {
+			case preimages <- preimage:
+			case <-ws.Request().Context().Done():
+				return
+			}
+		}
+	}()
+	return preimages
+}
+
+// Send a text message once its allowance is paid.
+func (c *Conn) Send(text string) error {
+	return c.send(len(text), text)
+}
+
+// SendBinary sends a binary message once its allowance is paid.
+func (c *Conn) SendBinary(data []byte) error {
+	return c.send(len(data), data)
+}
+
+func (c *Conn) send(size int, message any) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.session.take(c.ws.Request().Context(), size); err != nil {
+		return err
+	}
+	return websocket.Message.Send(c.ws, message)
+}
+
+// event sends an event of the meter, with the lock held by send.
+func (c *Conn) event(name string, payload any) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	return websocket.JSON.Send(c.ws, Event{Event: name, Data: data})
+}
@@ -0,0 +1,78 @@
+package metrics
+
+import (
+	"context"
+	"lsat/challenge"
+	"lsat/service"
+	"net/http"
+	"time"
+)
+
+// Node wraps a LightningNode and records the latency of its calls.
+type Node struct {
+	challenge.LightningNode
+	Metrics *Metrics
+}
+
+// Create a new Node recording the calls of a node.
+func NewNode(node challenge.LightningNode, metrics *Metrics) *Node {
+	return &Node{LightningNode: node, Metrics: metrics}
+}
+
+func (n *Node) CreateInvoice(ctx context.Context, req challenge.CreateInvoiceRequest) (challenge.InvoiceResponse, error) {
+	start := time.Now()
+	response, err := n.LightningNode.CreateInvoice(ctx, req)
+	n.Metrics.ObserveLightning("create_invoice", time.Since(start), err)
+	return response, err
+}
+
+func (n *Node) PayInvoice(ctx context.Context, req challenge.PayInvoiceRequest) (challenge.PayInvoiceResponse, error) {
+	start := time.Now()
+	response, err := n.LightningNode.PayInvoice(ctx, req)
+	n.Metrics.ObserveLightning("pay_invoice", time.Since(start), err)
+	return response, err
+}
+
+// Upstream wraps the handler forwarding the requests of a service and records their latency.
+//
+// The latency is measured until the handler returns, so it includes the transfer of the
+// response body.
+func (m *Metrics) Upstream(id service.ServiceID, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r)
+		m.ObserveUpstream(id, recorder.status, time.Since(start))
+	})
+}
+
+// statusRecorder remembers the status of a response.
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	if !s.wroteHeader {
+		s.status, s.wroteHeader = status, true
+	}
+	s.ResponseWriter.WriteHeader(status)
+}
+
+func (s *statusRecorder) Write(p []byte) (int, error) {
+	s.wroteHeader = true
+	return s.ResponseWriter.Write(p)
+}
+
+// Flush sends the buffered data, so that the streams still reach the client.
+func (s *statusRecorder) Flush() {
+	if flusher, ok := s.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap returns the wrapped writer, for http.ResponseController.
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
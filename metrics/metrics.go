@@ -0,0 +1,159 @@
+package metrics
+
+import (
+	"errors"
+	"lsat/amount"
+	"lsat/auth"
+	"lsat/challenge"
+	"lsat/service"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/lightningnetwork/lnd/lntypes"
+)
+
+// The reasons a token is rejected, as the reason label of l402_tokens_rejected_total.
+const (
+	ReasonMalformed   = "malformed"    // The Authorization header cannot be parsed.
+	ReasonPaymentHash = "payment_hash" // The preimage does not match the payment hash.
+	ReasonSignature   = "signature"    // The signature of the macaroon is invalid.
+	ReasonNotGranted  = "not_granted"  // The token is valid for another service.
+	ReasonCaveats     = "caveats"      // A condition of the service rejected the caveats.
+	ReasonSpent       = "spent"        // The token of a service paid per request was already used.
+)
+
+// The invoices challenged and not settled yet are remembered up to this number, to label
+// their settlement with their service.
+const maxUnsettled = 100000
+
+// Metrics records the activity of a proxy server.
+//
+// It is an auth.Observer of the minter, and serves the metrics in the Prometheus text format:
+//
+//	l402_challenges_total{service}                 The challenges issued.
+//	l402_tokens_authorized_total{service}          The tokens accepted.
+//	l402_tokens_rejected_total{service,reason}     The tokens rejected, by reason.
+//	l402_invoiced_sats_total{service}              The sats of the challenges issued.
+//	l402_settled_sats_total{service}               The sats received for the challenges, see Settled.
+//	l402_lightning_duration_seconds{call,result}   The latency of the Lightning backend.
+//	l402_upstream_duration_seconds{service,code}   The latency of the upstream backends.
+//
+// The service label is the service ID, e.g. image:0.
+type Metrics struct {
+	Registry *Registry
+
+	challenges Counter
+	authorized Counter
+	rejected   Counter
+	invoiced   Counter
+	settled    Counter
+	lightning  Histogram
+	upstream   Histogram
+
+	mu        sync.Mutex
+	unsettled map[lntypes.Hash]service.ServiceID
+}
+
+// Create a new Metrics with its own registry.
+func New() *Metrics {
+	r := NewRegistry()
+	return &Metrics{
+		Registry:   r,
+		challenges: r.Counter("l402_challenges_total", "The challenges issued.", "service"),
+		authorized: r.Counter("l402_tokens_authorized_total", "The tokens accepted.", "service"),
+		rejected:   r.Counter("l402_tokens_rejected_total", "The tokens rejected, by reason.", "service", "reason"),
+		invoiced:   r.Counter("l402_invoiced_sats_total", "The sats of the challenges issued.", "service"),
+		settled:    r.Counter("l402_settled_sats_total", "The sats received for the challenges.", "service"),
+		lightning:  r.Histogram("l402_lightning_duration_seconds", "The latency of the calls to the Lightning backend.", DefaultBuckets, "call", "result"),
+		upstream:   r.Histogram("l402_upstream_duration_seconds", "The latency of the requests forwarded to the upstream backends.", DefaultBuckets, "service", "code"),
+		unsettled:  make(map[lntypes.Hash]service.ServiceID),
+	}
+}
+
+// ServeHTTP answers with the metrics in the Prometheus text format.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.Registry.ServeHTTP(w, r)
+}
+
+func sats(msat amount.MilliSatoshi) float64 {
+	return float64(msat) / float64(amount.Satoshi)
+}
+
+// Challenged records a challenge issued for a service.
+func (m *Metrics) Challenged(id service.ServiceID, invoice challenge.InvoiceResponse) {
+	m.challenges.Inc(id.String())
+	m.invoiced.Add(sats(invoice.Amount), id.String())
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.unsettled) >= maxUnsettled {
+		// Forget an arbitrary challenge, most are never paid.
+		for hash := range m.unsettled {
+			delete(m.unsettled, hash)
+			break
+		}
+	}
+	m.unsettled[invoice.PaymentHash] = id
+}
+
+// Authorized records a token accepted, or rejected with an error, for a service.
+func (m *Metrics) Authorized(id service.ServiceID, err error) {
+	if err == nil {
+		m.authorized.Inc(id.String())
+		return
+	}
+	m.rejected.Inc(id.String(), Reason(err))
+}
+
+// Reason returns the reason label of an error rejecting a token.
+func Reason(err error) string {
+	switch {
+	case errors.Is(err, auth.ErrInvalidScheme), errors.Is(err, auth.ErrInvalidAuth):
+		return ReasonMalformed
+	case errors.Is(err, auth.ErrPaymentHash):
+		return ReasonPaymentHash
+	case errors.Is(err, auth.ErrSignature):
+		return ReasonSignature
+	case errors.Is(err, auth.ErrNotGranted):
+		return ReasonNotGranted
+	case errors.Is(err, auth.ErrSpent):
+		return ReasonSpent
+	}
+	return ReasonCaveats
+}
+
+// Settled records the payment of a challenge.
+//
+// It is meant as the OnSettle hook of the challenge.PendingChallenges. The payments of
+// challenges this Metrics did not see issued are recorded with the unknown service.
+//
+// The payments are only observed through the settlement events, the settled sats are not
+// counted when the tokens are verified without them.
+func (m *Metrics) Settled(status challenge.ChallengeStatus) {
+	m.mu.Lock()
+	id, ok := m.unsettled[status.PaymentHash]
+	delete(m.unsettled, status.PaymentHash)
+	m.mu.Unlock()
+
+	label := "unknown"
+	if ok {
+		label = id.String()
+	}
+	m.settled.Add(sats(status.Received), label)
+}
+
+// ObserveLightning records the latency of a call to the Lightning backend.
+func (m *Metrics) ObserveLightning(call string, duration time.Duration, err error) {
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+	m.lightning.Observe(duration.Seconds(), call, result)
+}
+
+// ObserveUpstream records the latency of a request forwarded to the upstream of a service.
+func (m *Metrics) ObserveUpstream(id service.ServiceID, code int, duration time.Duration) {
+	m.upstream.Observe(duration.Seconds(), id.String(), strconv.Itoa(code))
+}
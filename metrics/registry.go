@@ -0,0 +1,223 @@
+// Package metrics records the activity of a proxy server and exposes it in the Prometheus
+// text format.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets are the upper bounds, in seconds, of the latency histograms.
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// A series of a family, for one set of label values.
+type series struct {
+	values []string
+	value  float64  // The value of a counter, or the sum of a histogram.
+	counts []uint64 // The observations of a histogram, per bucket.
+	count  uint64
+}
+
+// A family of metrics sharing a name, with one series per set of label values.
+type family struct {
+	name    string
+	help    string
+	kind    string // counter or histogram.
+	labels  []string
+	buckets []float64
+
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+// get returns the series of the label values, which the lock must be held for.
+func (f *family) get(values []string) *series {
+	if len(values) != len(f.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", f.name, len(f.labels), len(values)))
+	}
+
+	key := strings.Join(values, "\xff")
+	s, ok := f.series[key]
+	if !ok {
+		s = &series{values: append([]string(nil), values...)}
+		if f.kind == "histogram" {
+			s.counts = make([]uint64, len(f.buckets))
+		}
+		f.series[key] = s
+	}
+	return s
+}
+
+// Counter is a value that only increases.
+type Counter struct{ f *family }
+
+// Add a non-negative value to the series of the label values.
+func (c Counter) Add(value float64, labelValues ...string) {
+	if value < 0 {
+		return
+	}
+	c.f.mu.Lock()
+	defer c.f.mu.Unlock()
+	c.f.get(labelValues).value += value
+}
+
+// Inc adds one to the series of the label values.
+func (c Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Histogram counts observations in buckets.
+type Histogram struct{ f *family }
+
+// Observe a value in the series of the label values.
+func (h Histogram) Observe(value float64, labelValues ...string) {
+	h.f.mu.Lock()
+	defer h.f.mu.Unlock()
+
+	s := h.f.get(labelValues)
+	for i, bound := range h.f.buckets {
+		if value <= bound {
+			s.counts[i]++
+		}
+	}
+	s.value += value
+	s.count++
+}
+
+// Registry holds families of metrics and writes them in the Prometheus text format.
+type Registry struct {
+	mu       sync.Mutex
+	families []*family
+}
+
+// Create an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (r *Registry) register(f *family) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, existing := range r.families {
+		if existing.name == f.name {
+			panic("metrics: duplicate metric " + f.name)
+		}
+	}
+	f.series = make(map[string]*series)
+	r.families = append(r.families, f)
+}
+
+// Counter registers a counter with the names of its labels.
+func (r *Registry) Counter(name, help string, labels ...string) Counter {
+	f := &family{name: name, help: help, kind: "counter", labels: labels}
+	r.register(f)
+	return Counter{f}
+}
+
+// Histogram registers a histogram with the upper bounds of its buckets, in increasing order,
+// and the names of its labels.
+func (r *Registry) Histogram(name, help string, buckets []float64, labels ...string) Histogram {
+	f := &family{name: name, help: help, kind: "histogram", labels: labels, buckets: buckets}
+	r.register(f)
+	return Histogram{f}
+}
+
+// WriteTo writes the metrics in the Prometheus text format.
+//
+// The series are sorted by label values, so that the output is stable.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	families := append([]*family(nil), r.families...)
+	r.mu.Unlock()
+
+	counter := &countingWriter{w: w}
+	buf := bufio.NewWriter(counter)
+	for _, f := range families {
+		f.write(buf)
+	}
+	err := buf.Flush()
+	return counter.n, err
+}
+
+// ServeHTTP answers with the metrics.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	r.WriteTo(w)
+}
+
+func (f *family) write(w *bufio.Writer) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n", f.name, escapeHelp(f.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", f.name, f.kind)
+
+	keys := make([]string, 0, len(f.series))
+	for key := range f.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		s := f.series[key]
+		if f.kind == "counter" {
+			fmt.Fprintf(w, "%s%s %s\n", f.name, f.labelSet(s.values, ""), formatFloat(s.value))
+			continue
+		}
+
+		for i, bound := range f.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", f.name, f.labelSet(s.values, formatFloat(bound)), s.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", f.name, f.labelSet(s.values, "+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", f.name, f.labelSet(s.values, ""), formatFloat(s.value))
+		fmt.Fprintf(w, "%s_count%s %d\n", f.name, f.labelSet(s.values, ""), s.count)
+	}
+}
+
+// labelSet formats the labels of a series, with the le label of a bucket if set.
+func (f *family) labelSet(values []string, le string) string {
+	pairs := make([]string, 0, len(values)+1)
+	for i, value := range values {
+		pairs = append(pairs, fmt.Sprintf("%s=\"%s\"", f.labels[i], escapeLabel(value)))
+	}
+	if le != "" {
+		pairs = append(pairs, fmt.Sprintf("le=\"%s\"", le))
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatFloat(value float64) string {
+	if math.IsInf(value, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string  { return helpEscaper.Replace(s) }
+func escapeLabel(s string) string { return labelEscaper.Replace(s) }
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
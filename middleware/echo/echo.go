@@ -0,0 +1,35 @@
+// Package echo adapts the L402 middleware to echo.
+package echo
+
+import (
+	"lsat/auth"
+	"lsat/middleware"
+	"lsat/service"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+// L402 requires the requests to carry a paid token of the service, see middleware.L402.
+func L402(minter *auth.Minter, id service.ServiceID) echo.MiddlewareFunc {
+	return Adapt(middleware.L402(minter, id))
+}
+
+// Adapt adapts a middleware to echo.
+//
+// Unlike echo.WrapMiddleware, the errors of the next handlers are answered by the error
+// handler of echo before the middleware returns, so that the middleware sees their status.
+func Adapt(middleware func(http.Handler) http.Handler) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				c.SetRequest(r)
+				c.SetResponse(echo.NewResponse(w, c.Echo()))
+				if err := next(c); err != nil {
+					c.Error(err)
+				}
+			})).ServeHTTP(c.Response(), c.Request())
+			return nil
+		}
+	}
+}
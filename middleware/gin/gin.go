@@ -0,0 +1,65 @@
+// Package gin adapts the L402 middleware to gin.
+package gin
+
+import (
+	"lsat/auth"
+	"lsat/middleware"
+	"lsat/service"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// L402 requires the requests to carry a paid token of the service, see middleware.L402.
+func L402(minter *auth.Minter, id service.ServiceID) gin.HandlerFunc {
+	return Adapt(middleware.L402(minter, id))
+}
+
+// Adapt adapts a middleware to gin.
+//
+// The request and the writer passed on by the middleware replace those of the context, so
+// that the middleware sees the responses of the next handlers, and the chain is aborted if
+// the middleware answers the request itself.
+func Adapt(middleware func(http.Handler) http.Handler) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		passed := false
+		writer := c.Writer
+		middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			passed = true
+			c.Request = r
+			c.Writer = &responseWriter{ResponseWriter: writer, w: w}
+			c.Next()
+			c.Writer = writer
+		})).ServeHTTP(writer, c.Request)
+
+		if !passed {
+			c.Abort()
+		}
+	}
+}
+
+// responseWriter is the writer of a gin context writing through the writer of a middleware.
+type responseWriter struct {
+	gin.ResponseWriter
+	w http.ResponseWriter // The writer passed on by the middleware.
+}
+
+func (r *responseWriter) WriteHeader(status int) {
+	r.w.WriteHeader(status)
+}
+
+func (r *responseWriter) Write(p []byte) (int, error) {
+	return r.w.Write(p)
+}
+
+func (r *responseWriter) WriteString(s string) (int, error) {
+	return r.w.Write([]byte(s))
+}
+
+// WriteHeaderNow writes the status set so far, through the writer of the middleware.
+func (r *responseWriter) WriteHeaderNow() {
+	if !r.Written() {
+		r.w.WriteHeader(r.Status())
+	}
+	r.ResponseWriter.WriteHeaderNow()
+}
@@ -0,0 +1,399 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"lsat/auth"
+	"lsat/challenge"
+	"lsat/macaroon"
+	"lsat/problem"
+	"lsat/secrets"
+	"lsat/service"
+	"net/http"
+	"strings"
+	"sync"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// AuthorizationMetadata is the metadata key of the credentials, as in the Authorization header.
+	AuthorizationMetadata = "authorization"
+	// MacaroonTrailer is the trailer key of the macaroon of a challenge, base64 encoded.
+	MacaroonTrailer = "l402-macaroon"
+	// InvoiceTrailer is the trailer key of the invoice of a challenge.
+	InvoiceTrailer = "l402-invoice"
+)
+
+// authorizeCall verifies the token in the metadata of a call.
+//
+// Calls without L402 credentials, or with a spent token, fail with codes.Unauthenticated and the
+// challenge in the returned trailer, and the other errors with the code of their problem.
+func authorizeCall(ctx context.Context, minter *auth.Minter, id service.ServiceID) (context.Context, metadata.MD, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	values := md.Get(AuthorizationMetadata)
+	if len(values) == 0 || !auth.HasScheme(values[0]) {
+		return challengeCall(ctx, minter, id)
+	}
+
+	token, err := Authorize(minter, values[0], id)
+	if errors.Is(err, auth.ErrSpent) {
+		return challengeCall(ctx, minter, id)
+	} else if err != nil {
+		return nil, nil, callError(err)
+	}
+
+	return withToken(ctx, token), nil, nil
+}
+
+// challengeCall fails a call with a challenge in the trailer.
+func challengeCall(ctx context.Context, minter *auth.Minter, id service.ServiceID) (context.Context, metadata.MD, error) {
+	preToken, err := minter.MintTokenContext(ctx, secrets.NewUserId(), id)
+	if err != nil {
+		return nil, nil, callError(err)
+	}
+
+	trailer := metadata.Pairs(MacaroonTrailer, preToken.Macaroon.String(), InvoiceTrailer, preToken.InvoiceResponse.Invoice)
+	return nil, trailer, status.Error(codes.Unauthenticated, "Payment Required")
+}
+
+// callError converts an error to the status of a call, with the code matching the status code
+// of its problem, see problem.From.
+func callError(err error) error {
+	p := problem.From(err)
+	code := codes.Internal
+	switch p.Status {
+	case http.StatusBadRequest:
+		code = codes.InvalidArgument
+	case http.StatusUnauthorized:
+		code = codes.Unauthenticated
+	case http.StatusForbidden:
+		code = codes.PermissionDenied
+	case http.StatusNotFound:
+		code = codes.NotFound
+	case http.StatusTooManyRequests:
+		code = codes.ResourceExhausted
+	case http.StatusServiceUnavailable:
+		code = codes.Unavailable
+	}
+	if p.Detail == "" {
+		return status.Error(code, p.Title)
+	}
+	return status.Error(code, p.Detail)
+}
+
+// UnaryServerInterceptor requires the unary calls to carry a paid token of the service.
+//
+// The token is read from the authorization metadata, in the format of the Authorization header.
+// Calls without a token fail with codes.Unauthenticated and a challenge in the l402-macaroon
+// and l402-invoice trailers. The verified token and its caveats are put in the context of the call.
+func UnaryServerInterceptor(minter *auth.Minter, id service.ServiceID) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		authorized, trailer, err := authorizeCall(ctx, minter, id)
+		if err != nil {
+			if trailer != nil {
+				grpc.SetTrailer(ctx, trailer)
+			}
+			return nil, err
+		}
+		return handler(authorized, req)
+	}
+}
+
+// StreamServerInterceptor requires the streams to carry a paid token of the service.
+//
+// See UnaryServerInterceptor.
+func StreamServerInterceptor(minter *auth.Minter, id service.ServiceID) grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		authorized, trailer, err := authorizeCall(stream.Context(), minter, id)
+		if err != nil {
+			if trailer != nil {
+				stream.SetTrailer(trailer)
+			}
+			return err
+		}
+		return handler(srv, &authorizedStream{ServerStream: stream, ctx: authorized})
+	}
+}
+
+// A server stream with the context of its verified token.
+type authorizedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *authorizedStream) Context() context.Context {
+	return s.ctx
+}
+
+// Payer pays the challenges of gRPC calls and retries them with the paid token.
+//
+// The tokens are kept by service, the target of the connection and the gRPC service of the
+// methods, and sent with the next calls to any method of the service. A token that is refused
+// is forgotten, so that the next call is challenged again.
+//
+// The challenges of a service are paid one at a time: the calls challenged while a token is
+// being paid wait for it and are retried with it, rather than paying again.
+type Payer struct {
+	Node   challenge.LightningNode // The node paying the invoices.
+	Policy challenge.InvoicePolicy // The invoices are checked against this policy before being paid.
+
+	mu       sync.Mutex
+	tokens   map[string]macaroon.Token
+	payments map[string]*sync.Mutex
+}
+
+// Create a new Payer.
+func NewPayer(node challenge.LightningNode, policy challenge.InvoicePolicy) *Payer {
+	return &Payer{Node: node, Policy: policy, tokens: make(map[string]macaroon.Token)}
+}
+
+// serviceKey returns the key of the tokens of a method called on a target.
+func serviceKey(target string, method string) string {
+	service := strings.TrimPrefix(method, "/")
+	if i := strings.LastIndex(service, "/"); i >= 0 {
+		service = service[:i]
+	}
+	return target + "/" + service
+}
+
+// Token returns the token paid for the service of a method on a target, such as the target
+// of a grpc.ClientConn.
+func (p *Payer) Token(target string, method string) (macaroon.Token, bool) {
+	return p.token(serviceKey(target, method))
+}
+
+func (p *Payer) token(key string) (macaroon.Token, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	token, ok := p.tokens[key]
+	return token, ok
+}
+
+// forget removes the token of a service, unless it was replaced since it was used.
+func (p *Payer) forget(key string, used macaroon.Token) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if token, ok := p.tokens[key]; ok && token.Preimage == used.Preimage {
+		delete(p.tokens, key)
+	}
+}
+
+// paying returns the lock serializing the payments of a service.
+func (p *Payer) paying(key string) *sync.Mutex {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.payments == nil {
+		p.payments = make(map[string]*sync.Mutex)
+	}
+	lock, ok := p.payments[key]
+	if !ok {
+		lock = &sync.Mutex{}
+		p.payments[key] = lock
+	}
+	return lock
+}
+
+// Add the token of a service to the metadata of a call, and return the token sent.
+func (p *Payer) withToken(ctx context.Context, key string) (context.Context, macaroon.Token) {
+	token, ok := p.token(key)
+	if !ok {
+		return ctx, macaroon.Token{}
+	}
+	return metadata.AppendToOutgoingContext(ctx, AuthorizationMetadata, auth.NewAuthorization(token).String()), token
+}
+
+// pay pays the challenge of a call failed with the token used, if any, and keeps the token.
+//
+// It returns whether the call can be retried.
+func (p *Payer) pay(key string, used macaroon.Token, err error, trailer metadata.MD) (bool, error) {
+	if status.Code(err) != codes.Unauthenticated {
+		return false, nil
+	}
+
+	lock := p.paying(key)
+	lock.Lock()
+	defer lock.Unlock()
+
+	// Another call may have paid for the service while this one was challenged.
+	if token, ok := p.token(key); ok && token.Preimage != used.Preimage {
+		return true, nil
+	}
+
+	macaroons, invoices := trailer.Get(MacaroonTrailer), trailer.Get(InvoiceTrailer)
+	if len(macaroons) == 0 || len(invoices) == 0 {
+		p.forget(key, used)
+		return false, nil
+	}
+
+	preToken, err := auth.Challenge{Macaroon: macaroons[0], Invoice: invoices[0]}.PreToken()
+	if err != nil {
+		return false, err
+	}
+
+	token, err := preToken.PayWithPolicy(p.Node, p.Policy)
+	if err != nil {
+		return false, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.tokens == nil {
+		p.tokens = make(map[string]macaroon.Token)
+	}
+	p.tokens[key] = token
+	return true, nil
+}
+
+// UnaryClientInterceptor pays the challenges of unary calls and retries them once.
+func (p *Payer) UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		key := serviceKey(cc.Target(), method)
+
+		var trailer metadata.MD
+		callCtx, used := p.withToken(ctx, key)
+		err := invoker(callCtx, method, req, reply, cc, append(opts, grpc.Trailer(&trailer))...)
+		if err == nil {
+			return nil
+		}
+
+		retry, payErr := p.pay(key, used, err, trailer)
+		if payErr != nil {
+			return payErr
+		} else if !retry {
+			return err
+		}
+		callCtx, _ = p.withToken(ctx, key)
+		return invoker(callCtx, method, req, reply, cc, opts...)
+	}
+}
+
+// StreamClientInterceptor pays the challenges of streams and retries them once.
+//
+// The challenge is only known once the first message is received, so the messages sent
+// until then are kept and sent again on the new stream. They must not be modified after
+// being sent.
+func (p *Payer) StreamClientInterceptor() grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		key := serviceKey(cc.Target(), method)
+		open := func() (grpc.ClientStream, macaroon.Token, error) {
+			streamCtx, used := p.withToken(ctx, key)
+			stream, err := streamer(streamCtx, desc, cc, method, opts...)
+			return stream, used, err
+		}
+
+		stream, used, err := open()
+		if err != nil {
+			return nil, err
+		}
+		return &payingStream{payer: p, key: key, open: open, stream: stream, used: used}, nil
+	}
+}
+
+// A client stream opened again with a paid token when it is challenged.
+type payingStream struct {
+	payer *Payer
+	key   string
+	open  func() (grpc.ClientStream, macaroon.Token, error)
+
+	mu       sync.Mutex
+	stream   grpc.ClientStream
+	used     macaroon.Token
+	sent     []interface{}
+	closed   bool
+	received bool
+	retried  bool
+}
+
+func (s *payingStream) current() grpc.ClientStream {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stream
+}
+
+func (s *payingStream) Header() (metadata.MD, error) {
+	return s.current().Header()
+}
+
+func (s *payingStream) Trailer() metadata.MD {
+	return s.current().Trailer()
+}
+
+func (s *payingStream) Context() context.Context {
+	return s.current().Context()
+}
+
+func (s *payingStream) CloseSend() error {
+	s.mu.Lock()
+	s.closed = true
+	s.mu.Unlock()
+	return s.current().CloseSend()
+}
+
+func (s *payingStream) SendMsg(m interface{}) error {
+	s.mu.Lock()
+	if !s.received && !s.retried {
+		s.sent = append(s.sent, m)
+	}
+	s.mu.Unlock()
+	return s.current().SendMsg(m)
+}
+
+func (s *payingStream) RecvMsg(m interface{}) error {
+	stream := s.current()
+	err := stream.RecvMsg(m)
+
+	s.mu.Lock()
+	if err == nil {
+		s.received = true
+		s.sent = nil
+	}
+	first := !s.received && !s.retried
+	used := s.used
+	s.mu.Unlock()
+	if err == nil || !first {
+		return err
+	}
+
+	retry, payErr := s.payer.pay(s.key, used, err, stream.Trailer())
+	if payErr != nil {
+		return payErr
+	} else if !retry {
+		return err
+	}
+
+	if err := s.reopen(); err != nil {
+		return err
+	}
+	return s.current().RecvMsg(m)
+}
+
+// reopen opens the stream again and sends the messages sent so far.
+func (s *payingStream) reopen() error {
+	stream, used, err := s.open()
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.stream = stream
+	s.used = used
+	s.retried = true
+	sent, closed := s.sent, s.closed
+	s.sent = nil
+	s.mu.Unlock()
+
+	for _, m := range sent {
+		// A failed send is reported by the next RecvMsg.
+		if stream.SendMsg(m) != nil {
+			return nil
+		}
+	}
+	if closed {
+		return stream.CloseSend()
+	}
+	return nil
+}
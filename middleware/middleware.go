@@ -0,0 +1,198 @@
+// Package middleware enforces L402 on net/http handlers and on gRPC servers.
+//
+// The middleware has the standard func(http.Handler) http.Handler signature, so it can be
+// used as is with chi (router.Use) or any router built on net/http. The subpackages gin and
+// echo adapt it to those routers.
+package middleware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"lsat/auth"
+	"lsat/macaroon"
+	"lsat/problem"
+	"lsat/secrets"
+	"lsat/service"
+	"net/http"
+)
+
+type contextKey int
+
+const (
+	tokenKey contextKey = iota
+	caveatsKey
+)
+
+// TokenFromContext returns the verified token of a request.
+func TokenFromContext(ctx context.Context) (macaroon.Token, bool) {
+	token, ok := ctx.Value(tokenKey).(macaroon.Token)
+	return token, ok
+}
+
+// CaveatsFromContext returns the caveats of the verified token of a request.
+func CaveatsFromContext(ctx context.Context) []macaroon.Caveat {
+	caveats, _ := ctx.Value(caveatsKey).([]macaroon.Caveat)
+	return caveats
+}
+
+// Put a verified token and its caveats in a context.
+func withToken(ctx context.Context, token macaroon.Token) context.Context {
+	ctx = context.WithValue(ctx, tokenKey, token)
+	return context.WithValue(ctx, caveatsKey, token.Macaroon.Caveats())
+}
+
+// L402 requires the requests to carry a paid token of the service.
+//
+// Requests without L402 credentials, such as those authenticated with another scheme, or with
+// a spent token of a service paid per request, are answered with a challenge, and requests
+// with invalid credentials are rejected with the problem details of their error, see
+// problem.From. The verified token and its caveats are put in the context of the request.
+//
+// A token of a service paid per request is spent before the request is handled, so that it
+// cannot be used twice concurrently, and released if the handler answers with a 5xx status,
+// so that the client can retry with it.
+func L402(minter *auth.Minter, id service.ServiceID) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !auth.HasScheme(r.Header.Get("Authorization")) {
+				WriteChallenge(w, r, minter, id)
+				return
+			}
+
+			token, err := AuthorizeRequest(minter, r, id)
+			if errors.Is(err, auth.ErrSpent) {
+				WriteChallenge(w, r, minter, id)
+				return
+			} else if err != nil {
+				problem.WriteError(w, err)
+				return
+			}
+
+			recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(recorder, r.WithContext(withToken(r.Context(), token)))
+			if recorder.status >= http.StatusInternalServerError {
+				minter.Release(&token)
+			}
+		})
+	}
+}
+
+// Authorize verifies the token of an Authorization header for a service.
+//
+// Malformed headers fail with auth.ErrInvalidScheme or auth.ErrInvalidAuth, tokens of other
+// services with auth.ErrNotGranted, and spent tokens of services paid per request with
+// auth.ErrSpent. The observer of the minter is notified of the result.
+//
+// There is no request to check, so tokens restricted to some routes or methods fail, see
+// AuthorizeRequest.
+func Authorize(minter *auth.Minter, authHeader string, id service.ServiceID) (macaroon.Token, error) {
+	token, err := authorize(minter, authHeader, nil, id)
+	minter.Observer().Authorized(id, err)
+	return token, err
+}
+
+// AuthorizeRequest verifies the token of the Authorization header of a request for a service,
+// like Authorize, and checks the caveats restricting the routes and methods against the request.
+func AuthorizeRequest(minter *auth.Minter, r *http.Request, id service.ServiceID) (macaroon.Token, error) {
+	token, err := authorize(minter, r.Header.Get("Authorization"), r, id)
+	minter.Observer().Authorized(id, err)
+	return token, err
+}
+
+func authorize(minter *auth.Minter, authHeader string, r *http.Request, id service.ServiceID) (macaroon.Token, error) {
+	authorization, err := auth.ParseAuthorization(authHeader)
+	if err != nil {
+		return macaroon.Token{}, err
+	}
+
+	token, err := authorization.Token()
+	if err != nil {
+		return macaroon.Token{}, err
+	}
+
+	if err := minter.AuthRequest(&token, r); err != nil {
+		return macaroon.Token{}, err
+	}
+
+	if !grantsService(token.Macaroon, id) {
+		return macaroon.Token{}, fmt.Errorf("%w: %s", auth.ErrNotGranted, id)
+	}
+
+	// Spend the token last, so that only valid tokens are spent.
+	if err := minter.Redeem(&token); err != nil {
+		return macaroon.Token{}, err
+	}
+
+	return token, nil
+}
+
+// Whether a macaroon grants access to a service.
+//
+// The first service caveat, added by the minter, grants the access. The holder can add other
+// service caveats without the secret, so they may only narrow it and must match the first.
+func grantsService(mac macaroon.Macaroon, id service.ServiceID) bool {
+	iter := mac.GetValue(macaroon.ServiceKey)
+	if !iter.HasNext() {
+		return false
+	}
+	granted := iter.Next()
+	if granted != id.String() {
+		return false
+	}
+	for iter.HasNext() {
+		if iter.Next() != granted {
+			return false
+		}
+	}
+	return true
+}
+
+// WriteChallenge answers with a challenge to pay for a new token of the service.
+//
+// The challenge is sent with the L402 scheme, and with the legacy LSAT scheme for older clients.
+func WriteChallenge(w http.ResponseWriter, r *http.Request, minter *auth.Minter, id service.ServiceID) {
+	preToken, err := minter.MintTokenContext(r.Context(), secrets.NewUserId(), id)
+	if err != nil {
+		problem.WriteError(w, err)
+		return
+	}
+
+	challenge := auth.NewChallenge(preToken)
+	w.Header().Add("WWW-Authenticate", challenge.String())
+	challenge.Scheme = auth.SchemeLSAT
+	w.Header().Add("WWW-Authenticate", challenge.String())
+
+	problem.Write(w, problem.PaymentRequired())
+}
+
+// statusRecorder remembers the status of a response.
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	if !s.wroteHeader {
+		s.status, s.wroteHeader = status, true
+	}
+	s.ResponseWriter.WriteHeader(status)
+}
+
+func (s *statusRecorder) Write(p []byte) (int, error) {
+	s.wroteHeader = true
+	return s.ResponseWriter.Write(p)
+}
+
+// Flush sends the buffered data, so that the streams still reach the client.
+func (s *statusRecorder) Flush() {
+	if flusher, ok := s.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap returns the wrapped writer, for http.ResponseController.
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
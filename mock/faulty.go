@@ -0,0 +1,233 @@
+package mock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"lsat/challenge"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrInjectedFault is returned when a call fails before reaching the node.
+	ErrInjectedFault = errors.New("injected lightning node failure")
+	// ErrLostResponse is returned when a payment was sent by the node but its response was dropped.
+	ErrLostResponse = fmt.Errorf("the response of the lightning node was lost: %w", challenge.ErrPaymentInFlight)
+	// ErrLostInvoice is returned when an invoice was created by the node but its response was dropped.
+	ErrLostInvoice = errors.New("the invoice created by the lightning node was lost")
+	// ErrInjectedTimeout is returned when a call hangs without a context deadline.
+	ErrInjectedTimeout = fmt.Errorf("injected lightning node timeout: %w", context.DeadlineExceeded)
+)
+
+// The time a call hangs when a timeout is injected and the context has no deadline.
+const defaultFaultTimeout = 30 * time.Second
+
+// FaultConfig describes the failures injected by a FaultyLightningNode.
+//
+// Rates are probabilities between 0 and 1, drawn for every call.
+type FaultConfig struct {
+	Seed             int64         // The seed of the failures, the same seed replays the same failures.
+	Latency          time.Duration // The delay added to every call.
+	Jitter           time.Duration // A random delay, up to Jitter, added to every call.
+	ErrorRate        float64       // The rate of calls failing before reaching the node.
+	TimeoutRate      float64       // The rate of calls hanging until the context is done.
+	Timeout          time.Duration // How long a call hangs when the context has no deadline.
+	LostResponseRate float64       // The rate of calls succeeding on the node but losing the response.
+	SettlementDelay  time.Duration // The time a payment stays in flight before it settles.
+}
+
+// FaultStats counts the failures injected by a FaultyLightningNode.
+type FaultStats struct {
+	Calls          int
+	Errors         int
+	Timeouts       int
+	LostResponses  int
+	DelayedSettles int
+}
+
+// FaultyLightningNode wraps a LightningNode and injects failures in its calls.
+//
+// It is used to test the resilience of the proxy and the clients, with the mock
+// or a real backend. The failures are deterministic for a given seed and sequence
+// of calls.
+type FaultyLightningNode struct {
+	challenge.LightningNode
+	Config FaultConfig
+
+	mu    sync.Mutex
+	rng   *rand.Rand
+	stats FaultStats
+}
+
+// Create a new FaultyLightningNode wrapping the node.
+func NewFaultyLightningNode(node challenge.LightningNode, config FaultConfig) *FaultyLightningNode {
+	return &FaultyLightningNode{
+		LightningNode: node,
+		Config:        config,
+		rng:           rand.New(rand.NewSource(config.Seed)),
+	}
+}
+
+// Stats returns the failures injected so far.
+func (f *FaultyLightningNode) Stats() FaultStats {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.stats
+}
+
+// The failure drawn for a call.
+type fault struct {
+	delay        time.Duration
+	err          bool
+	timeout      bool
+	lostResponse bool
+}
+
+// draw decides the failure of the next call.
+//
+// Every draw consumes the same amount of randomness, so that changing a rate does
+// not shift the failures of the following calls.
+func (f *FaultyLightningNode) draw() fault {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.rng == nil {
+		f.rng = rand.New(rand.NewSource(f.Config.Seed))
+	}
+
+	errDraw, timeoutDraw, lostDraw, jitterDraw := f.rng.Float64(), f.rng.Float64(), f.rng.Float64(), f.rng.Float64()
+
+	ft := fault{
+		delay:        f.Config.Latency + time.Duration(jitterDraw*float64(f.Config.Jitter)),
+		err:          errDraw < f.Config.ErrorRate,
+		timeout:      timeoutDraw < f.Config.TimeoutRate,
+		lostResponse: lostDraw < f.Config.LostResponseRate,
+	}
+
+	f.stats.Calls++
+	switch {
+	case ft.err:
+		f.stats.Errors++
+	case ft.timeout:
+		f.stats.Timeouts++
+	case ft.lostResponse:
+		f.stats.LostResponses++
+	}
+
+	return ft
+}
+
+// before applies the failures injected before the call reaches the node.
+func (f *FaultyLightningNode) before(ctx context.Context, ft fault) error {
+	if err := sleep(ctx, ft.delay); err != nil {
+		return err
+	}
+
+	if ft.err {
+		return ErrInjectedFault
+	}
+
+	if ft.timeout {
+		timeout := f.Config.Timeout
+		if timeout == 0 {
+			timeout = defaultFaultTimeout
+		}
+		if err := sleep(ctx, timeout); err != nil {
+			return err
+		}
+		return ErrInjectedTimeout
+	}
+
+	return nil
+}
+
+func (f *FaultyLightningNode) CreateInvoice(ctx context.Context, req challenge.CreateInvoiceRequest) (challenge.InvoiceResponse, error) {
+	ft := f.draw()
+
+	if err := f.before(ctx, ft); err != nil {
+		return challenge.InvoiceResponse{}, err
+	}
+
+	response, err := f.LightningNode.CreateInvoice(ctx, req)
+	if err != nil {
+		return challenge.InvoiceResponse{}, err
+	}
+
+	if ft.lostResponse {
+		return challenge.InvoiceResponse{}, ErrLostInvoice
+	}
+
+	return response, nil
+}
+
+func (f *FaultyLightningNode) PayInvoice(ctx context.Context, req challenge.PayInvoiceRequest) (challenge.PayInvoiceResponse, error) {
+	ft := f.draw()
+
+	if err := f.before(ctx, ft); err != nil {
+		return challenge.PayInvoiceResponse{}, err
+	}
+
+	var response challenge.PayInvoiceResponse
+	var err error
+	if f.Config.SettlementDelay > 0 {
+		response, err = f.payDelayed(ctx, req)
+	} else {
+		response, err = f.LightningNode.PayInvoice(ctx, req)
+	}
+	if err != nil {
+		return challenge.PayInvoiceResponse{}, err
+	}
+
+	if ft.lostResponse {
+		return challenge.PayInvoiceResponse{}, ErrLostResponse
+	}
+
+	return response, nil
+}
+
+// payDelayed sends a payment that stays in flight for the SettlementDelay before it settles.
+//
+// The caller may give up before, but the payment is not cancelled: it settles anyway, as a
+// payment already sent on the network, so the error wraps challenge.ErrPaymentInFlight.
+func (f *FaultyLightningNode) payDelayed(ctx context.Context, req challenge.PayInvoiceRequest) (challenge.PayInvoiceResponse, error) {
+	f.mu.Lock()
+	f.stats.DelayedSettles++
+	f.mu.Unlock()
+
+	type result struct {
+		response challenge.PayInvoiceResponse
+		err      error
+	}
+	settled := make(chan result, 1)
+	go func() {
+		time.Sleep(f.Config.SettlementDelay)
+		response, err := f.LightningNode.PayInvoice(context.Background(), req)
+		settled <- result{response, err}
+	}()
+
+	select {
+	case r := <-settled:
+		return r.response, r.err
+	case <-ctx.Done():
+		return challenge.PayInvoiceResponse{}, fmt.Errorf("%w: %w", challenge.ErrPaymentInFlight, ctx.Err())
+	}
+}
+
+// sleep waits for the duration or until the context is done.
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
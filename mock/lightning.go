@@ -2,80 +2,229 @@ package mock
 
 import (
 	"context"
-	"encoding/base64"
-	"encoding/json"
+	"encoding/hex"
 	"errors"
+	"fmt"
+	"lsat/amount"
 	"lsat/challenge"
 	"lsat/secrets"
 	"math"
+	"sync"
+	"time"
 
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/ecdsa"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
 	"github.com/lightningnetwork/lnd/lntypes"
+	"github.com/lightningnetwork/lnd/zpay32"
 )
 
+// Network is the network of the invoices issued by the mock nodes.
+var Network = &chaincfg.RegressionNetParams
+
+// The default expiry of the invoices.
+const invoiceExpiry = time.Hour
+
+var (
+	ErrUnknownInvoice    = errors.New("unknown invoice")
+	ErrAlreadyPaid       = errors.New("the invoice is already paid")
+	ErrUnknownNode       = errors.New("no route to the destination node")
+	ErrExpiredInvoice    = errors.New("the invoice is expired")
+	ErrInsufficientFunds = errors.New("insufficient balance")
+)
+
+// TestLightningNode is an in-memory Lightning node issuing signed regtest BOLT11 invoices.
+//
+// The preimages of the invoices stay private to the node that issued them. They are only
+// revealed when another node, or the node itself, pays the invoice. The nodes of a process
+// share an in-memory network, so every node can pay the invoices of the others, until they
+// are closed.
+//
+// The zero value is ready to use and the node is safe for concurrent use.
 type TestLightningNode struct {
-	Balance uint64
+	Balance amount.MilliSatoshi
+
+	mu       sync.Mutex
+	key      *btcec.PrivateKey
+	invoices map[lntypes.Hash]*invoice
+	// onSettle is called once an invoice of the node is paid, without the lock held. It is read
+	// with the lock held.
+	onSettle func(preimage lntypes.Preimage, price amount.MilliSatoshi)
 }
 
+// An invoice issued by a node.
 type invoice struct {
-	RawInvoice string `json:"raw_invoice"`
-	Amount     uint64 `json:"amount"`
+	preimage lntypes.Preimage
+	amount   amount.MilliSatoshi
+	paid     bool
+}
+
+// The nodes of the in-memory network, by public key.
+var network = struct {
+	sync.Mutex
+	nodes map[string]*TestLightningNode
+}{nodes: make(map[string]*TestLightningNode)}
+
+// Create a new TestLightningNode with the given balance.
+func NewLightningNode(balance amount.MilliSatoshi) *TestLightningNode {
+	return &TestLightningNode{Balance: balance}
 }
 
 func NewChallenger() challenge.Challenger {
-	return &challenge.ChallengeFactory{LightningNode: &TestLightningNode{Balance: math.MaxUint64}}
+	return &challenge.ChallengeFactory{LightningNode: NewLightningNode(math.MaxUint64)}
+}
+
+// GetBalance returns the balance of the node, safe to call while payments are in flight.
+func (ln *TestLightningNode) GetBalance() amount.MilliSatoshi {
+	return ln.balance()
+}
+
+// init generates the key of the node and joins the network, the lock must be held.
+func (ln *TestLightningNode) init() error {
+	if ln.key != nil {
+		return nil
+	}
+
+	key, err := btcec.NewPrivateKey()
+	if err != nil {
+		return err
+	}
+
+	ln.key = key
+	ln.invoices = make(map[lntypes.Hash]*invoice)
+
+	network.Lock()
+	network.nodes[nodeId(key.PubKey())] = ln
+	network.Unlock()
+
+	return nil
+}
+
+// Close removes the node from the network, so that its invoices can no longer be paid and the
+// node is not kept for the rest of the process. The node can still pay the others.
+func (ln *TestLightningNode) Close() {
+	ln.mu.Lock()
+	defer ln.mu.Unlock()
+
+	if ln.key == nil {
+		return
+	}
+
+	network.Lock()
+	defer network.Unlock()
+
+	id := nodeId(ln.key.PubKey())
+	if network.nodes[id] == ln {
+		delete(network.nodes, id)
+	}
+}
+
+// PubKey returns the public key of the node.
+func (ln *TestLightningNode) PubKey() (*btcec.PublicKey, error) {
+	ln.mu.Lock()
+	defer ln.mu.Unlock()
+
+	if err := ln.init(); err != nil {
+		return nil, err
+	}
+
+	return ln.key.PubKey(), nil
+}
+
+func nodeId(key *btcec.PublicKey) string {
+	return hex.EncodeToString(key.SerializeCompressed())
 }
 
 func (ln *TestLightningNode) CreateInvoice(ctx context.Context, req challenge.CreateInvoiceRequest) (challenge.InvoiceResponse, error) {
+	ln.mu.Lock()
+	defer ln.mu.Unlock()
+
+	if err := ln.init(); err != nil {
+		return challenge.InvoiceResponse{}, err
+	}
+
 	secret := secrets.NewSecret()
+	preimage, err := lntypes.MakePreimage(secret[:])
+	if err != nil {
+		return challenge.InvoiceResponse{}, err
+	}
 
-	// xor the preimage to build the invoice
-	rawInvoice := xor(secret[:])
+	options := []func(*zpay32.Invoice){
+		zpay32.PaymentAddr(secrets.NewSecret()),
+		zpay32.Expiry(invoiceExpiry),
+	}
 
-	inv := invoice{
-		RawInvoice: base64.StdEncoding.EncodeToString(rawInvoice),
-		Amount:     req.Amount,
+	if req.Amount > 0 {
+		options = append(options, zpay32.Amount(req.Amount.ToLnwire()))
 	}
 
-	invoiceJSON, err := json.Marshal(inv)
+	if req.DescriptionHash != (lntypes.Hash{}) {
+		options = append(options, zpay32.DescriptionHash(req.DescriptionHash))
+	} else {
+		options = append(options, zpay32.Description(req.Description))
+	}
+
+	bolt11, err := zpay32.NewInvoice(Network, preimage.Hash(), time.Now(), options...)
 	if err != nil {
 		return challenge.InvoiceResponse{}, err
 	}
 
-	preimage, err := lntypes.MakePreimage(secret[:])
+	serialized, err := bolt11.Encode(zpay32.MessageSigner{
+		SignCompact: func(msg []byte) ([]byte, error) {
+			return ecdsa.SignCompact(ln.key, chainhash.HashB(msg), true)
+		},
+	})
 	if err != nil {
 		return challenge.InvoiceResponse{}, err
 	}
 
+	ln.invoices[preimage.Hash()] = &invoice{preimage: preimage, amount: req.Amount}
+
 	return challenge.InvoiceResponse{
 		PaymentHash: preimage.Hash(),
-		Invoice:     base64.StdEncoding.EncodeToString(invoiceJSON),
+		Invoice:     serialized,
+		Amount:      req.Amount,
 	}, nil
 }
 
 func (ln *TestLightningNode) PayInvoice(ctx context.Context, req challenge.PayInvoiceRequest) (challenge.PayInvoiceResponse, error) {
-	invoiceJSON, err := base64.StdEncoding.DecodeString(req.Invoice)
+	bolt11, err := zpay32.Decode(req.Invoice, Network)
 	if err != nil {
 		return challenge.PayInvoiceResponse{}, err
 	}
 
-	var inv invoice
-	err = json.Unmarshal(invoiceJSON, &inv)
-	if err != nil {
-		return challenge.PayInvoiceResponse{}, err
+	if time.Now().After(bolt11.Timestamp.Add(bolt11.Expiry())) {
+		return challenge.PayInvoiceResponse{}, ErrExpiredInvoice
 	}
 
-	if inv.Amount > ln.Balance {
-		return challenge.PayInvoiceResponse{}, errors.New("insufficient balance")
+	network.Lock()
+	payee, ok := network.nodes[nodeId(bolt11.Destination)]
+	network.Unlock()
+
+	if !ok {
+		return challenge.PayInvoiceResponse{}, ErrUnknownNode
 	}
 
-	decodedInvoice, _ := base64.StdEncoding.DecodeString(inv.RawInvoice)
+	// The amount is charged to the exact millisatoshi.
+	price := req.Amount
+	if bolt11.MilliSat != nil {
+		price = amount.FromLnwire(*bolt11.MilliSat)
+	}
 
-	preimage, err := lntypes.MakePreimage(xor(decodedInvoice))
+	if err := ln.debit(price); err != nil {
+		return challenge.PayInvoiceResponse{}, err
+	}
+
+	preimage, onSettle, err := payee.settle(*bolt11.PaymentHash, price)
 	if err != nil {
+		ln.credit(price)
 		return challenge.PayInvoiceResponse{}, err
 	}
 
-	ln.Balance -= inv.Amount
+	if onSettle != nil {
+		onSettle(preimage, price)
+	}
 
 	return challenge.PayInvoiceResponse{
 		PaymentId:   preimage.Hash().String(),
@@ -84,11 +233,55 @@ func (ln *TestLightningNode) PayInvoice(ctx context.Context, req challenge.PayIn
 	}, nil
 }
 
-func xor(data []byte) []byte {
-	maxByte := byte(math.MaxUint8)
-	result := make([]byte, len(data))
-	for i, b := range data {
-		result[i] = b ^ maxByte
+// settle marks an invoice paid and reveals its preimage, with the callback of the node.
+func (ln *TestLightningNode) settle(hash lntypes.Hash, price amount.MilliSatoshi) (lntypes.Preimage, func(lntypes.Preimage, amount.MilliSatoshi), error) {
+	ln.mu.Lock()
+	defer ln.mu.Unlock()
+
+	inv, ok := ln.invoices[hash]
+	if !ok {
+		return lntypes.Preimage{}, nil, ErrUnknownInvoice
+	}
+
+	if inv.paid {
+		return lntypes.Preimage{}, nil, ErrAlreadyPaid
+	}
+
+	if price < inv.amount {
+		return lntypes.Preimage{}, nil, fmt.Errorf("the payment of %s is below the invoice amount of %s", price, inv.amount)
+	}
+
+	inv.paid = true
+	if ln.Balance <= math.MaxUint64-price {
+		ln.Balance += price
+	}
+
+	return inv.preimage, ln.onSettle, nil
+}
+
+// balance reads the balance of the node.
+func (ln *TestLightningNode) balance() amount.MilliSatoshi {
+	ln.mu.Lock()
+	defer ln.mu.Unlock()
+
+	return ln.Balance
+}
+
+func (ln *TestLightningNode) debit(price amount.MilliSatoshi) error {
+	ln.mu.Lock()
+	defer ln.mu.Unlock()
+
+	if price > ln.Balance {
+		return ErrInsufficientFunds
 	}
-	return result
+
+	ln.Balance -= price
+	return nil
+}
+
+func (ln *TestLightningNode) credit(price amount.MilliSatoshi) {
+	ln.mu.Lock()
+	defer ln.mu.Unlock()
+
+	ln.Balance += price
 }
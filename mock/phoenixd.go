@@ -0,0 +1,491 @@
+package mock
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"lsat/amount"
+	"lsat/challenge"
+	"lsat/phoenixd"
+	"lsat/phoenixd/webhook"
+	"lsat/secrets"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lightningnetwork/lnd/lntypes"
+	"github.com/lightningnetwork/lnd/zpay32"
+	"golang.org/x/net/websocket"
+)
+
+// The number of events buffered for each websocket connection, newer events are dropped.
+const eventBuffer = 256
+
+// FakePhoenixd is an in-process phoenixd serving its HTTP API on top of a TestLightningNode.
+//
+// It keeps the invoices and payments in memory. Since it relies on the in-memory network of
+// the mock nodes, two FakePhoenixd of the same process can pay each other. Serve it with
+// httptest.NewServer in tests, or with http.ListenAndServe.
+type FakePhoenixd struct {
+	Node *TestLightningNode
+	// Webhook is the URL the payment_received events are posted to, if set.
+	Webhook string
+	// WebhookSecret is the secret signing the events posted to the Webhook.
+	WebhookSecret string
+
+	password string
+	mux      *http.ServeMux
+
+	mu          sync.Mutex
+	incoming    map[string]*phoenixd.Payment
+	outgoing    map[string]*phoenixd.OutgoingPayment
+	subscribers map[chan phoenixd.PaymentReceived]struct{}
+}
+
+// Create a FakePhoenixd accepting the password and holding the balance.
+func NewFakePhoenixd(password string, balance amount.MilliSatoshi) *FakePhoenixd {
+	f := &FakePhoenixd{
+		Node:        NewLightningNode(balance),
+		password:    password,
+		mux:         http.NewServeMux(),
+		incoming:    make(map[string]*phoenixd.Payment),
+		outgoing:    make(map[string]*phoenixd.OutgoingPayment),
+		subscribers: make(map[chan phoenixd.PaymentReceived]struct{}),
+	}
+	f.Node.onSettle = f.settled
+
+	f.mux.HandleFunc("/createinvoice", f.post(f.createInvoice))
+	f.mux.HandleFunc("/payinvoice", f.post(f.payInvoice))
+	f.mux.HandleFunc("/decodeinvoice", f.post(f.decodeInvoice))
+	f.mux.HandleFunc("/getinfo", f.get(f.getInfo))
+	f.mux.HandleFunc("/getbalance", f.get(f.getBalance))
+	f.mux.HandleFunc("/payments/incoming", f.get(f.listIncoming))
+	f.mux.HandleFunc("/payments/incoming/", f.get(f.getIncoming))
+	f.mux.HandleFunc("/payments/outgoing", f.get(f.listOutgoing))
+	f.mux.HandleFunc("/payments/outgoing/", f.get(f.getOutgoing))
+	f.mux.HandleFunc("/payments/outgoingbyhash/", f.get(f.getOutgoingByHash))
+	f.mux.Handle("/websocket", websocket.Handler(f.stream))
+
+	return f
+}
+
+// Close removes the node of the fake from the in-memory network.
+func (f *FakePhoenixd) Close() {
+	f.Node.Close()
+}
+
+func (f *FakePhoenixd) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// Like phoenixd, any username is accepted.
+	if _, password, ok := r.BasicAuth(); !ok || password != f.password {
+		http.Error(w, "Invalid authentication (use basic auth with the http password set in phoenix.conf)", http.StatusUnauthorized)
+		return
+	}
+
+	f.mux.ServeHTTP(w, r)
+}
+
+// An endpoint returning the response to encode, or an error to answer with a 400.
+type endpoint func(r *http.Request) (any, error)
+
+func (f *FakePhoenixd) get(handler endpoint) http.HandlerFunc {
+	return f.serve(http.MethodGet, handler)
+}
+
+func (f *FakePhoenixd) post(handler endpoint) http.HandlerFunc {
+	return f.serve(http.MethodPost, handler)
+}
+
+func (f *FakePhoenixd) serve(method string, handler endpoint) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != method {
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		response, err := handler(r)
+		if err == errNotFound {
+			http.NotFound(w, r)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+	}
+}
+
+var errNotFound = fmt.Errorf("not found")
+
+// uintParam parses an optional integer parameter.
+func uintParam(r *http.Request, name string) (uint64, error) {
+	value := r.Form.Get(name)
+	if value == "" {
+		return 0, nil
+	}
+
+	parsed, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid parameter %s", name)
+	}
+	return parsed, nil
+}
+
+func (f *FakePhoenixd) createInvoice(r *http.Request) (any, error) {
+	if !r.Form.Has("amountSat") {
+		return nil, fmt.Errorf("Missing parameter amountSat")
+	}
+	amountSat, err := uintParam(r, "amountSat")
+	if err != nil {
+		return nil, err
+	}
+
+	req := challenge.CreateInvoiceRequest{
+		Amount:      amount.FromSatoshis(amountSat),
+		Description: r.Form.Get("description"),
+	}
+	if descriptionHash := r.Form.Get("descriptionHash"); descriptionHash != "" {
+		if req.DescriptionHash, err = lntypes.MakeHashFromStr(descriptionHash); err != nil {
+			return nil, fmt.Errorf("Invalid parameter descriptionHash")
+		}
+	}
+
+	response, err := f.Node.CreateInvoice(r.Context(), req)
+	if err != nil {
+		return nil, err
+	}
+
+	f.mu.Lock()
+	f.incoming[response.PaymentHash.String()] = &phoenixd.Payment{
+		PaymentHash: response.PaymentHash.String(),
+		ExternalId:  r.Form.Get("externalId"),
+		Description: req.Description,
+		Invoice:     response.Invoice,
+		CreatedAt:   time.Now().UnixMilli(),
+	}
+	f.mu.Unlock()
+
+	return phoenixd.InvoiceResponse{
+		AmountSat:   amountSat,
+		PaymentHash: response.PaymentHash.String(),
+		Serialized:  response.Invoice,
+	}, nil
+}
+
+// settled records an incoming payment and notifies it.
+func (f *FakePhoenixd) settled(preimage lntypes.Preimage, price amount.MilliSatoshi) {
+	receivedSat, _ := price.ToSatoshis(amount.RoundDown)
+	now := time.Now().UnixMilli()
+
+	f.mu.Lock()
+	payment, ok := f.incoming[preimage.Hash().String()]
+	if !ok {
+		f.mu.Unlock()
+		return
+	}
+	payment.Preimage = preimage.String()
+	payment.IsPaid = true
+	payment.ReceivedSat = receivedSat
+	payment.CompletedAt = now
+
+	event := phoenixd.PaymentReceived{
+		Type:        phoenixd.PaymentReceivedType,
+		Timestamp:   now,
+		AmountSat:   receivedSat,
+		PaymentHash: payment.PaymentHash,
+		ExternalId:  payment.ExternalId,
+	}
+	for subscriber := range f.subscribers {
+		select {
+		case subscriber <- event:
+		default:
+		}
+	}
+	f.mu.Unlock()
+
+	f.postWebhook(event)
+}
+
+// postWebhook posts a signed event to the webhook, if one is set.
+func (f *FakePhoenixd) postWebhook(event phoenixd.PaymentReceived) {
+	if f.Webhook == "" {
+		return
+	}
+
+	body, _ := json.Marshal(event)
+	req, err := http.NewRequest(http.MethodPost, f.Webhook, bytes.NewReader(body))
+	if err != nil {
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(webhook.SignatureHeader, webhook.Sign(f.WebhookSecret, body))
+
+	client := http.Client{Timeout: 5 * time.Second}
+	if resp, err := client.Do(req); err == nil {
+		resp.Body.Close()
+	}
+}
+
+func (f *FakePhoenixd) payInvoice(r *http.Request) (any, error) {
+	serialized := r.Form.Get("invoice")
+	if serialized == "" {
+		return nil, fmt.Errorf("Missing parameter invoice")
+	}
+	amountSat, err := uintParam(r, "amountSat")
+	if err != nil {
+		return nil, err
+	}
+
+	uid := secrets.NewUserId()
+	paymentId := hex.EncodeToString(uid[:16])
+	payment := &phoenixd.OutgoingPayment{
+		PaymentId: paymentId,
+		Invoice:   serialized,
+		CreatedAt: time.Now().UnixMilli(),
+	}
+	if bolt11, err := zpay32.Decode(serialized, Network); err == nil {
+		payment.PaymentHash = hex.EncodeToString(bolt11.PaymentHash[:])
+	}
+
+	response, err := f.Node.PayInvoice(r.Context(), challenge.PayInvoiceRequest{
+		Amount:  amount.FromSatoshis(amountSat),
+		Invoice: serialized,
+	})
+
+	f.mu.Lock()
+	f.outgoing[paymentId] = payment
+	if err == nil {
+		sent, _ := f.sentAmount(serialized, amountSat)
+		payment.IsPaid = true
+		payment.Preimage = response.Preimage.String()
+		payment.Sent = sent
+		payment.CompletedAt = time.Now().UnixMilli()
+	}
+	f.mu.Unlock()
+
+	if err != nil {
+		return map[string]string{"type": phoenixd.PaymentFailedCode, "paymentId": paymentId, "reason": err.Error()}, nil
+	}
+
+	return phoenixd.PaymentResponse{
+		RecipientAmountSat: payment.Sent,
+		PaymentId:          paymentId,
+		PaymentHash:        response.PaymentHash.String(),
+		PaymentPreimage:    response.Preimage.String(),
+	}, nil
+}
+
+// sentAmount returns the amount paid for an invoice, in satoshi.
+func (f *FakePhoenixd) sentAmount(serialized string, amountSat uint64) (uint64, error) {
+	bolt11, err := zpay32.Decode(serialized, Network)
+	if err != nil || bolt11.MilliSat == nil {
+		return amountSat, err
+	}
+	return amount.FromLnwire(*bolt11.MilliSat).ToSatoshis(amount.RoundUp)
+}
+
+func (f *FakePhoenixd) decodeInvoice(r *http.Request) (any, error) {
+	bolt11, err := zpay32.Decode(r.Form.Get("invoice"), Network)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid parameter invoice")
+	}
+
+	decoded := phoenixd.DecodedInvoice{
+		Chain:                   "regtest",
+		PaymentHash:             hex.EncodeToString(bolt11.PaymentHash[:]),
+		NodeId:                  hex.EncodeToString(bolt11.Destination.SerializeCompressed()),
+		MinFinalCltvExpiryDelta: uint32(bolt11.MinFinalCLTVExpiry()),
+		ExpirySeconds:           int64(bolt11.Expiry().Seconds()),
+		TimestampSeconds:        bolt11.Timestamp.Unix(),
+	}
+	if bolt11.MilliSat != nil {
+		decoded.Amount = amount.FromLnwire(*bolt11.MilliSat)
+	}
+	if bolt11.Description != nil {
+		decoded.Description = *bolt11.Description
+	}
+	if bolt11.DescriptionHash != nil {
+		decoded.DescriptionHash = hex.EncodeToString(bolt11.DescriptionHash[:])
+	}
+	if bolt11.PaymentAddr != nil {
+		decoded.PaymentSecret = hex.EncodeToString(bolt11.PaymentAddr[:])
+	}
+	return decoded, nil
+}
+
+func (f *FakePhoenixd) getInfo(r *http.Request) (any, error) {
+	key, err := f.Node.PubKey()
+	if err != nil {
+		return nil, err
+	}
+	return phoenixd.NodeInfo{NodeId: nodeId(key), Channels: []phoenixd.Channel{}}, nil
+}
+
+func (f *FakePhoenixd) getBalance(r *http.Request) (any, error) {
+	balanceSat, _ := f.Node.balance().ToSatoshis(amount.RoundDown)
+	return phoenixd.Balance{BalanceSat: balanceSat}, nil
+}
+
+// listParams parses the filters and pagination of the listing endpoints.
+func listParams(r *http.Request) (from, to int64, limit, offset uint64, all bool, err error) {
+	var fromMs, toMs uint64
+	if fromMs, err = uintParam(r, "from"); err != nil {
+		return
+	}
+	if toMs, err = uintParam(r, "to"); err != nil {
+		return
+	}
+	if limit, err = uintParam(r, "limit"); err != nil {
+		return
+	}
+	if offset, err = uintParam(r, "offset"); err != nil {
+		return
+	}
+	if toMs == 0 {
+		toMs = uint64(time.Now().Add(time.Hour).UnixMilli())
+	}
+	if limit == 0 {
+		limit = 20
+	}
+	return int64(fromMs), int64(toMs), limit, offset, r.Form.Get("all") == "true", nil
+}
+
+// paginate applies the offset and limit to a list sorted by creation time.
+func paginate[T any](items []T, limit, offset uint64) []T {
+	if offset >= uint64(len(items)) {
+		return []T{}
+	}
+	items = items[offset:]
+	if limit < uint64(len(items)) {
+		items = items[:limit]
+	}
+	return items
+}
+
+func (f *FakePhoenixd) listIncoming(r *http.Request) (any, error) {
+	from, to, limit, offset, all, err := listParams(r)
+	if err != nil {
+		return nil, err
+	}
+	externalId := r.Form.Get("externalId")
+
+	f.mu.Lock()
+	payments := []phoenixd.Payment{}
+	for _, payment := range f.incoming {
+		if (payment.IsPaid || all) && payment.CreatedAt >= from && payment.CreatedAt <= to &&
+			(externalId == "" || payment.ExternalId == externalId) {
+			payments = append(payments, *payment)
+		}
+	}
+	f.mu.Unlock()
+
+	sort.Slice(payments, func(i, j int) bool { return payments[i].CreatedAt < payments[j].CreatedAt })
+	return paginate(payments, limit, offset), nil
+}
+
+func (f *FakePhoenixd) getIncoming(r *http.Request) (any, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	payment, ok := f.incoming[strings.TrimPrefix(r.URL.Path, "/payments/incoming/")]
+	if !ok {
+		return nil, errNotFound
+	}
+	return *payment, nil
+}
+
+func (f *FakePhoenixd) listOutgoing(r *http.Request) (any, error) {
+	from, to, limit, offset, all, err := listParams(r)
+	if err != nil {
+		return nil, err
+	}
+
+	f.mu.Lock()
+	payments := []phoenixd.OutgoingPayment{}
+	for _, payment := range f.outgoing {
+		if (payment.IsPaid || all) && payment.CreatedAt >= from && payment.CreatedAt <= to {
+			payments = append(payments, *payment)
+		}
+	}
+	f.mu.Unlock()
+
+	sort.Slice(payments, func(i, j int) bool { return payments[i].CreatedAt < payments[j].CreatedAt })
+	return paginate(payments, limit, offset), nil
+}
+
+func (f *FakePhoenixd) getOutgoing(r *http.Request) (any, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	payment, ok := f.outgoing[strings.TrimPrefix(r.URL.Path, "/payments/outgoing/")]
+	if !ok {
+		return nil, errNotFound
+	}
+	return *payment, nil
+}
+
+func (f *FakePhoenixd) getOutgoingByHash(r *http.Request) (any, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	paymentHash := strings.TrimPrefix(r.URL.Path, "/payments/outgoingbyhash/")
+	for _, payment := range f.outgoing {
+		if payment.PaymentHash == paymentHash && payment.IsPaid {
+			return *payment, nil
+		}
+	}
+	return nil, errNotFound
+}
+
+// Subscribers returns the number of websockets connected to the events.
+func (f *FakePhoenixd) Subscribers() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.subscribers)
+}
+
+// stream sends the payments received over a websocket, until it is closed.
+func (f *FakePhoenixd) stream(conn *websocket.Conn) {
+	events := make(chan phoenixd.PaymentReceived, eventBuffer)
+
+	f.mu.Lock()
+	f.subscribers[events] = struct{}{}
+	f.mu.Unlock()
+
+	defer func() {
+		f.mu.Lock()
+		delete(f.subscribers, events)
+		f.mu.Unlock()
+	}()
+
+	// Detect the connection being closed by the client.
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		var ignored []byte
+		for websocket.Message.Receive(conn, &ignored) == nil {
+		}
+		cancel()
+	}()
+
+	for {
+		select {
+		case event := <-events:
+			if websocket.JSON.Send(conn, event) != nil {
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -2,13 +2,20 @@
 package phoenixd
 
 import (
-	"bytes"
+	"context"
 	"encoding/base64"
 	"encoding/json"
-	"errors"
-	"fmt"
 	"io"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultRetries      = 3
+	defaultRetryBackoff = 250 * time.Millisecond
 )
 
 // PhoenixClient is a client for interacting with the Phoenix API.
@@ -16,6 +23,10 @@ type PhoenixClient struct {
 	BaseURL    string
 	HTTPClient *http.Client
 	APIKey     string
+	// Retries is the number of times a GET request is retried after a transient failure.
+	Retries int
+	// RetryBackoff is the delay before the first retry, doubled for each retry.
+	RetryBackoff time.Duration
 }
 
 // CreateInvoiceRequest represents the request to create an invoice.
@@ -113,9 +124,11 @@ type NodeInfo struct {
 // NewPhoenixClient creates a new PhoenixClient.
 func NewPhoenixClient(baseURL, apiKey string) *PhoenixClient {
 	return &PhoenixClient{
-		BaseURL:    baseURL,
-		HTTPClient: &http.Client{},
-		APIKey:     apiKey,
+		BaseURL:      baseURL,
+		HTTPClient:   &http.Client{},
+		APIKey:       apiKey,
+		Retries:      defaultRetries,
+		RetryBackoff: defaultRetryBackoff,
 	}
 }
 
@@ -125,147 +138,130 @@ func (c *PhoenixClient) createAuthHeader() string {
 	return "Basic " + base64.StdEncoding.EncodeToString([]byte(auth))
 }
 
-// CreateInvoice creates a new invoice.
-func (c *PhoenixClient) CreateInvoice(req *CreateInvoiceRequest) (*InvoiceResponse, error) {
-	url := fmt.Sprintf("%s/createinvoice", c.BaseURL)
-	formData := fmt.Sprintf("description=%s&amountSat=%d&externalId=%s", req.Description, req.AmountSat, req.ExternalId)
-
-	httpReq, err := http.NewRequest("POST", url, bytes.NewBuffer([]byte(formData)))
-	if err != nil {
-		return nil, err
+// get sends a GET request to the API and decodes the JSON response into out.
+//
+// Since GET requests are idempotent, they are retried with backoff after a transient failure,
+// until the context is done.
+func (c *PhoenixClient) get(ctx context.Context, path string, query url.Values, out any) error {
+	endpoint := c.BaseURL + path
+	if len(query) > 0 {
+		endpoint += "?" + query.Encode()
 	}
-	httpReq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-	httpReq.Header.Set("Authorization", c.createAuthHeader())
 
-	resp, err := c.HTTPClient.Do(httpReq)
-	if err != nil {
-		return nil, err
+	backoff := c.RetryBackoff
+	for attempt := 0; ; attempt++ {
+		httpReq, err := http.NewRequestWithContext(ctx, "GET", endpoint, nil)
+		if err != nil {
+			return err
+		}
+
+		err = c.do(httpReq, out)
+		if err == nil || attempt >= c.Retries || !isTransient(err) {
+			return err
+		}
+
+		if err := sleep(ctx, backoff); err != nil {
+			return err
+		}
+		backoff *= 2
 	}
-	defer resp.Body.Close()
+}
 
-	body, err := io.ReadAll(resp.Body)
+// post sends a form to the API and decodes the JSON response into out.
+func (c *PhoenixClient) post(ctx context.Context, path string, form url.Values, out any) error {
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", c.BaseURL+path, strings.NewReader(form.Encode()))
 	if err != nil {
-		return nil, err
-	}
-
-	if resp.StatusCode != http.StatusOK {
-		return nil, errors.New(string(body))
-	}
-
-	var invoiceResponse InvoiceResponse
-	if err := json.Unmarshal(body, &invoiceResponse); err != nil {
-		return nil, err
+		return err
 	}
+	httpReq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
-	return &invoiceResponse, nil
+	return c.do(httpReq, out)
 }
 
-// PayInvoice pays a BOLT11 Lightning invoice.
-func (c *PhoenixClient) PayInvoice(req *PayInvoiceRequest) (*PaymentResponse, error) {
-	url := fmt.Sprintf("%s/payinvoice", c.BaseURL)
-	formData := fmt.Sprintf("invoice=%s", req.Invoice)
-	if req.AmountSat != 0 {
-		formData += fmt.Sprintf("&amountSat=%d", req.AmountSat)
-	}
-
-	httpReq, err := http.NewRequest("POST", url, bytes.NewBufferString(formData))
-	if err != nil {
-		return nil, err
-	}
-	httpReq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+// do sends an authenticated request and decodes the response into out.
+//
+// Some endpoints answer with plain text, in which case out must be a *string.
+func (c *PhoenixClient) do(httpReq *http.Request, out any) error {
 	httpReq.Header.Set("Authorization", c.createAuthHeader())
 
 	resp, err := c.HTTPClient.Do(httpReq)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	defer resp.Body.Close()
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, errors.New(string(body))
-	}
-
-	var paymentResponse PaymentResponse
-	if err := json.Unmarshal(body, &paymentResponse); err != nil {
-		return nil, err
+		return newError(resp.StatusCode, body)
 	}
 
-	if paymentResponse.PaymentPreimage == "" {
-		return nil, errors.New(string(body))
+	if text, ok := out.(*string); ok {
+		*text = strings.TrimSpace(string(body))
+		return nil
 	}
 
-	return &paymentResponse, nil
+	return json.Unmarshal(body, out)
 }
 
-// GetIncomingPayment retrieves the details of an incoming payment.
-func (c *PhoenixClient) GetIncomingPayment(paymentHash string) (*Payment, error) {
-	url := fmt.Sprintf("%s/payments/incoming/%s", c.BaseURL, paymentHash)
-
-	httpReq, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return nil, err
+// CreateInvoice creates a new invoice.
+func (c *PhoenixClient) CreateInvoice(ctx context.Context, req *CreateInvoiceRequest) (*InvoiceResponse, error) {
+	form := url.Values{}
+	form.Set("amountSat", strconv.FormatUint(req.AmountSat, 10))
+	if req.Description != "" {
+		form.Set("description", req.Description)
+	}
+	if req.DescriptionHash != "" {
+		form.Set("descriptionHash", req.DescriptionHash)
+	}
+	if req.ExternalId != "" {
+		form.Set("externalId", req.ExternalId)
 	}
-	httpReq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-	httpReq.Header.Set("Authorization", c.createAuthHeader())
 
-	resp, err := c.HTTPClient.Do(httpReq)
-	if err != nil {
+	var invoiceResponse InvoiceResponse
+	if err := c.post(ctx, "/createinvoice", form, &invoiceResponse); err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
+	return &invoiceResponse, nil
+}
 
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
+// PayInvoice pays a BOLT11 Lightning invoice.
+func (c *PhoenixClient) PayInvoice(ctx context.Context, req *PayInvoiceRequest) (*PaymentResponse, error) {
+	form := paymentForm(req.AmountSat, "")
+	form.Set("invoice", req.Invoice)
+
+	// phoenixd answers with the reason when the payment fails.
+	var response struct {
+		PaymentResponse
+		Reason string `json:"reason"`
+	}
+	if err := c.post(ctx, "/payinvoice", form, &response); err != nil {
 		return nil, err
 	}
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, errors.New(string(body))
+	if response.PaymentPreimage == "" {
+		return nil, &Error{StatusCode: http.StatusOK, Code: PaymentFailedCode, Message: response.Reason}
 	}
+	return &response.PaymentResponse, nil
+}
 
+// GetIncomingPayment retrieves the details of an incoming payment.
+func (c *PhoenixClient) GetIncomingPayment(ctx context.Context, paymentHash string) (*Payment, error) {
 	var payment Payment
-	if err := json.Unmarshal(body, &payment); err != nil {
+	if err := c.get(ctx, "/payments/incoming/"+url.PathEscape(paymentHash), nil, &payment); err != nil {
 		return nil, err
 	}
-
 	return &payment, nil
 }
 
 // GetInfo retrieves information about the node.
-func (c *PhoenixClient) GetInfo() (*NodeInfo, error) {
-	url := fmt.Sprintf("%s/getinfo", c.BaseURL)
-
-	httpReq, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return nil, err
-	}
-	httpReq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-	httpReq.Header.Set("Authorization", c.createAuthHeader())
-
-	resp, err := c.HTTPClient.Do(httpReq)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
-	}
-
-	if resp.StatusCode != http.StatusOK {
-		return nil, errors.New(string(body))
-	}
-
+func (c *PhoenixClient) GetInfo(ctx context.Context) (*NodeInfo, error) {
 	var nodeInfo NodeInfo
-	if err := json.Unmarshal(body, &nodeInfo); err != nil {
+	if err := c.get(ctx, "/getinfo", nil, &nodeInfo); err != nil {
 		return nil, err
 	}
-
 	return &nodeInfo, nil
 }
@@ -0,0 +1,80 @@
+package phoenixd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// PaymentFailedCode is the code of the errors returned when phoenixd fails to pay.
+const PaymentFailedCode = "payment_failed"
+
+// Error is returned when phoenixd answers a request with an error.
+type Error struct {
+	// StatusCode is the HTTP status of the response.
+	StatusCode int
+	// Code is the error code of phoenixd, if the response has one.
+	Code string
+	// Message is the description of the error.
+	Message string
+}
+
+func (e *Error) Error() string {
+	if e.Code != "" {
+		return fmt.Sprintf("phoenixd: %s (%d %s): %s", e.Code, e.StatusCode, http.StatusText(e.StatusCode), e.Message)
+	}
+	return fmt.Sprintf("phoenixd: %d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), e.Message)
+}
+
+// Temporary reports whether the request may succeed if retried.
+func (e *Error) Temporary() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= http.StatusInternalServerError
+}
+
+// newError builds the error of a response.
+//
+// phoenixd mostly answers errors in plain text, but some are JSON objects with a code.
+func newError(statusCode int, body []byte) *Error {
+	e := &Error{StatusCode: statusCode, Message: strings.TrimSpace(string(body))}
+
+	var object struct {
+		Code    string `json:"code"`
+		Type    string `json:"type"`
+		Message string `json:"message"`
+		Reason  string `json:"reason"`
+		Error   string `json:"error"`
+	}
+	if json.Unmarshal(body, &object) != nil {
+		return e
+	}
+
+	for _, code := range []string{object.Code, object.Type} {
+		if code != "" {
+			e.Code = code
+			break
+		}
+	}
+	for _, message := range []string{object.Message, object.Reason, object.Error} {
+		if message != "" {
+			e.Message = message
+			break
+		}
+	}
+	return e
+}
+
+// isTransient reports whether a request failed for a reason worth retrying.
+func isTransient(err error) bool {
+	var phoenixErr *Error
+	if errors.As(err, &phoenixErr) {
+		return phoenixErr.Temporary()
+	}
+
+	// Connections refused, reset or closed by the server.
+	var opErr *net.OpError
+	return errors.As(err, &opErr) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
@@ -0,0 +1,26 @@
+package phoenixd
+
+// EventType is the type of an event of phoenixd.
+type EventType string
+
+const (
+	PaymentReceivedType EventType = "payment_received"
+)
+
+// PaymentReceived is sent when an incoming payment is settled, to the webhook and over the websocket.
+type PaymentReceived struct {
+	// Type is the type of the event.
+	Type EventType `json:"type"`
+	// Timestamp is the time of the event, in milliseconds.
+	Timestamp int64 `json:"timestamp"`
+	// AmountSat is the amount received, in satoshi.
+	AmountSat uint64 `json:"amountSat"`
+	// PaymentHash is the payment hash of the invoice.
+	PaymentHash string `json:"paymentHash"`
+	// ExternalId is the identifier given when creating the invoice.
+	ExternalId string `json:"externalId,omitempty"`
+	// PayerNote is an optional message of the payer.
+	PayerNote string `json:"payerNote,omitempty"`
+	// PayerKey is the optional public key of the payer.
+	PayerKey string `json:"payerKey,omitempty"`
+}
@@ -2,6 +2,8 @@ package phoenixd
 
 import (
 	"context"
+	"fmt"
+	"lsat/amount"
 	"lsat/challenge"
 
 	"github.com/lightningnetwork/lnd/lntypes"
@@ -9,31 +11,56 @@ import (
 
 type PhoenixNode struct {
 	Client *PhoenixClient
+	// Rounding is the policy used to convert the amounts of the invoices created to satoshi,
+	// since phoenixd does not handle millisatoshis. Amounts are rounded up by default.
+	//
+	// The payments are never rounded, so that the payer does not pay more than asked.
+	Rounding amount.Rounding
 }
 
-func (c *PhoenixNode) CreateInvoice(_ context.Context, req challenge.CreateInvoiceRequest) (challenge.InvoiceResponse, error) {
-	response, err := c.Client.CreateInvoice(&CreateInvoiceRequest{
-		Description:     req.Description,
-		DescriptionHash: req.DescriptionHash.String(),
-		AmountSat:       req.Amount,
-		ExternalId:      req.Udata,
-	})
+func (c *PhoenixNode) CreateInvoice(ctx context.Context, req challenge.CreateInvoiceRequest) (challenge.InvoiceResponse, error) {
+	amountSat, err := req.Amount.ToSatoshis(c.Rounding)
+	if err != nil {
+		return challenge.InvoiceResponse{}, err
+	}
+
+	invoiceReq := &CreateInvoiceRequest{
+		Description: req.Description,
+		AmountSat:   amountSat,
+		ExternalId:  req.Udata,
+	}
+	if req.DescriptionHash != (lntypes.Hash{}) {
+		invoiceReq.DescriptionHash = req.DescriptionHash.String()
+	}
 
+	response, err := c.Client.CreateInvoice(ctx, invoiceReq)
 	if err != nil {
 		return challenge.InvoiceResponse{}, err
 	}
 
-	paymentHash, _ := lntypes.MakeHashFromStr(response.PaymentHash)
+	paymentHash, err := lntypes.MakeHashFromStr(response.PaymentHash)
+	if err != nil {
+		return challenge.InvoiceResponse{}, fmt.Errorf("phoenixd returned an invalid payment hash: %w", err)
+	}
 
 	return challenge.InvoiceResponse{
 		PaymentHash: paymentHash,
 		Invoice:     response.Serialized,
+		Amount:      amount.FromSatoshis(response.AmountSat),
 	}, nil
 }
 
-func (c *PhoenixNode) PayInvoice(_ context.Context, req challenge.PayInvoiceRequest) (challenge.PayInvoiceResponse, error) {
-	response, err := c.Client.PayInvoice(&PayInvoiceRequest{
-		AmountSat: req.Amount,
+// PayInvoice pays an invoice, with the amount of the request if set.
+//
+// The amount must be a whole number of satoshis, since phoenixd cannot pay millisatoshis.
+func (c *PhoenixNode) PayInvoice(ctx context.Context, req challenge.PayInvoiceRequest) (challenge.PayInvoiceResponse, error) {
+	amountSat, err := req.Amount.ToSatoshis(amount.RoundExact)
+	if err != nil {
+		return challenge.PayInvoiceResponse{}, err
+	}
+
+	response, err := c.Client.PayInvoice(ctx, &PayInvoiceRequest{
+		AmountSat: amountSat,
 		Invoice:   req.Invoice,
 	})
 
@@ -41,8 +68,15 @@ func (c *PhoenixNode) PayInvoice(_ context.Context, req challenge.PayInvoiceRequ
 		return challenge.PayInvoiceResponse{}, err
 	}
 
-	paymentHash, _ := lntypes.MakeHashFromStr(response.PaymentHash)
-	preimage, _ := lntypes.MakePreimageFromStr(response.PaymentPreimage)
+	paymentHash, err := lntypes.MakeHashFromStr(response.PaymentHash)
+	if err != nil {
+		return challenge.PayInvoiceResponse{}, fmt.Errorf("phoenixd returned an invalid payment hash: %w", err)
+	}
+
+	preimage, err := lntypes.MakePreimageFromStr(response.PaymentPreimage)
+	if err != nil {
+		return challenge.PayInvoiceResponse{}, fmt.Errorf("phoenixd returned an invalid preimage: %w", err)
+	}
 
 	return challenge.PayInvoiceResponse{
 		PaymentId:   response.PaymentId,
@@ -50,3 +84,27 @@ func (c *PhoenixNode) PayInvoice(_ context.Context, req challenge.PayInvoiceRequ
 		PaymentHash: paymentHash,
 	}, nil
 }
+
+// SubscribeInvoices delivers the invoices paid to the node, from the websocket of phoenixd.
+func (c *PhoenixNode) SubscribeInvoices(ctx context.Context) (<-chan challenge.SettledInvoice, error) {
+	events := c.Client.Events(ctx)
+	invoices := make(chan challenge.SettledInvoice)
+
+	go func() {
+		defer close(invoices)
+
+		for event := range events {
+			paymentHash, err := lntypes.MakeHashFromStr(event.PaymentHash)
+			if err != nil {
+				continue
+			}
+
+			select {
+			case invoices <- challenge.SettledInvoice{PaymentHash: paymentHash, Amount: amount.FromSatoshis(event.AmountSat)}:
+			case <-ctx.Done():
+			}
+		}
+	}()
+
+	return invoices, nil
+}
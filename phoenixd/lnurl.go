@@ -0,0 +1,69 @@
+package phoenixd
+
+import (
+	"context"
+	"lsat/amount"
+	"net/url"
+)
+
+// LnurlPayRequest represents the request to pay a LNURL-pay link.
+type LnurlPayRequest struct {
+	// AmountSat is the amount to pay, in satoshi.
+	AmountSat uint64
+	// Lnurl is the LNURL-pay link or lightning address.
+	Lnurl string
+	// Message is an optional message for the recipient.
+	Message string
+}
+
+// LnurlWithdrawResponse represents the response from a LNURL-withdraw.
+type LnurlWithdrawResponse struct {
+	// Url is the URL of the service.
+	Url string `json:"url"`
+	// MinWithdrawable is the minimum amount that can be withdrawn, in millisatoshi.
+	MinWithdrawable amount.MilliSatoshi `json:"minWithdrawable"`
+	// MaxWithdrawable is the maximum amount that can be withdrawn, in millisatoshi.
+	MaxWithdrawable amount.MilliSatoshi `json:"maxWithdrawable"`
+	// Description is the description of the withdrawal.
+	Description string `json:"description"`
+	// K1 is the secret of the withdrawal.
+	K1 string `json:"k1"`
+	// Invoice is the invoice sent to the service to be paid.
+	Invoice string `json:"invoice"`
+}
+
+// LnurlPay pays a LNURL-pay link.
+func (c *PhoenixClient) LnurlPay(ctx context.Context, req *LnurlPayRequest) (*PaymentResponse, error) {
+	form := paymentForm(req.AmountSat, req.Message)
+	form.Set("lnurl", req.Lnurl)
+
+	var paymentResponse PaymentResponse
+	if err := c.post(ctx, "/lnurlpay", form, &paymentResponse); err != nil {
+		return nil, err
+	}
+	return &paymentResponse, nil
+}
+
+// LnurlWithdraw withdraws the maximum amount of a LNURL-withdraw link.
+func (c *PhoenixClient) LnurlWithdraw(ctx context.Context, lnurl string) (*LnurlWithdrawResponse, error) {
+	form := url.Values{}
+	form.Set("lnurl", lnurl)
+
+	var response LnurlWithdrawResponse
+	if err := c.post(ctx, "/lnurlwithdraw", form, &response); err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+// LnurlAuth authenticates the node on a LNURL-auth link.
+func (c *PhoenixClient) LnurlAuth(ctx context.Context, lnurl string) (string, error) {
+	form := url.Values{}
+	form.Set("lnurl", lnurl)
+
+	var response string
+	if err := c.post(ctx, "/lnurlauth", form, &response); err != nil {
+		return "", err
+	}
+	return response, nil
+}
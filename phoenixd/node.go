@@ -0,0 +1,119 @@
+package phoenixd
+
+import (
+	"context"
+	"net/url"
+	"strconv"
+)
+
+// Balance represents the balance of the node.
+type Balance struct {
+	// BalanceSat is the spendable balance, in satoshi.
+	BalanceSat uint64 `json:"balanceSat"`
+	// FeeCreditSat is the fee credit, in satoshi. It is used to pay the fees of future channel operations.
+	FeeCreditSat uint64 `json:"feeCreditSat"`
+}
+
+// CloseChannelRequest represents the request to close a channel.
+type CloseChannelRequest struct {
+	// ChannelId is the identifier of the channel to close.
+	ChannelId string
+	// Address is the Bitcoin address receiving the funds of the channel.
+	Address string
+	// FeerateSatByte is the fee rate of the closing transaction, in satoshi per byte.
+	FeerateSatByte uint64
+}
+
+// SendToAddressRequest represents the request to send an on-chain payment.
+type SendToAddressRequest struct {
+	// AmountSat is the amount to send, in satoshi.
+	AmountSat uint64
+	// Address is the Bitcoin address of the recipient.
+	Address string
+	// FeerateSatByte is the fee rate of the transaction, in satoshi per byte.
+	FeerateSatByte uint64
+}
+
+// LiquidityFees represents the fees to request inbound liquidity.
+type LiquidityFees struct {
+	// MiningFeeSat is the mining fee, in satoshi.
+	MiningFeeSat uint64 `json:"miningFeeSat"`
+	// ServiceFeeSat is the service fee, in satoshi.
+	ServiceFeeSat uint64 `json:"serviceFeeSat"`
+}
+
+// GetBalance retrieves the balance of the node.
+func (c *PhoenixClient) GetBalance(ctx context.Context) (*Balance, error) {
+	var balance Balance
+	if err := c.get(ctx, "/getbalance", nil, &balance); err != nil {
+		return nil, err
+	}
+	return &balance, nil
+}
+
+// ListChannels retrieves the channels of the node.
+func (c *PhoenixClient) ListChannels(ctx context.Context) ([]Channel, error) {
+	var channels []Channel
+	if err := c.get(ctx, "/listchannels", nil, &channels); err != nil {
+		return nil, err
+	}
+	return channels, nil
+}
+
+// CloseChannel closes a channel and returns the id of the closing transaction.
+func (c *PhoenixClient) CloseChannel(ctx context.Context, req *CloseChannelRequest) (string, error) {
+	form := url.Values{}
+	form.Set("channelId", req.ChannelId)
+	form.Set("address", req.Address)
+	form.Set("feerateSatByte", strconv.FormatUint(req.FeerateSatByte, 10))
+
+	var txId string
+	if err := c.post(ctx, "/closechannel", form, &txId); err != nil {
+		return "", err
+	}
+	return txId, nil
+}
+
+// SendToAddress sends an on-chain payment and returns the id of the transaction.
+func (c *PhoenixClient) SendToAddress(ctx context.Context, req *SendToAddressRequest) (string, error) {
+	form := url.Values{}
+	form.Set("amountSat", strconv.FormatUint(req.AmountSat, 10))
+	form.Set("address", req.Address)
+	form.Set("feerateSatByte", strconv.FormatUint(req.FeerateSatByte, 10))
+
+	var txId string
+	if err := c.post(ctx, "/sendtoaddress", form, &txId); err != nil {
+		return "", err
+	}
+	return txId, nil
+}
+
+// EstimateLiquidityFees estimates the fees to request the given amount of inbound liquidity, in satoshi.
+func (c *PhoenixClient) EstimateLiquidityFees(ctx context.Context, amountSat uint64) (*LiquidityFees, error) {
+	query := url.Values{}
+	query.Set("amountSat", strconv.FormatUint(amountSat, 10))
+
+	var fees LiquidityFees
+	if err := c.get(ctx, "/estimateliquidityfees", query, &fees); err != nil {
+		return nil, err
+	}
+	return &fees, nil
+}
+
+// GetOffer retrieves the reusable BOLT12 offer of the node.
+func (c *PhoenixClient) GetOffer(ctx context.Context) (string, error) {
+	var offer string
+	if err := c.get(ctx, "/getoffer", nil, &offer); err != nil {
+		return "", err
+	}
+	return offer, nil
+}
+
+// GetLnAddress retrieves the lightning address of the node.
+func (c *PhoenixClient) GetLnAddress(ctx context.Context) (string, error) {
+	var address string
+	if err := c.get(ctx, "/getlnaddress", nil, &address); err != nil {
+		return "", err
+	}
+	return address, nil
+}
@@ -0,0 +1,126 @@
+package phoenixd
+
+import (
+	"context"
+	"lsat/amount"
+	"net/url"
+	"strconv"
+)
+
+// PayOfferRequest represents the request to pay a BOLT12 offer.
+type PayOfferRequest struct {
+	// AmountSat is the amount to pay, in satoshi. If unset, will pay the amount requested in the offer.
+	AmountSat uint64
+	// Offer is the BOLT12 offer.
+	Offer string
+	// Message is an optional message for the recipient.
+	Message string
+}
+
+// PayLnAddressRequest represents the request to pay a lightning address.
+type PayLnAddressRequest struct {
+	// AmountSat is the amount to pay, in satoshi.
+	AmountSat uint64
+	// Address is the lightning address, e.g. alice@example.com.
+	Address string
+	// Message is an optional message for the recipient.
+	Message string
+}
+
+// DecodedInvoice represents a decoded BOLT11 invoice.
+type DecodedInvoice struct {
+	// Chain is the chain of the invoice, e.g. mainnet.
+	Chain string `json:"chain"`
+	// Amount is the amount requested by the invoice, in millisatoshi.
+	Amount amount.MilliSatoshi `json:"amount"`
+	// PaymentHash is the payment hash of the invoice.
+	PaymentHash string `json:"paymentHash"`
+	// Description is the description of the invoice.
+	Description string `json:"description"`
+	// DescriptionHash is the hash of the description, if the invoice has one.
+	DescriptionHash string `json:"descriptionHash"`
+	// NodeId is the public key of the recipient.
+	NodeId string `json:"nodeId"`
+	// MinFinalCltvExpiryDelta is the minimum CLTV delta of the final hop.
+	MinFinalCltvExpiryDelta uint32 `json:"minFinalCltvExpiryDelta"`
+	// PaymentSecret is the payment secret of the invoice.
+	PaymentSecret string `json:"paymentSecret"`
+	// ExpirySeconds is the validity of the invoice from its timestamp.
+	ExpirySeconds int64 `json:"expirySeconds"`
+	// TimestampSeconds is the creation time of the invoice.
+	TimestampSeconds int64 `json:"timestampSeconds"`
+}
+
+// DecodedOffer represents a decoded BOLT12 offer.
+type DecodedOffer struct {
+	// Chain is the chain of the offer, e.g. mainnet.
+	Chain string `json:"chain"`
+	// Amount is the amount requested by the offer, in millisatoshi.
+	Amount amount.MilliSatoshi `json:"amount"`
+	// Description is the description of the offer.
+	Description string `json:"description"`
+	// Issuer is the issuer of the offer.
+	Issuer string `json:"issuer"`
+	// NodeId is the public key of the recipient.
+	NodeId string `json:"nodeId"`
+}
+
+// paymentForm builds the form shared by the payment endpoints.
+func paymentForm(amountSat uint64, message string) url.Values {
+	form := url.Values{}
+	if amountSat != 0 {
+		form.Set("amountSat", strconv.FormatUint(amountSat, 10))
+	}
+	if message != "" {
+		form.Set("message", message)
+	}
+	return form
+}
+
+// PayOffer pays a BOLT12 offer.
+func (c *PhoenixClient) PayOffer(ctx context.Context, req *PayOfferRequest) (*PaymentResponse, error) {
+	form := paymentForm(req.AmountSat, req.Message)
+	form.Set("offer", req.Offer)
+
+	var paymentResponse PaymentResponse
+	if err := c.post(ctx, "/payoffer", form, &paymentResponse); err != nil {
+		return nil, err
+	}
+	return &paymentResponse, nil
+}
+
+// PayLnAddress pays a lightning address.
+func (c *PhoenixClient) PayLnAddress(ctx context.Context, req *PayLnAddressRequest) (*PaymentResponse, error) {
+	form := paymentForm(req.AmountSat, req.Message)
+	form.Set("address", req.Address)
+
+	var paymentResponse PaymentResponse
+	if err := c.post(ctx, "/paylnaddress", form, &paymentResponse); err != nil {
+		return nil, err
+	}
+	return &paymentResponse, nil
+}
+
+// DecodeInvoice decodes a BOLT11 invoice.
+func (c *PhoenixClient) DecodeInvoice(ctx context.Context, invoice string) (*DecodedInvoice, error) {
+	form := url.Values{}
+	form.Set("invoice", invoice)
+
+	var decoded DecodedInvoice
+	if err := c.post(ctx, "/decodeinvoice", form, &decoded); err != nil {
+		return nil, err
+	}
+	return &decoded, nil
+}
+
+// DecodeOffer decodes a BOLT12 offer.
+func (c *PhoenixClient) DecodeOffer(ctx context.Context, offer string) (*DecodedOffer, error) {
+	form := url.Values{}
+	form.Set("offer", offer)
+
+	var decoded DecodedOffer
+	if err := c.post(ctx, "/decodeoffer", form, &decoded); err != nil {
+		return nil, err
+	}
+	return &decoded, nil
+}
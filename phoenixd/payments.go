@@ -0,0 +1,114 @@
+package phoenixd
+
+import (
+	"context"
+	"lsat/amount"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// OutgoingPayment represents the details of an outgoing payment.
+type OutgoingPayment struct {
+	// PaymentId is the internal payment ID for the payment.
+	PaymentId string `json:"paymentId"`
+	// PaymentHash is the payment hash of the payment.
+	PaymentHash string `json:"paymentHash"`
+	// Preimage is the preimage of the payment, once it is paid.
+	Preimage string `json:"preimage"`
+	// IsPaid indicates whether the payment has been paid.
+	IsPaid bool `json:"isPaid"`
+	// Sent is the amount sent, in satoshi.
+	Sent uint64 `json:"sent"`
+	// Fees are the fees of the payment, in millisatoshi.
+	Fees amount.MilliSatoshi `json:"fees"`
+	// Invoice is the serialized invoice.
+	Invoice string `json:"invoice"`
+	// CompletedAt is the timestamp when the payment was completed.
+	CompletedAt int64 `json:"completedAt"`
+	// CreatedAt is the timestamp when the payment was created.
+	CreatedAt int64 `json:"createdAt"`
+}
+
+// ListPaymentsRequest filters and paginates the listing of payments.
+type ListPaymentsRequest struct {
+	// From is the start of the time range, unbounded if zero.
+	From time.Time
+	// To is the end of the time range, unbounded if zero.
+	To time.Time
+	// Limit is the maximum number of payments to return.
+	Limit uint
+	// Offset is the number of payments to skip.
+	Offset uint
+	// All includes the payments that are not paid yet.
+	All bool
+}
+
+// ListIncomingPaymentsRequest filters and paginates the listing of incoming payments.
+type ListIncomingPaymentsRequest struct {
+	ListPaymentsRequest
+	// ExternalId only returns the payments linked to this identifier.
+	ExternalId string
+}
+
+// query encodes the filters as query parameters.
+func (req *ListPaymentsRequest) query() url.Values {
+	query := url.Values{}
+	if !req.From.IsZero() {
+		query.Set("from", strconv.FormatInt(req.From.UnixMilli(), 10))
+	}
+	if !req.To.IsZero() {
+		query.Set("to", strconv.FormatInt(req.To.UnixMilli(), 10))
+	}
+	if req.Limit > 0 {
+		query.Set("limit", strconv.FormatUint(uint64(req.Limit), 10))
+	}
+	if req.Offset > 0 {
+		query.Set("offset", strconv.FormatUint(uint64(req.Offset), 10))
+	}
+	if req.All {
+		query.Set("all", "true")
+	}
+	return query
+}
+
+// ListIncomingPayments lists the incoming payments matching the filters.
+func (c *PhoenixClient) ListIncomingPayments(ctx context.Context, req *ListIncomingPaymentsRequest) ([]Payment, error) {
+	query := req.query()
+	if req.ExternalId != "" {
+		query.Set("externalId", req.ExternalId)
+	}
+
+	var payments []Payment
+	if err := c.get(ctx, "/payments/incoming", query, &payments); err != nil {
+		return nil, err
+	}
+	return payments, nil
+}
+
+// ListOutgoingPayments lists the outgoing payments matching the filters.
+func (c *PhoenixClient) ListOutgoingPayments(ctx context.Context, req *ListPaymentsRequest) ([]OutgoingPayment, error) {
+	var payments []OutgoingPayment
+	if err := c.get(ctx, "/payments/outgoing", req.query(), &payments); err != nil {
+		return nil, err
+	}
+	return payments, nil
+}
+
+// GetOutgoingPayment retrieves the details of an outgoing payment by its id.
+func (c *PhoenixClient) GetOutgoingPayment(ctx context.Context, paymentId string) (*OutgoingPayment, error) {
+	var payment OutgoingPayment
+	if err := c.get(ctx, "/payments/outgoing/"+url.PathEscape(paymentId), nil, &payment); err != nil {
+		return nil, err
+	}
+	return &payment, nil
+}
+
+// GetOutgoingPaymentByHash retrieves the details of an outgoing payment by its payment hash.
+func (c *PhoenixClient) GetOutgoingPaymentByHash(ctx context.Context, paymentHash string) (*OutgoingPayment, error) {
+	var payment OutgoingPayment
+	if err := c.get(ctx, "/payments/outgoingbyhash/"+url.PathEscape(paymentHash), nil, &payment); err != nil {
+		return nil, err
+	}
+	return &payment, nil
+}
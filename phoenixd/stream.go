@@ -0,0 +1,253 @@
+package phoenixd
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+const (
+	defaultMinBackoff   = 500 * time.Millisecond
+	defaultMaxBackoff   = 30 * time.Second
+	defaultResumeWindow = time.Hour
+	// The number of payments listed per request on reconnection.
+	resumePageSize = 50
+	// The number of payment hashes remembered to drop duplicates.
+	seenCapacity = 4096
+)
+
+// EventStream receives the live events of phoenixd over its websocket.
+//
+// The connection is reestablished with an exponential backoff. The payments received
+// while disconnected are fetched on reconnection, and each payment is delivered once.
+type EventStream struct {
+	Client *PhoenixClient
+	// MinBackoff is the delay before reconnecting after a failure, 500ms by default.
+	MinBackoff time.Duration
+	// MaxBackoff caps the delay between reconnections, 30s by default.
+	MaxBackoff time.Duration
+	// ResumeWindow is how far back the payments are listed on reconnection, since
+	// invoices created earlier may have been paid while disconnected. 1h by default.
+	ResumeWindow time.Duration
+	// OnError is called with the errors of the connection, if set.
+	OnError func(error)
+
+	// The time of the last event delivered, or of the first connection.
+	since time.Time
+	seen  map[string]struct{}
+	order []string
+}
+
+// Create an EventStream with the default backoff.
+func NewEventStream(client *PhoenixClient) *EventStream {
+	return &EventStream{Client: client}
+}
+
+// Events delivers the payments received by the node.
+//
+// The channel is closed once the context is done.
+func (c *PhoenixClient) Events(ctx context.Context) <-chan PaymentReceived {
+	return NewEventStream(c).Events(ctx)
+}
+
+// Events connects to the websocket and delivers the payments received until the context is done.
+//
+// A stream should only be consumed once at a time.
+func (s *EventStream) Events(ctx context.Context) <-chan PaymentReceived {
+	events := make(chan PaymentReceived)
+
+	go func() {
+		defer close(events)
+
+		backoff := s.minBackoff()
+		for {
+			conn, err := s.dial(ctx)
+			if err == nil {
+				backoff = s.minBackoff()
+
+				if s.since.IsZero() {
+					s.since = time.Now()
+				} else if err := s.resume(ctx, events); err != nil {
+					s.report(err)
+				}
+
+				err = s.read(ctx, conn, events)
+			}
+
+			if ctx.Err() != nil {
+				return
+			}
+			s.report(err)
+
+			if sleep(ctx, backoff) != nil {
+				return
+			}
+			backoff = min(2*backoff, s.maxBackoff())
+		}
+	}()
+
+	return events
+}
+
+func (s *EventStream) minBackoff() time.Duration {
+	if s.MinBackoff > 0 {
+		return s.MinBackoff
+	}
+	return defaultMinBackoff
+}
+
+func (s *EventStream) maxBackoff() time.Duration {
+	if s.MaxBackoff > 0 {
+		return s.MaxBackoff
+	}
+	return defaultMaxBackoff
+}
+
+func (s *EventStream) report(err error) {
+	if s.OnError != nil && err != nil {
+		s.OnError(err)
+	}
+}
+
+// dial opens an authenticated connection to the websocket.
+func (s *EventStream) dial(ctx context.Context) (*websocket.Conn, error) {
+	endpoint, err := url.Parse(s.Client.BaseURL)
+	if err != nil {
+		return nil, err
+	}
+
+	origin := endpoint.String()
+	endpoint.Scheme = strings.Replace(endpoint.Scheme, "http", "ws", 1)
+	endpoint.Path = strings.TrimSuffix(endpoint.Path, "/") + "/websocket"
+
+	config, err := websocket.NewConfig(endpoint.String(), origin)
+	if err != nil {
+		return nil, err
+	}
+	config.Header.Set("Authorization", s.Client.createAuthHeader())
+
+	return config.DialContext(ctx)
+}
+
+// read delivers the events of a connection until it fails.
+func (s *EventStream) read(ctx context.Context, conn *websocket.Conn, events chan<- PaymentReceived) error {
+	done := make(chan struct{})
+	defer close(done)
+	defer conn.Close()
+
+	// Unblock the reads when the context is done.
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	for {
+		var message []byte
+		if err := websocket.Message.Receive(conn, &message); err != nil {
+			return err
+		}
+
+		var event PaymentReceived
+		if err := json.Unmarshal(message, &event); err != nil {
+			s.report(err)
+			continue
+		}
+		if event.Type != PaymentReceivedType {
+			continue
+		}
+
+		if !s.deliver(ctx, event, events) {
+			return ctx.Err()
+		}
+	}
+}
+
+// resume delivers the payments received since the last event.
+func (s *EventStream) resume(ctx context.Context, events chan<- PaymentReceived) error {
+	window := s.ResumeWindow
+	if window <= 0 {
+		window = defaultResumeWindow
+	}
+
+	// The payments are listed by pages, until a page is not full.
+	since := s.since
+	req := &ListIncomingPaymentsRequest{
+		ListPaymentsRequest: ListPaymentsRequest{From: since.Add(-window), Limit: resumePageSize},
+	}
+	for {
+		payments, err := s.Client.ListIncomingPayments(ctx, req)
+		if err != nil {
+			return err
+		}
+
+		for _, payment := range payments {
+			if !payment.IsPaid || payment.CompletedAt < since.UnixMilli() {
+				continue
+			}
+
+			event := PaymentReceived{
+				Type:        PaymentReceivedType,
+				Timestamp:   payment.CompletedAt,
+				AmountSat:   payment.ReceivedSat,
+				PaymentHash: payment.PaymentHash,
+				ExternalId:  payment.ExternalId,
+			}
+			if !s.deliver(ctx, event, events) {
+				return ctx.Err()
+			}
+		}
+
+		if len(payments) < resumePageSize {
+			return nil
+		}
+		req.Offset += uint(len(payments))
+	}
+}
+
+// deliver sends an event unless it was already delivered, and returns false if the context is done.
+func (s *EventStream) deliver(ctx context.Context, event PaymentReceived, events chan<- PaymentReceived) bool {
+	if s.seen == nil {
+		s.seen = make(map[string]struct{})
+	}
+	if _, ok := s.seen[event.PaymentHash]; ok {
+		return true
+	}
+
+	select {
+	case events <- event:
+	case <-ctx.Done():
+		return false
+	}
+
+	s.seen[event.PaymentHash] = struct{}{}
+	s.order = append(s.order, event.PaymentHash)
+	if len(s.order) > seenCapacity {
+		delete(s.seen, s.order[0])
+		s.order = s.order[1:]
+	}
+
+	if timestamp := time.UnixMilli(event.Timestamp); timestamp.After(s.since) {
+		s.since = timestamp
+	}
+	return true
+}
+
+// sleep waits for a duration, or until the context is done.
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
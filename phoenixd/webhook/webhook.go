@@ -0,0 +1,144 @@
+// Package webhook receives the events posted by phoenixd to its webhook.
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"lsat/phoenixd"
+	"net/http"
+	"sync"
+)
+
+// SignatureHeader is the header holding the HMAC-SHA256 of the body, hex encoded.
+const SignatureHeader = "X-Phoenix-Signature"
+
+// The maximum size of an event.
+const maxEventSize = 1 << 16
+
+var (
+	ErrMissingSignature = errors.New("the webhook signature is missing")
+	ErrInvalidSignature = errors.New("the webhook signature is invalid")
+)
+
+// Sign computes the signature of a body with the webhook secret.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks the signature of a body in constant time.
+func Verify(secret string, body []byte, signature string) error {
+	if signature == "" {
+		return ErrMissingSignature
+	}
+
+	expected, _ := hex.DecodeString(Sign(secret, body))
+	actual, err := hex.DecodeString(signature)
+	if err != nil || !hmac.Equal(expected, actual) {
+		return ErrInvalidSignature
+	}
+
+	return nil
+}
+
+// Handler verifies the events posted by phoenixd and fans them out to the subscribers.
+type Handler struct {
+	secret string
+
+	mu          sync.RWMutex
+	nextId      int
+	subscribers map[int]func(phoenixd.PaymentReceived)
+}
+
+// Create a new Handler verifying the events with the webhook secret of phoenixd.
+func NewHandler(secret string) *Handler {
+	return &Handler{
+		secret:      secret,
+		subscribers: make(map[int]func(phoenixd.PaymentReceived)),
+	}
+}
+
+// Subscribe registers a callback called for every payment received.
+//
+// The callbacks are called in turn while handling the request, so they should return quickly.
+// The returned function removes the subscription.
+func (h *Handler) Subscribe(callback func(phoenixd.PaymentReceived)) (unsubscribe func()) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	id := h.nextId
+	h.nextId++
+	h.subscribers[id] = callback
+
+	return func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		delete(h.subscribers, id)
+	}
+}
+
+// Publish delivers an event to the subscribers.
+func (h *Handler) Publish(event phoenixd.PaymentReceived) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	for _, callback := range h.subscribers {
+		callback(event)
+	}
+}
+
+// Parse verifies and decodes an event.
+//
+// Events of other types are returned as nil without error, so that new event types
+// do not make the webhook fail.
+func (h *Handler) Parse(body []byte, signature string) (*phoenixd.PaymentReceived, error) {
+	if err := Verify(h.secret, body, signature); err != nil {
+		return nil, err
+	}
+
+	var event phoenixd.PaymentReceived
+	if err := json.Unmarshal(body, &event); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal the event: %v", err)
+	}
+
+	if event.Type != phoenixd.PaymentReceivedType {
+		return nil, nil
+	}
+
+	return &event, nil
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxEventSize))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	event, err := h.Parse(body, r.Header.Get(SignatureHeader))
+	if errors.Is(err, ErrMissingSignature) || errors.Is(err, ErrInvalidSignature) {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if event != nil {
+		h.Publish(*event)
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
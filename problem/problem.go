@@ -0,0 +1,117 @@
+// Package problem answers the errors of the authorization with the problem details of RFC 7807,
+// in application/problem+json, so that the clients can react to their stable codes:
+//
+//	{"type":"urn:l402:problem:token_expired","title":"Token Expired","status":401,
+//	 "detail":"the token is expired: ...","code":"token_expired"}
+package problem
+
+import (
+	"encoding/json"
+	"errors"
+	"lsat/auth"
+	"lsat/challenge"
+	"lsat/macaroon"
+	"lsat/service"
+	"net/http"
+)
+
+// ContentType is the media type of the problem details.
+const ContentType = "application/problem+json"
+
+// The stable codes of the problems.
+const (
+	CodeMalformed           = "malformed_credentials"
+	CodeInvalidServiceID    = "invalid_service_id"
+	CodeBadSignature        = "bad_signature"
+	CodeUnpaid              = "unpaid"
+	CodeExpired             = "token_expired"
+	CodeNotYetValid         = "token_not_yet_valid"
+	CodeRevoked             = "token_revoked"
+	CodeInvalidCaveats      = "invalid_caveats"
+	CodeWrongService        = "wrong_service"
+	CodeRequestDenied       = "request_denied"
+	CodeQuotaExhausted      = "quota_exhausted"
+	CodePaymentRequired     = "payment_required"
+	CodeUnknownService      = "unknown_service"
+	CodeServiceDisabled     = "service_disabled"
+	CodeChallengeFailed     = "challenge_failed"
+	CodeUnknownChallenge    = "unknown_challenge"
+	CodeInvalidPaymentHash  = "invalid_payment_hash"
+	CodeUpstreamUnavailable = "upstream_unavailable"
+	CodeUpstreamTimeout     = "upstream_timeout"
+	CodeInternal            = "internal_error"
+	CodeNotImplemented      = "not_implemented"
+)
+
+// Problem is the details of an error, as in RFC 7807, with its code.
+type Problem struct {
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Detail string `json:"detail,omitempty"`
+	Code   string `json:"code"`
+}
+
+// Create a new Problem, its type is derived from the code.
+func New(status int, code string, title string, detail string) Problem {
+	return Problem{Type: "urn:l402:problem:" + code, Title: title, Status: status, Detail: detail, Code: code}
+}
+
+// A kind of error, and the problem it is answered with.
+type kind struct {
+	errs   []error
+	status int
+	code   string
+	title  string
+}
+
+// The kinds of errors, the first matching one is used.
+var kinds = []kind{
+	{[]error{auth.ErrInvalidScheme, auth.ErrInvalidAuth}, http.StatusBadRequest, CodeMalformed, "Malformed Credentials"},
+	{[]error{service.ErrInvalidServiceID}, http.StatusBadRequest, CodeInvalidServiceID, "Invalid Service ID"},
+	{[]error{auth.ErrSignature}, http.StatusUnauthorized, CodeBadSignature, "Bad Signature"},
+	{[]error{macaroon.ErrUnpaid}, http.StatusUnauthorized, CodeUnpaid, "Unpaid Token"},
+	{[]error{service.ErrExpired}, http.StatusUnauthorized, CodeExpired, "Token Expired"},
+	{[]error{service.ErrNotYetValid}, http.StatusUnauthorized, CodeNotYetValid, "Token Not Yet Valid"},
+	{[]error{service.ErrRevoked}, http.StatusUnauthorized, CodeRevoked, "Token Revoked"},
+	{[]error{service.ErrCaveats}, http.StatusUnauthorized, CodeInvalidCaveats, "Invalid Caveats"},
+	{[]error{auth.ErrNotGranted}, http.StatusForbidden, CodeWrongService, "Wrong Service"},
+	{[]error{service.ErrRequestDenied}, http.StatusForbidden, CodeRequestDenied, "Request Denied"},
+	{[]error{service.ErrQuotaExhausted}, http.StatusTooManyRequests, CodeQuotaExhausted, "Quota Exhausted"},
+	{[]error{auth.ErrSpent}, http.StatusPaymentRequired, CodePaymentRequired, "Payment Required"},
+	{[]error{service.ErrUnknownService}, http.StatusNotFound, CodeUnknownService, "Unknown Service"},
+	{[]error{service.ErrDisabled}, http.StatusForbidden, CodeServiceDisabled, "Service Disabled"},
+	{[]error{auth.ErrChallenge}, http.StatusServiceUnavailable, CodeChallengeFailed, "Challenge Failed"},
+	{[]error{challenge.ErrUnknownChallenge}, http.StatusNotFound, CodeUnknownChallenge, "Unknown Challenge"},
+}
+
+// From returns the problem of an error.
+//
+// The errors that are not of a known kind are internal errors, and their details are not sent.
+func From(err error) Problem {
+	for _, k := range kinds {
+		for _, target := range k.errs {
+			if errors.Is(err, target) {
+				return New(k.status, k.code, k.title, err.Error())
+			}
+		}
+	}
+	return New(http.StatusInternalServerError, CodeInternal, "Internal Error", "")
+}
+
+// PaymentRequired is the problem answered with a challenge.
+func PaymentRequired() Problem {
+	return New(http.StatusPaymentRequired, CodePaymentRequired, "Payment Required", "pay the invoice of the challenge to get a token")
+}
+
+// Write answers with a problem.
+func Write(w http.ResponseWriter, p Problem) {
+	w.Header().Set("Content-Type", ContentType)
+	w.WriteHeader(p.Status)
+	json.NewEncoder(w).Encode(p)
+}
+
+// WriteError answers with the problem of an error.
+func WriteError(w http.ResponseWriter, err error) {
+	Write(w, From(err))
+}
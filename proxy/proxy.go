@@ -1,15 +1,26 @@
 package proxy
 
 import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
-	"fmt"
+	"lsat/amount"
 	"lsat/auth"
+	"lsat/challenge"
 	"lsat/macaroon"
-	"lsat/secrets"
+	"lsat/metered"
+	"lsat/metrics"
+	"lsat/middleware"
+	"lsat/phoenixd"
+	"lsat/phoenixd/webhook"
+	"lsat/problem"
 	"lsat/service"
 	"net/http"
 	"os"
 	"strings"
+	"sync"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
@@ -17,13 +28,28 @@ import (
 )
 
 const (
-	macaroonHeader    = "L402"
 	authFailedMessage = "Authentication failed!"
 )
 
 // L402ProxyServer is a struct that contains the necessary information to handle service requests.
 type L402ProxyServer struct {
 	*auth.Minter
+
+	// Pending tracks the challenges issued, if set. The challenger of the Minter
+	// should be a challenge.TrackingChallenger recording into it.
+	Pending *challenge.PendingChallenges
+	// Webhook receives the settlement events of phoenixd, if set.
+	Webhook *webhook.Handler
+	// Routes are forwarded to upstream backends once paid.
+	Routes []Route
+	// Metrics record the latency of the Routes, if set. It should also be the observer of the Minter.
+	Metrics *metrics.Metrics
+	// MetricsToken is the bearer token required to read the Metrics on /metrics. The metrics
+	// are not served without it, e.g. to serve them on an internal listener instead.
+	MetricsToken string
+
+	subscribe sync.Once
+	payments  metered.Payments // Receives the preimages of the metered Routes.
 }
 
 // Handle the minting of a new token.
@@ -32,140 +58,179 @@ func (h *L402ProxyServer) HandleMint(c *gin.Context) {
 	// Parse the service name.
 	serviceID, err := service.ParseServiceID(serviceName)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		problem.WriteError(c.Writer, err)
 		return
 	}
 
-	// Mint a new token.
-	uid := secrets.NewUserId()
-	pretoken, err := h.Minter.MintToken(uid, serviceID)
+	h.challenge(c, serviceID)
+}
+
+// Answer with a challenge to pay for a new token.
+func (h *L402ProxyServer) challenge(c *gin.Context, serviceID service.ServiceID) {
+	middleware.WriteChallenge(c.Writer, c.Request, h.Minter, serviceID)
+}
+
+// Authorize the token of a request for a service, and spend it if it is sold per request.
+//
+// The request is answered with a challenge if the token is spent, and with the problem of the
+// error if it is invalid.
+func (h *L402ProxyServer) authorize(c *gin.Context) (service.ServiceID, macaroon.Token, bool) {
+	serviceID, err := service.ParseServiceID(c.Param("service"))
 	if err != nil {
-		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
-		return
+		problem.WriteError(c.Writer, err)
+		return serviceID, macaroon.Token{}, false
 	}
 
-	// Set the WWW-Authenticate header.
-	mac := pretoken.Macaroon
-	authHeader := fmt.Sprintf("%s macaroon=\"%s\", invoice=\"%s\"", macaroonHeader, mac, pretoken.InvoiceResponse.Invoice)
-	c.Header("WWW-Authenticate", authHeader)
-	c.JSON(http.StatusPaymentRequired, gin.H{"error": "Payment Required"})
+	token, err := middleware.AuthorizeRequest(h.Minter, c.Request, serviceID)
+	if errors.Is(err, auth.ErrSpent) {
+		h.challenge(c, serviceID)
+		return serviceID, token, false
+	} else if err != nil {
+		problem.WriteError(c.Writer, err)
+		return serviceID, token, false
+	}
+	return serviceID, token, true
 }
 
-// Parse a token from the Authorization header.
-func parseToken(authHeader string) (macaroon.Token, error) {
-	// Get the Authorization header from the request
-	parts := strings.Split(authHeader, " ")
-
-	if len(parts) != 2 || parts[0] != macaroonHeader {
-		return macaroon.Token{}, errors.New("Invalid Authorization header")
+// Run a callback of a service, the token is released if it fails, like in middleware.L402.
+func (h *L402ProxyServer) callback(c *gin.Context, token macaroon.Token, callback func(any) error) {
+	if err := callback(c); err != nil {
+		h.Minter.Release(&token)
+		problem.WriteError(c.Writer, err)
 	}
+}
 
-	credentials := strings.Split(parts[1], ":")
-	if len(credentials) != 2 {
-		return macaroon.Token{}, errors.New("Invalid credentials")
+// Handle an update on a service.
+func (h *L402ProxyServer) HandleUpdate(c *gin.Context) {
+	serviceID, token, ok := h.authorize(c)
+	if !ok {
+		return
 	}
 
-	mac, err := macaroon.DecodeBase64(credentials[0])
-	if err != nil {
-		return macaroon.Token{}, err
+	// Execute callbacks for this service
+	if service, err := h.Minter.ServiceManager().GetService(serviceID); err == nil {
+		if service.Post != nil {
+			h.callback(c, token, service.Post)
+			return
+		}
 	}
+}
 
-	preimage, err := lntypes.MakePreimageFromStr(credentials[1])
-	if err != nil {
-		return macaroon.Token{}, err
+// Handle the authorization of a token.
+func (h *L402ProxyServer) HandleToken(c *gin.Context) {
+	serviceID, token, ok := h.authorize(c)
+	if !ok {
+		return
 	}
 
-	token := macaroon.Token{
-		Macaroon: mac,
-		Preimage: preimage,
+	// Execute callbacks for this service
+	if service, err := h.Minter.ServiceManager().GetService(serviceID); err == nil {
+		if service.Get != nil {
+			h.callback(c, token, service.Get)
+			return
+		}
 	}
 
-	return token, nil
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
 }
 
-// Handle an update on a service.
-func (h *L402ProxyServer) HandleUpdate(c *gin.Context) {
-	// Get service ID from the request
-	serviceName := c.Param("service")
-	serviceID, err := service.ParseServiceID(serviceName)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+// Handle a request for the catalog of the services.
+//
+// The catalog is answered with an ETag, and with 304 Not Modified if it matches If-None-Match.
+// It is not implemented, with 501, if the service manager is not a service.ServiceLister.
+func (h *L402ProxyServer) HandleServices(c *gin.Context) {
+	lister, ok := h.Minter.ServiceManager().(service.ServiceLister)
+	if !ok {
+		problem.Write(c.Writer, problem.New(http.StatusNotImplemented, problem.CodeNotImplemented, "Not Implemented", "the service manager cannot list its services"))
 		return
 	}
 
-	// Get the Authorization header from the request
-	authHeader := c.GetHeader("Authorization")
-
-	// Parse the token from the Authorization header
-	token, err := parseToken(authHeader)
+	body, err := json.Marshal(service.NewCatalog(lister.Services()...))
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		problem.WriteError(c.Writer, err)
 		return
 	}
 
-	// Check if the token is valid.
-	err = h.Minter.AuthToken(&token)
-	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+	sum := sha256.Sum256(body)
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+	c.Header("ETag", etag)
+	c.Header("Cache-Control", "no-cache")
+	if matchETag(c.GetHeader("If-None-Match"), etag) {
+		c.Status(http.StatusNotModified)
 		return
 	}
+	c.Data(http.StatusOK, "application/json; charset=utf-8", body)
+}
 
-	// Execute callbacks for this service
-	if service, err := h.Minter.ServiceManager().GetService(serviceID); err == nil {
-		if service.Get != nil {
-			if err := service.Post(c); err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			}
-			return
+// Whether an If-None-Match header matches an ETag, with the weak comparison.
+func matchETag(ifNoneMatch string, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
 		}
 	}
+	return false
 }
 
-// Handle the authorization of a token.
-func (h *L402ProxyServer) HandleToken(c *gin.Context) {
-	// Get service ID from the request
-	serviceName := c.Param("service")
-	serviceID, err := service.ParseServiceID(serviceName)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+// Mark the challenge of a payment received as paid.
+func (h *L402ProxyServer) HandleSettlement(event phoenixd.PaymentReceived) {
+	if h.Pending == nil {
 		return
 	}
 
-	// Get the Authorization header from the request
-	authHeader := c.GetHeader("Authorization")
+	paymentHash, err := lntypes.MakeHashFromStr(event.PaymentHash)
+	if err != nil {
+		return
+	}
 
-	// Parse the token from the Authorization header
-	token, err := parseToken(authHeader)
+	// Payments of invoices that are not challenges are ignored.
+	h.Pending.Settle(paymentHash, amount.FromSatoshis(event.AmountSat))
+}
 
-	// Check if the token is valid.
-	err = h.Minter.AuthToken(&token)
+// Handle a request for the state of a challenge.
+func (h *L402ProxyServer) HandleChallengeStatus(c *gin.Context) {
+	paymentHash, err := lntypes.MakeHashFromStr(c.Param("hash"))
 	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		problem.Write(c.Writer, problem.New(http.StatusBadRequest, problem.CodeInvalidPaymentHash, "Invalid Payment Hash", err.Error()))
 		return
 	}
 
-	// Execute callbacks for this service
-	if service, err := h.Minter.ServiceManager().GetService(serviceID); err == nil {
-		if service.Get != nil {
-			if err := service.Get(c); err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			}
-			return
-		}
+	status, ok := h.Pending.Status(paymentHash)
+	if !ok {
+		problem.WriteError(c.Writer, challenge.ErrUnknownChallenge)
+		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+	c.JSON(http.StatusOK, gin.H{
+		"payment_hash": status.PaymentHash.String(),
+		"amount_msat":  status.Amount,
+		"paid":         status.Paid,
+	})
 }
 
-// Run the service.
-func (h *L402ProxyServer) Run() {
+// Reject the requests for the metrics without the MetricsToken.
+func (h *L402ProxyServer) authenticateMetrics(c *gin.Context) {
+	token, found := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if !found || subtle.ConstantTimeCompare([]byte(token), []byte(h.MetricsToken)) != 1 {
+		c.Header("WWW-Authenticate", "Bearer")
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+	c.Next()
+}
+
+// Router builds the routes of the server.
+//
+// The proxy is subscribed to the settlement events of the Webhook on the first call.
+func (h *L402ProxyServer) Router() *gin.Engine {
 	// Initialize the Gin router.
 	router := gin.Default()
 
 	// Configure CORS middleware
 	config := cors.DefaultConfig()
 	config.AllowOrigins = []string{"*"} // Allow all origins
-	config.AllowMethods = []string{"GET", "PUT", "POST", "OPTIONS"}
+	config.AllowMethods = []string{"GET", "HEAD", "PUT", "POST", "PATCH", "DELETE", "OPTIONS"}
 	config.AllowHeaders = []string{
 		"Origin",
 		"Content-Type",
@@ -175,19 +240,48 @@ func (h *L402ProxyServer) Run() {
 	}
 	config.ExposeHeaders = []string{
 		"WWW-Authenticate", // Important to expose this header for LSAT
+		"ETag",
 	}
 
 	// Use CORS middleware
 	router.Use(cors.New(config))
 
 	// Define the routes.
+	router.GET("/services", h.HandleServices)
 	router.PUT("/service/:service", h.HandleMint)
 	router.POST("/service/:service", h.HandleUpdate)
 	router.GET("/service/:service", h.HandleToken)
 
+	if h.Pending != nil {
+		router.GET("/challenge/:hash", h.HandleChallengeStatus)
+	}
+	if h.Metrics != nil && h.MetricsToken != "" {
+		router.GET("/metrics", h.authenticateMetrics, gin.WrapH(h.Metrics))
+	}
+	anyMetered := false
+	for _, route := range h.Routes {
+		handler := h.HandleRoute(route)
+		router.Any(route.PathPrefix, handler)
+		router.Any(route.PathPrefix+"/*path", handler)
+		anyMetered = anyMetered || !route.Metered.IsZero()
+	}
+	if anyMetered {
+		router.POST(PreimagePath, gin.WrapH(&h.payments))
+	}
+
+	if h.Webhook != nil {
+		h.subscribe.Do(func() { h.Webhook.Subscribe(h.HandleSettlement) })
+		router.POST("/webhook/phoenixd", gin.WrapH(h.Webhook))
+	}
+
+	return router
+}
+
+// Run the service.
+func (h *L402ProxyServer) Run() {
 	// Start the server.
 	port := getEnv("PORT", "8080")
-	router.Run("localhost:" + port)
+	h.Router().Run("localhost:" + port)
 }
 
 // Get the value of an environment variable or a default value.
@@ -0,0 +1,120 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"lsat/metered"
+	"lsat/middleware"
+	"lsat/problem"
+	"lsat/service"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// ServiceHeader tells the upstream which service authorized the request.
+	ServiceHeader = "X-L402-Service"
+	// PreimagePath receives the preimages of the invoices of the metered routes.
+	PreimagePath = "/metered/preimage"
+
+	defaultTimeout     = 30 * time.Second
+	defaultDialTimeout = 10 * time.Second
+)
+
+// A Route forwards the requests under a path prefix to an upstream backend, once paid.
+type Route struct {
+	Service    service.ServiceID // The service whose tokens grant access to the route.
+	PathPrefix string            // The prefix of the proxied paths, stripped before forwarding.
+	Upstream   *url.URL          // The base URL of the backend.
+	Timeout    time.Duration     // The time to wait for the response headers, 30s by default.
+	Headers    http.Header       // Headers set on the forwarded requests, e.g. the credentials of the backend.
+	Metered    metered.Allowance // Charges the event streams of the route by time or bytes, if set.
+}
+
+// Create a new Route to an upstream URL.
+func NewRoute(id service.ServiceID, pathPrefix string, upstream string) (Route, error) {
+	if !strings.HasPrefix(pathPrefix, "/") || pathPrefix == "/" {
+		return Route{}, fmt.Errorf("invalid path prefix %q: it must start with / and not be the root", pathPrefix)
+	}
+
+	target, err := url.Parse(upstream)
+	if err != nil {
+		return Route{}, err
+	}
+	if target.Scheme != "http" && target.Scheme != "https" {
+		return Route{}, fmt.Errorf("invalid upstream %q: the scheme must be http or https", upstream)
+	}
+
+	return Route{Service: id, PathPrefix: strings.TrimSuffix(pathPrefix, "/"), Upstream: target}, nil
+}
+
+// ReverseProxy builds the proxy forwarding the requests of the route.
+//
+// The L402 credentials and the headers set by the proxy are removed from the incoming
+// requests, the X-Forwarded headers are set from the connection, and the responses are
+// flushed as they are received so that streams reach the client.
+func (route Route) ReverseProxy() *httputil.ReverseProxy {
+	timeout := route.Timeout
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = (&net.Dialer{Timeout: defaultDialTimeout}).DialContext
+	transport.ResponseHeaderTimeout = timeout
+
+	return &httputil.ReverseProxy{
+		Rewrite: func(r *httputil.ProxyRequest) {
+			r.Out.URL.Path = strings.TrimPrefix(r.In.URL.Path, route.PathPrefix)
+			r.Out.URL.RawPath = strings.TrimPrefix(r.In.URL.RawPath, route.PathPrefix)
+			r.SetURL(route.Upstream)
+			r.SetXForwarded()
+
+			r.Out.Header.Del("Authorization")
+			for key := range r.Out.Header {
+				if strings.HasPrefix(strings.ToLower(key), "x-l402-") {
+					r.Out.Header.Del(key)
+				}
+			}
+			r.Out.Header.Set(ServiceHeader, route.Service.String())
+			for key, values := range route.Headers {
+				r.Out.Header[http.CanonicalHeaderKey(key)] = values
+			}
+		},
+		Transport:     transport,
+		FlushInterval: -1,
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			var netErr net.Error
+			if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+				problem.Write(w, problem.New(http.StatusGatewayTimeout, problem.CodeUpstreamTimeout, "Upstream Timeout", "the upstream of the route did not answer in time"))
+				return
+			}
+			problem.Write(w, problem.New(http.StatusBadGateway, problem.CodeUpstreamUnavailable, "Upstream Unavailable", "the upstream of the route is unavailable"))
+		},
+	}
+}
+
+// Handle a request to a route.
+//
+// Requests without a token are challenged, and requests with a valid token are forwarded.
+// The event streams of a metered route are paused for a payment whenever the allowance of
+// the stream runs out, until the preimage of the invoice is posted to PreimagePath.
+func (h *L402ProxyServer) HandleRoute(route Route) gin.HandlerFunc {
+	var upstream http.Handler = route.ReverseProxy()
+	if h.Metrics != nil {
+		upstream = h.Metrics.Upstream(route.Service, upstream)
+	}
+	if !route.Metered.IsZero() {
+		meter := metered.NewMeter(h.Minter, route.Service, route.Metered)
+		meter.Payments = &h.payments
+		upstream = meter.SSE(upstream)
+	}
+	return gin.WrapH(middleware.L402(h.Minter, route.Service)(upstream))
+}
@@ -0,0 +1,73 @@
+package rates
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// CachedProvider caches the rates of another RateProvider and enforces a staleness limit.
+//
+// A cached rate is reused for TTL. After that the provider is queried again, and if it
+// fails, the cached rate is still used as long as it is younger than MaxAge. A rate older
+// than MaxAge is never returned, whichever provider produced it.
+type CachedProvider struct {
+	Provider RateProvider     // The underlying provider.
+	TTL      time.Duration    // How long a rate is reused before refreshing it.
+	MaxAge   time.Duration    // The maximum age of a rate, zero disables the limit.
+	Clock    func() time.Time // The clock used to date the rates, defaults to time.Now.
+
+	mu    sync.Mutex
+	rates map[Currency]Rate
+}
+
+// Create a new CachedProvider.
+func NewCachedProvider(provider RateProvider, ttl, maxAge time.Duration) *CachedProvider {
+	return &CachedProvider{
+		Provider: provider,
+		TTL:      ttl,
+		MaxAge:   maxAge,
+	}
+}
+
+func (p *CachedProvider) now() time.Time {
+	if p.Clock != nil {
+		return p.Clock()
+	}
+	return time.Now()
+}
+
+// Rate returns the cached rate of the currency, refreshing it when needed.
+func (p *CachedProvider) Rate(ctx context.Context, currency Currency) (Rate, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := p.now()
+
+	cached, ok := p.rates[currency]
+	if ok && cached.Age(now) < p.TTL {
+		return cached, nil
+	}
+
+	rate, err := p.Provider.Rate(ctx, currency)
+	if err == nil {
+		if p.rates == nil {
+			p.rates = make(map[Currency]Rate)
+		}
+		p.rates[currency] = rate
+		cached, ok = rate, true
+	} else if !ok {
+		return Rate{}, err
+	}
+
+	// Either the fresh rate or the fallback must be within the staleness limit.
+	if p.MaxAge > 0 && cached.Age(now) > p.MaxAge {
+		if err != nil {
+			return Rate{}, fmt.Errorf("%w: %v", ErrStaleRate, err)
+		}
+		return Rate{}, fmt.Errorf("%w: quoted at %s", ErrStaleRate, cached.Timestamp.Format(time.RFC3339))
+	}
+
+	return cached, nil
+}
@@ -0,0 +1,115 @@
+package rates
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The placeholder replaced by the currency code in the URL of an HTTPProvider.
+const CurrencyPlaceholder = "{currency}"
+
+// HTTPProvider fetches exchange rates from an HTTP endpoint returning JSON.
+//
+// For example, the Coinbase spot price API can be used with:
+//
+//	HTTPProvider{
+//		URL:   "https://api.coinbase.com/v2/prices/BTC-{currency}/spot",
+//		Field: "data.amount",
+//	}
+type HTTPProvider struct {
+	URL        string       // The URL of the endpoint, {currency} is replaced by the currency code.
+	Field      string       // The dotted path to the price in the JSON response.
+	HTTPClient *http.Client // The client used for the requests.
+}
+
+// Create a new HTTPProvider.
+func NewHTTPProvider(url, field string) *HTTPProvider {
+	return &HTTPProvider{
+		URL:        url,
+		Field:      field,
+		HTTPClient: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Rate fetches the rate of the currency from the endpoint.
+func (p *HTTPProvider) Rate(ctx context.Context, currency Currency) (Rate, error) {
+	url := strings.ReplaceAll(p.URL, CurrencyPlaceholder, string(currency))
+
+	httpReq, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return Rate{}, err
+	}
+	httpReq.Header.Set("Accept", "application/json")
+
+	client := p.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return Rate{}, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return Rate{}, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return Rate{}, fmt.Errorf("rate provider returned %s: %s", resp.Status, string(body))
+	}
+
+	var document any
+	if err := json.Unmarshal(body, &document); err != nil {
+		return Rate{}, err
+	}
+
+	price, err := lookupPrice(document, p.Field)
+	if err != nil {
+		return Rate{}, err
+	}
+
+	if price <= 0 {
+		return Rate{}, ErrInvalidRate
+	}
+
+	return Rate{Currency: currency, Price: price, Timestamp: time.Now()}, nil
+}
+
+// lookupPrice follows the dotted path in a JSON document and returns the price it points to.
+//
+// The price can be either a JSON number or a string holding a number.
+func lookupPrice(document any, path string) (float64, error) {
+	value := document
+
+	if path != "" {
+		for _, key := range strings.Split(path, ".") {
+			object, ok := value.(map[string]any)
+			if !ok {
+				return 0, fmt.Errorf("the field %s is not an object", key)
+			}
+
+			value, ok = object[key]
+			if !ok {
+				return 0, fmt.Errorf("the field %s is missing", key)
+			}
+		}
+	}
+
+	switch price := value.(type) {
+	case float64:
+		return price, nil
+	case string:
+		return strconv.ParseFloat(price, 64)
+	default:
+		return 0, fmt.Errorf("the field %s is not a number", path)
+	}
+}
@@ -0,0 +1,148 @@
+// Package rates provides exchange rates used to price services in fiat currencies.
+package rates
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"lsat/amount"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Currency is an ISO 4217 currency code.
+type Currency string
+
+const (
+	USD Currency = "USD"
+	EUR Currency = "EUR"
+	GBP Currency = "GBP"
+	JPY Currency = "JPY"
+	CHF Currency = "CHF"
+	CAD Currency = "CAD"
+)
+
+// The number of decimals of the minor unit of each currency.
+//
+// Currencies that are not listed are assumed to have two decimals.
+var decimals = map[Currency]int{
+	JPY: 0,
+}
+
+var (
+	ErrUnsupportedCurrency = errors.New("unsupported currency")
+	ErrStaleRate           = errors.New("the exchange rate is stale")
+	ErrInvalidRate         = errors.New("the exchange rate must be positive")
+)
+
+// Decimals returns the number of decimals of the minor unit of the currency.
+func (c Currency) Decimals() int {
+	if d, ok := decimals[c]; ok {
+		return d
+	}
+	return 2
+}
+
+func (c Currency) String() string {
+	return string(c)
+}
+
+// Price is an amount stated in a currency.
+type Price struct {
+	Amount   uint64   // The amount in the minor unit of the currency (e.g. cents).
+	Currency Currency // The currency of the amount.
+}
+
+// IsZero returns true if no price is set.
+func (p Price) IsZero() bool {
+	return p.Currency == ""
+}
+
+func (p Price) String() string {
+	return fmt.Sprintf("%d %s", p.Amount, p.Currency)
+}
+
+// Rate is the price of one bitcoin in a currency at a given time.
+type Rate struct {
+	Currency  Currency  // The quoted currency.
+	Price     float64   // The price of one bitcoin, in the major unit of the currency.
+	Timestamp time.Time // The time at which the rate was quoted.
+}
+
+// ToMilliSatoshis converts an amount in the minor unit of the currency (e.g. cents).
+//
+// The result is rounded up so that a service is never undercharged.
+func (r Rate) ToMilliSatoshis(minor uint64) (amount.MilliSatoshi, error) {
+	if r.Price <= 0 || math.IsNaN(r.Price) || math.IsInf(r.Price, 0) {
+		return 0, ErrInvalidRate
+	}
+
+	// Use exact decimal arithmetic: msat = minor * 1e11 / (price * 10^decimals).
+	price, ok := new(big.Rat).SetString(strconv.FormatFloat(r.Price, 'f', -1, 64))
+	if !ok {
+		return 0, ErrInvalidRate
+	}
+
+	scale := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(r.Currency.Decimals())), nil)
+	minorPerBitcoin := new(big.Rat).Mul(price, new(big.Rat).SetInt(scale))
+
+	msat := new(big.Rat).SetInt(new(big.Int).SetUint64(minor))
+	msat.Mul(msat, new(big.Rat).SetUint64(uint64(amount.Bitcoin)))
+	msat.Quo(msat, minorPerBitcoin)
+
+	// Round up.
+	quotient, remainder := new(big.Int).QuoRem(msat.Num(), msat.Denom(), new(big.Int))
+	if remainder.Sign() != 0 {
+		quotient.Add(quotient, big.NewInt(1))
+	}
+
+	if !quotient.IsUint64() {
+		return 0, fmt.Errorf("the amount %d %s overflows", minor, r.Currency)
+	}
+
+	return amount.MilliSatoshi(quotient.Uint64()), nil
+}
+
+// Age returns how old the rate is at the given time.
+func (r Rate) Age(now time.Time) time.Duration {
+	return now.Sub(r.Timestamp)
+}
+
+// String formats the rate as "<price> <currency>/BTC @ <timestamp>".
+func (r Rate) String() string {
+	return fmt.Sprintf("%s %s/BTC @ %s",
+		strconv.FormatFloat(r.Price, 'f', -1, 64), r.Currency, r.Timestamp.UTC().Format(time.RFC3339))
+}
+
+// ParseRate parses a rate formatted by Rate.String.
+func ParseRate(s string) (Rate, error) {
+	fields := strings.Fields(s)
+	if len(fields) != 4 || fields[2] != "@" || !strings.HasSuffix(fields[1], "/BTC") {
+		return Rate{}, fmt.Errorf("invalid exchange rate format: %s", s)
+	}
+
+	price, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return Rate{}, fmt.Errorf("invalid exchange rate price: %s", fields[0])
+	}
+
+	timestamp, err := time.Parse(time.RFC3339, fields[3])
+	if err != nil {
+		return Rate{}, err
+	}
+
+	return Rate{
+		Currency:  Currency(strings.TrimSuffix(fields[1], "/BTC")),
+		Price:     price,
+		Timestamp: timestamp,
+	}, nil
+}
+
+// RateProvider quotes the price of bitcoin in a currency.
+type RateProvider interface {
+	// Rate returns the current price of one bitcoin in the currency.
+	Rate(context.Context, Currency) (Rate, error)
+}
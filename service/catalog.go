@@ -0,0 +1,198 @@
+package service
+
+import (
+	"lsat/macaroon"
+	"sort"
+)
+
+// Catalog describes the services sold, so that the clients can budget before paying.
+type Catalog struct {
+	Services []CatalogService `json:"services"`
+}
+
+// CatalogService describes the tiers of a service.
+type CatalogService struct {
+	Name  string        `json:"name"`
+	Tiers []CatalogTier `json:"tiers"`
+}
+
+// CatalogTier describes a tier of a service, with the caveats of its tokens.
+type CatalogTier struct {
+	Tier       Tier                   `json:"tier"`
+	ID         string                 `json:"id"`
+	Price      CatalogPrice           `json:"price"`
+	PerRequest bool                   `json:"per_request"`
+	Caveats    []CaveatDescription    `json:"caveats"`
+	Conditions []ConditionDescription `json:"conditions"`
+	// The JSON schema of the caveats a holder can add to restrict a token.
+	Attenuation map[string]any `json:"attenuation"`
+}
+
+// CatalogPrice is the price of a token, in msat, or in the minor unit of a currency (e.g.
+// cents of USD) converted when the challenge is issued.
+type CatalogPrice struct {
+	Amount uint64 `json:"amount"`
+	Unit   string `json:"unit"`
+}
+
+// CaveatDescription describes a first-party caveat added to the tokens, as in the configuration.
+//
+// The value of the caveats computed when a token is minted, such as expire, is not described.
+type CaveatDescription struct {
+	Type  string `json:"type"`
+	Key   string `json:"key"`
+	Value string `json:"value,omitempty"`
+	Delay string `json:"delay,omitempty"`
+}
+
+// ConditionDescription describes a condition checked on the tokens, as in the configuration.
+type ConditionDescription struct {
+	Type string `json:"type"`
+	Key  string `json:"key,omitempty"`
+}
+
+// Create a new Catalog of the services, grouped by name. The disabled services are not sold,
+// so they are left out.
+func NewCatalog(services ...Service) Catalog {
+	var sorted []Service
+	for _, s := range services {
+		if !s.Disabled {
+			sorted = append(sorted, s)
+		}
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Name != sorted[j].Name {
+			return sorted[i].Name < sorted[j].Name
+		}
+		return sorted[i].Tier < sorted[j].Tier
+	})
+
+	catalog := Catalog{Services: []CatalogService{}}
+	for _, s := range sorted {
+		last := len(catalog.Services) - 1
+		if last < 0 || catalog.Services[last].Name != s.Name {
+			catalog.Services = append(catalog.Services, CatalogService{Name: s.Name})
+			last++
+		}
+		catalog.Services[last].Tiers = append(catalog.Services[last].Tiers, describeTier(s))
+	}
+	return catalog
+}
+
+func describeTier(s Service) CatalogTier {
+	tier := CatalogTier{
+		Tier:        s.Tier,
+		ID:          s.Id().String(),
+		Price:       CatalogPrice{Amount: uint64(s.Price), Unit: "msat"},
+		PerRequest:  s.PerRequest,
+		Caveats:     []CaveatDescription{},
+		Conditions:  []ConditionDescription{},
+		Attenuation: attenuationSchema(s.Conditions),
+	}
+	if !s.FiatPrice.IsZero() {
+		tier.Price = CatalogPrice{Amount: s.FiatPrice.Amount, Unit: string(s.FiatPrice.Currency)}
+	}
+	for _, caveat := range s.FirstPartyCaveats {
+		tier.Caveats = append(tier.Caveats, describeCaveat(caveat))
+	}
+	for _, condition := range s.Conditions {
+		tier.Conditions = append(tier.Conditions, describeCondition(condition))
+	}
+	return tier
+}
+
+func describeCaveat(caveat Caveat) CaveatDescription {
+	switch c := caveat.(type) {
+	case Expire:
+		return CaveatDescription{Type: "expire", Key: macaroon.ExpiryDateKey, Delay: c.Delay.String()}
+	case NotBefore:
+		return CaveatDescription{Type: "not_before", Key: macaroon.NotBeforeKey, Delay: c.Delay.String()}
+	case GenerateID:
+		return CaveatDescription{Type: "generate_id", Key: c.Name}
+	case Route:
+		return CaveatDescription{Type: "route", Key: macaroon.RouteKey, Value: c.Pattern}
+	case Methods:
+		return CaveatDescription{Type: "method", Key: macaroon.MethodKey, Value: c.GetValue()}
+	case macaroon.Caveat:
+		return CaveatDescription{Type: "static", Key: c.Key, Value: c.Value}
+	}
+	return CaveatDescription{Type: "custom", Key: caveat.GetKey()}
+}
+
+func describeCondition(condition Condition) ConditionDescription {
+	switch c := condition.(type) {
+	case Expire:
+		return ConditionDescription{Type: "expire"}
+	case NotBefore:
+		return ConditionDescription{Type: "not_before"}
+	case Capabilities:
+		return ConditionDescription{Type: "capabilities", Key: c.Key}
+	case UniqueKey:
+		return ConditionDescription{Type: "unique", Key: c.Key}
+	case Revoked:
+		return ConditionDescription{Type: "revoked", Key: c.Key}
+	case *Quota:
+		return ConditionDescription{Type: "quota", Key: c.Key}
+	case Route:
+		return ConditionDescription{Type: "route"}
+	case Methods:
+		return ConditionDescription{Type: "method"}
+	}
+	return ConditionDescription{Type: "custom"}
+}
+
+// attenuationSchema returns the JSON schema of the caveats enforced by the BuiltinConditions
+// and the conditions of a service, which a holder can add to restrict a token.
+func attenuationSchema(conditions []Condition) map[string]any {
+	caveats := []any{
+		caveatSchema(macaroon.RouteKey, map[string]any{
+			"type":        "string",
+			"description": "Comma-separated paths, a pattern ending with /* matches every path under its prefix.",
+		}),
+		caveatSchema(macaroon.MethodKey, map[string]any{
+			"type":        "string",
+			"pattern":     "^[A-Za-z]+( *, *[A-Za-z]+)*$",
+			"description": "Comma-separated HTTP methods.",
+		}),
+	}
+	for _, condition := range conditions {
+		switch c := condition.(type) {
+		case Expire:
+			caveats = append(caveats, caveatSchema(macaroon.ExpiryDateKey, map[string]any{
+				"type":        "string",
+				"format":      "date-time",
+				"description": "An RFC 3339 time after which the token is expired.",
+			}))
+		case NotBefore:
+			caveats = append(caveats, caveatSchema(macaroon.NotBeforeKey, map[string]any{
+				"type":        "string",
+				"format":      "date-time",
+				"description": "An RFC 3339 time before which the token is not valid, after the previous ones.",
+			}))
+		case Capabilities:
+			caveats = append(caveats, caveatSchema(c.Key, map[string]any{
+				"type":        "string",
+				"description": "Capabilities contained in the previous value of the caveat.",
+			}))
+		}
+	}
+
+	return map[string]any{
+		"$schema":     "https://json-schema.org/draft/2020-12/schema",
+		"description": "The first-party caveats that can be added to a token to restrict it.",
+		"type":        "array",
+		"items":       map[string]any{"oneOf": caveats},
+	}
+}
+
+func caveatSchema(key string, value map[string]any) map[string]any {
+	return map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"key":   map[string]any{"const": key},
+			"value": value,
+		},
+		"required":             []string{"key", "value"},
+		"additionalProperties": false,
+	}
+}
@@ -3,22 +3,31 @@ package service
 import (
 	"fmt"
 	"lsat/macaroon"
+	"slices"
+	"sync"
 	"time"
 )
 
-// Condition is a condition that must be satisfied by a set of caveats.
+// Condition is a condition that must be satisfied to verify a token.
+//
+// The conditions checked on the caveats only can be adapted with Adapt.
 type Condition interface {
-	// Satisfy checks if the set of caveats satisfies the condition.
-	Satisfy(...macaroon.Caveat) error
+	// Verify checks if the caveats of the context satisfy the condition.
+	Verify(*VerificationContext) error
 }
 
 // Timeout is a condition that checks if the expiry date of a service is valid.
 // type Timeout struct{}
 
+// Satisfy checks the caveats at the current time.
 func (e Expire) Satisfy(caveats ...macaroon.Caveat) error {
-	now := time.Now()
+	return e.Verify(NewVerificationContext(nil, caveats...))
+}
+
+func (e Expire) Verify(ctx *VerificationContext) error {
+	now := ctx.Now()
 	var previousExpiry time.Time
-	iter := macaroon.NewIterator(macaroon.ExpiryDateKey, caveats)
+	iter := macaroon.NewIterator(macaroon.ExpiryDateKey, ctx.Caveats)
 
 	for iter.HasNext() {
 		expiryTime := iter.Next()
@@ -27,12 +36,12 @@ func (e Expire) Satisfy(caveats ...macaroon.Caveat) error {
 
 		// If there is an error parsing the time, return the error.
 		if err != nil {
-			return err
+			return fmt.Errorf("%w: %v", ErrCaveats, err)
 		}
 
 		// Each following expiry_date should be more strict or before the previous expiry date.
 		if now.After(expiry) && expiry.After(previousExpiry) {
-			return fmt.Errorf("the %s is passed at %s", macaroon.ExpiryDateKey, expiry)
+			return fmt.Errorf("%w: the %s is passed at %s", ErrExpired, macaroon.ExpiryDateKey, expiry)
 		}
 
 		// Update previousExpiry to the current expiry.
@@ -46,16 +55,20 @@ func (e Expire) Satisfy(caveats ...macaroon.Caveat) error {
 type Capabilities struct{ Key string }
 
 func (c Capabilities) Satisfy(caveats ...macaroon.Caveat) error {
+	return c.Verify(NewVerificationContext(nil, caveats...))
+}
+
+func (c Capabilities) Verify(ctx *VerificationContext) error {
 	var previousCapabilities string
 
-	iter := macaroon.NewIterator(c.Key, caveats)
+	iter := macaroon.NewIterator(c.Key, ctx.Caveats)
 
 	for iter.HasNext() {
 		currentCapabilities := iter.Next()
 
 		// If there are previous capabilities, check that the current capabilities are a subset of them.
 		if len(previousCapabilities) > 0 && !isSubstring(previousCapabilities, currentCapabilities) {
-			return fmt.Errorf("capabilities %v are not a subset of the previous ones %v", currentCapabilities, previousCapabilities)
+			return fmt.Errorf("%w: capabilities %v are not a subset of the previous ones %v", ErrCaveats, currentCapabilities, previousCapabilities)
 		}
 
 		// Update previousCapabilities to the current capabilities.
@@ -69,18 +82,27 @@ func (c Capabilities) Satisfy(caveats ...macaroon.Caveat) error {
 type UniqueKey struct{ Key string }
 
 func (k UniqueKey) Satisfy(caveats ...macaroon.Caveat) error {
-	iter := macaroon.NewIterator(k.Key, caveats)
+	return k.Verify(NewVerificationContext(nil, caveats...))
+}
+
+func (k UniqueKey) Verify(ctx *VerificationContext) error {
+	iter := macaroon.NewIterator(k.Key, ctx.Caveats)
 	iter.Next()
 	if iter.HasNext() {
-		return fmt.Errorf("the %s should be unique", k.Key)
+		return fmt.Errorf("%w: the %s should be unique", ErrCaveats, k.Key)
 	}
 	return nil
 }
 
+// Satisfy checks the caveats at the current time.
 func (n NotBefore) Satisfy(caveats ...macaroon.Caveat) error {
-	now := time.Now()
+	return n.Verify(NewVerificationContext(nil, caveats...))
+}
+
+func (n NotBefore) Verify(ctx *VerificationContext) error {
+	now := ctx.Now()
 	var latestStart time.Time
-	iter := macaroon.NewIterator(macaroon.NotBeforeKey, caveats)
+	iter := macaroon.NewIterator(macaroon.NotBeforeKey, ctx.Caveats)
 
 	for iter.HasNext() {
 		startTimeStr := iter.Next()
@@ -89,12 +111,12 @@ func (n NotBefore) Satisfy(caveats ...macaroon.Caveat) error {
 
 		// If there is an error parsing the time, return the error
 		if err != nil {
-			return err
+			return fmt.Errorf("%w: %v", ErrCaveats, err)
 		}
 
 		// Each following not_before should be less restrictive or after the previous start date
 		if now.Before(startTime) || startTime.Before(latestStart) {
-			return fmt.Errorf("current time %s is before the not_before date %s", now, startTime)
+			return fmt.Errorf("%w: current time %s is before the not_before date %s", ErrNotYetValid, now, startTime)
 		}
 
 		// Update latestStart to the current start time
@@ -104,6 +126,61 @@ func (n NotBefore) Satisfy(caveats ...macaroon.Caveat) error {
 	return nil
 }
 
+// Revoked is a condition that rejects the tokens with a revoked value of a key, e.g. the
+// generate_id of a leaked token.
+type Revoked struct {
+	Key    string
+	Values []string
+}
+
+func (r Revoked) Verify(ctx *VerificationContext) error {
+	iter := macaroon.NewIterator(r.Key, ctx.Caveats)
+	for iter.HasNext() {
+		value := iter.Next()
+		if slices.Contains(r.Values, value) {
+			return fmt.Errorf("%w: the %s %s is revoked", ErrRevoked, r.Key, value)
+		}
+	}
+	return nil
+}
+
+// Quota is a condition that accepts each value of a key a limited number of times, e.g. the
+// generate_id of the tokens, so that each token is used at most Limit times.
+//
+// Each verification of a token counts as a use, and the uses are kept in memory. The zero
+// value, with its Key and Limit set, is ready to use.
+type Quota struct {
+	Key   string
+	Limit int
+
+	mu   sync.Mutex
+	uses map[string]int
+}
+
+// Create a new Quota.
+func NewQuota(key string, limit int) *Quota {
+	return &Quota{Key: key, Limit: limit}
+}
+
+func (q *Quota) Verify(ctx *VerificationContext) error {
+	iter := macaroon.NewIterator(q.Key, ctx.Caveats)
+	if !iter.HasNext() {
+		return nil
+	}
+	value := iter.Next()
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.uses == nil {
+		q.uses = make(map[string]int)
+	}
+	if q.uses[value] >= q.Limit {
+		return fmt.Errorf("%w: the %s %s was used %d times", ErrQuotaExhausted, q.Key, value, q.Limit)
+	}
+	q.uses[value]++
+	return nil
+}
+
 // isSubset checks if the first slice is a subset of the second slice.
 func isSubstring(s, substr string) bool {
 	if len(substr) > len(s) {
@@ -0,0 +1,21 @@
+package service
+
+import "errors"
+
+// The errors of the services and of their conditions, wrapped with the details.
+//
+// The conditions written outside of this package should wrap them too, e.g. ErrRevoked as the
+// Revoked condition or ErrQuotaExhausted as the Quota condition, so that the clients get a
+// stable code.
+var (
+	ErrInvalidServiceID = errors.New("invalid service ID")
+	ErrUnknownService   = errors.New("service not found")
+	ErrDisabled         = errors.New("the service is disabled")
+
+	ErrExpired        = errors.New("the token is expired")
+	ErrNotYetValid    = errors.New("the token is not valid yet")
+	ErrCaveats        = errors.New("the caveats are not satisfied")
+	ErrRequestDenied  = errors.New("the token does not allow the request")
+	ErrRevoked        = errors.New("the token is revoked")
+	ErrQuotaExhausted = errors.New("the quota of the token is exhausted")
+)
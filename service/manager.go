@@ -3,6 +3,9 @@ package service
 import (
 	"fmt"
 	"lsat/macaroon"
+	"sort"
+	"sync"
+	"time"
 )
 
 const (
@@ -17,11 +20,26 @@ type ServiceManager interface {
 
 	// VerifyCaveats checks the validity of the provided caveats.
 	VerifyCaveats(caveats ...macaroon.Caveat) error
+
+	// Verify checks the validity of the caveats of a verification context.
+	Verify(ctx *VerificationContext) error
+}
+
+// ServiceLister is a ServiceManager that can list its services, e.g. for the catalog.
+type ServiceLister interface {
+	// Services returns every service, sorted by ID.
+	Services() []Service
 }
 
-// The configuration of every services.
+// The configuration of every service.
+//
+// It is safe for concurrent use, so that the services can be changed while serving.
 type Config struct {
+	mu       sync.RWMutex
 	services map[ServiceID]Service
+
+	// Clock is the time of the verifications without their own, time.Now by default.
+	Clock func() time.Time
 }
 
 // Creates a new Config the provided services.
@@ -35,22 +53,55 @@ func NewConfig(services ...Service) *Config {
 
 // GetService retrieves information about a service with the provided name.
 func (c *Config) GetService(id ServiceID) (Service, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
 	service, exists := c.services[id]
 	if !exists {
-		return Service{}, fmt.Errorf("service not found: %s", id.String())
+		return Service{}, fmt.Errorf("%w: %s", ErrUnknownService, id.String())
 	}
 	return service, nil
 }
 
 // VerifyCaveats checks the validity of the provided caveats.
+//
+// The caveats restricting the requests, such as route and method, fail without a request.
 func (c *Config) VerifyCaveats(caveats ...macaroon.Caveat) error {
-	iter := macaroon.NewIterator(macaroon.ServiceKey, caveats)
+	return c.Verify(NewVerificationContext(nil, caveats...))
+}
+
+// Verify checks the validity of the caveats of a verification context.
+//
+// The BuiltinConditions are checked first, then the conditions of the services of the caveats,
+// with the Service of the context set to the service of the condition. The tokens of the
+// disabled services are verified, and those of unknown services fail with ErrUnknownService.
+func (c *Config) Verify(ctx *VerificationContext) error {
+	if ctx.Clock == nil {
+		ctx.Clock = c.Clock
+	}
+
+	ctx.Service = nil
+	for _, condition := range BuiltinConditions {
+		if err := condition.Verify(ctx); err != nil {
+			return err
+		}
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	iter := macaroon.NewIterator(macaroon.ServiceKey, ctx.Caveats)
 	for iter.HasNext() {
 		service_str := iter.Next()
-		service_id, _ := ParseServiceID(service_str)
-		service, _ := c.services[service_id]
+		service_id, err := ParseServiceID(service_str)
+		if err != nil {
+			return err
+		}
+		service, exists := c.services[service_id]
+		if !exists {
+			return fmt.Errorf("%w: %s", ErrUnknownService, service_id.String())
+		}
+		ctx.Service = &service
 		for _, condition := range service.Conditions {
-			err := condition.Satisfy(caveats...)
+			err := condition.Verify(ctx)
 			if err != nil {
 				return err
 			}
@@ -59,3 +110,43 @@ func (c *Config) VerifyCaveats(caveats ...macaroon.Caveat) error {
 
 	return nil
 }
+
+// Services returns every service, sorted by ID.
+func (c *Config) Services() []Service {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	services := make([]Service, 0, len(c.services))
+	for _, service := range c.services {
+		services = append(services, service)
+	}
+	sort.Slice(services, func(i, j int) bool {
+		return services[i].Id().String() < services[j].Id().String()
+	})
+	return services
+}
+
+// SetService adds a service, or replaces the service with the same ID.
+func (c *Config) SetService(service Service) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.services[service.Id()] = service
+}
+
+// RemoveService removes a service.
+func (c *Config) RemoveService(id ServiceID) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.services, id)
+}
+
+// Reset replaces every service.
+func (c *Config) Reset(services ...Service) {
+	serviceMap := make(map[ServiceID]Service)
+	for _, service := range services {
+		serviceMap[service.Id()] = service
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.services = serviceMap
+}
@@ -0,0 +1,108 @@
+package service
+
+import (
+	"fmt"
+	"lsat/macaroon"
+	"path"
+	"strings"
+)
+
+var errNoRequest = fmt.Errorf("%w: the caveat can only be verified with a request", ErrRequestDenied)
+
+// BuiltinConditions are checked on every token, whatever its service, so that the caveats
+// added by the holder of a token to restrict it are always enforced.
+var BuiltinConditions = []Condition{Route{}, Methods{}}
+
+// Route is a caveat limiting a token to the paths matching a pattern, and the condition
+// checking it.
+//
+// A pattern ending with /* matches every path under its prefix, e.g. /images/public/*, and
+// the other patterns are matched with path.Match. A caveat may list patterns separated by
+// commas, and a request must match one of them for each route caveat of the token.
+type Route struct{ Pattern string }
+
+func (r Route) GetKey() string {
+	return macaroon.RouteKey
+}
+
+func (r Route) GetValue() string {
+	return r.Pattern
+}
+
+// Satisfy fails if the caveats restrict the routes, as there is no request to check.
+func (r Route) Satisfy(caveats ...macaroon.Caveat) error {
+	return r.Verify(NewVerificationContext(nil, caveats...))
+}
+
+func (r Route) Verify(ctx *VerificationContext) error {
+	iter := macaroon.NewIterator(macaroon.RouteKey, ctx.Caveats)
+	for iter.HasNext() {
+		patterns := iter.Next()
+		if ctx.Request == nil {
+			return fmt.Errorf("%s: %w", macaroon.RouteKey, errNoRequest)
+		}
+		if !matchRoute(patterns, ctx.Request.URL.Path) {
+			return fmt.Errorf("%w: the path %s does not match the route %s", ErrRequestDenied, ctx.Request.URL.Path, patterns)
+		}
+	}
+	return nil
+}
+
+// Whether a path matches one of the patterns of a route caveat.
+func matchRoute(patterns string, p string) bool {
+	p = path.Clean("/" + p)
+	for _, pattern := range strings.Split(patterns, ",") {
+		pattern = strings.TrimSpace(pattern)
+		if prefix, ok := strings.CutSuffix(pattern, "/*"); ok {
+			if p == prefix || strings.HasPrefix(p, prefix+"/") {
+				return true
+			}
+			continue
+		}
+		if matched, _ := path.Match(pattern, p); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// Methods is a caveat limiting a token to some HTTP methods, and the condition checking it.
+//
+// The value of the caveat is the list of methods separated by commas, e.g. GET,HEAD.
+type Methods struct{ Methods []string }
+
+func (m Methods) GetKey() string {
+	return macaroon.MethodKey
+}
+
+func (m Methods) GetValue() string {
+	return strings.Join(m.Methods, ",")
+}
+
+// Satisfy fails if the caveats restrict the methods, as there is no request to check.
+func (m Methods) Satisfy(caveats ...macaroon.Caveat) error {
+	return m.Verify(NewVerificationContext(nil, caveats...))
+}
+
+func (m Methods) Verify(ctx *VerificationContext) error {
+	iter := macaroon.NewIterator(macaroon.MethodKey, ctx.Caveats)
+	for iter.HasNext() {
+		methods := iter.Next()
+		if ctx.Request == nil {
+			return fmt.Errorf("%s: %w", macaroon.MethodKey, errNoRequest)
+		}
+		if !containsMethod(methods, ctx.Request.Method) {
+			return fmt.Errorf("%w: the method %s is not one of %s", ErrRequestDenied, ctx.Request.Method, methods)
+		}
+	}
+	return nil
+}
+
+func containsMethod(methods string, method string) bool {
+	for _, m := range strings.Split(methods, ",") {
+		if strings.EqualFold(strings.TrimSpace(m), method) {
+			return true
+		}
+	}
+	return false
+}
@@ -2,7 +2,9 @@ package service
 
 import (
 	"fmt"
+	"lsat/amount"
 	"lsat/macaroon"
+	"lsat/rates"
 	"strconv"
 	"strings"
 )
@@ -19,13 +21,16 @@ type PostCallback func(any) error
 
 // Service represents the configuration of a service.
 type Service struct {
-	Name              string        // The name of the service.
-	Tier              Tier          // The tier or level of the service.
-	Price             uint64        // The price in milli-satoshi.
-	FirstPartyCaveats []Caveat      // The caveats of the service.
-	Conditions        []Condition   // The conditions of the service.
-	Get               TokenCallback // The callback function on GET request.
-	Post              PostCallback  // The callback function on POST request.
+	Name              string              // The name of the service.
+	Tier              Tier                // The tier or level of the service.
+	Price             amount.MilliSatoshi // The price of the service.
+	FiatPrice         rates.Price         // The price in a currency, converted at challenge time if set.
+	FirstPartyCaveats []Caveat            // The caveats of the service.
+	Conditions        []Condition         // The conditions of the service.
+	PerRequest        bool                // Whether each token pays for a single request.
+	Disabled          bool                // Whether the service stops selling tokens, the tokens sold are still verified.
+	Get               TokenCallback       // The callback function on GET request.
+	Post              PostCallback        // The callback function on POST request.
 }
 
 // Service represents the identifiers of a Service
@@ -35,7 +40,7 @@ type ServiceID struct {
 }
 
 // Create a new service configuration.
-func NewService(Name string, Price uint64) Service {
+func NewService(Name string, Price amount.MilliSatoshi) Service {
 	return Service{
 		Name:       Name,
 		Price:      Price,
@@ -47,12 +52,12 @@ func NewService(Name string, Price uint64) Service {
 func ParseServiceID(serviceStr string) (ServiceID, error) {
 	parts := strings.Split(serviceStr, ":")
 	if len(parts) != 2 {
-		return ServiceID{}, fmt.Errorf("invalid service ID format: %s", serviceStr)
+		return ServiceID{}, fmt.Errorf("%w, expected <name>:<tier>: %s", ErrInvalidServiceID, serviceStr)
 	}
 
 	tier, err := strconv.Atoi(parts[1])
 	if err != nil {
-		return ServiceID{}, fmt.Errorf("invalid tier: %s", parts[1])
+		return ServiceID{}, fmt.Errorf("%w, invalid tier: %s", ErrInvalidServiceID, parts[1])
 	}
 
 	return ServiceID{Name: parts[0], Tier: Tier(tier)}, nil
@@ -77,6 +82,9 @@ func (service *Service) Caveats() []macaroon.Caveat {
 	caveats := []macaroon.Caveat{
 		macaroon.NewCaveat(macaroon.ServiceKey, service.Id().String()),
 	}
+	if service.PerRequest {
+		caveats = append(caveats, macaroon.NewCaveat(macaroon.PerRequestKey, "true"))
+	}
 	for _, caveat := range service.FirstPartyCaveats {
 		caveats = append(caveats, ToCaveat(caveat))
 	}
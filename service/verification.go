@@ -0,0 +1,79 @@
+package service
+
+import (
+	"lsat/macaroon"
+	"net"
+	"net/http"
+	"time"
+)
+
+// VerificationContext is what the conditions verify: the caveats of a token, with the request
+// they are presented with.
+//
+// A context is created for each verification and shared by all its conditions, so that a
+// condition can store values for the following ones.
+type VerificationContext struct {
+	Caveats []macaroon.Caveat
+	Request *http.Request    // The request, nil when the token is verified without one, e.g. on gRPC.
+	Service *Service         // The service of the condition, nil for the BuiltinConditions.
+	Clock   func() time.Time // Defaults to time.Now.
+
+	values map[any]any
+}
+
+// Create a new VerificationContext, the request can be nil.
+func NewVerificationContext(req *http.Request, caveats ...macaroon.Caveat) *VerificationContext {
+	return &VerificationContext{Caveats: caveats, Request: req}
+}
+
+// Now returns the time of the verification.
+func (v *VerificationContext) Now() time.Time {
+	if v.Clock == nil {
+		return time.Now()
+	}
+	return v.Clock()
+}
+
+// ClientIP returns the IP address of the client of the request, nil without a request.
+//
+// It is the address of the connection, so behind a reverse proxy it is the one of the proxy.
+func (v *VerificationContext) ClientIP() net.IP {
+	if v.Request == nil {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(v.Request.RemoteAddr)
+	if err != nil {
+		host = v.Request.RemoteAddr
+	}
+	return net.ParseIP(host)
+}
+
+// Value returns the value stored for a key during the verification, nil if there is none.
+func (v *VerificationContext) Value(key any) any {
+	return v.values[key]
+}
+
+// SetValue stores a value for the rest of the verification.
+func (v *VerificationContext) SetValue(key any, value any) {
+	if v.values == nil {
+		v.values = make(map[any]any)
+	}
+	v.values[key] = value
+}
+
+// CaveatCondition is a condition checked on the caveats only, as Condition was before the
+// VerificationContext. Adapt turns it into a Condition.
+type CaveatCondition interface {
+	Satisfy(...macaroon.Caveat) error
+}
+
+// Adapt a condition checked on the caveats only.
+func Adapt(condition CaveatCondition) Condition {
+	return adapted{condition}
+}
+
+type adapted struct{ CaveatCondition }
+
+func (a adapted) Verify(ctx *VerificationContext) error {
+	return a.Satisfy(ctx.Caveats...)
+}
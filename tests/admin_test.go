@@ -0,0 +1,166 @@
+package tests
+
+import (
+	"encoding/json"
+	"lsat/admin"
+	"lsat/amount"
+	"lsat/auth"
+	"lsat/config"
+	"lsat/mock"
+	"lsat/service"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+const adminToken = "admin-secret"
+
+// newAdmin serves the admin API of a store seeded with the image service.
+func newAdmin(t *testing.T, path string) (*gin.Engine, *service.Config) {
+	gin.SetMode(gin.TestMode)
+
+	store, err := admin.Open(path, []config.ServiceFile{{Name: "image", Price: "100sat"}})
+	assert.Nil(t, err, err)
+
+	manager := service.NewConfig()
+	api, err := admin.New(store, manager, adminToken)
+	assert.Nil(t, err, err)
+
+	router := gin.New()
+	api.Register(router.Group("/admin"))
+	return router, manager
+}
+
+// adminRequest sends a request to the admin API and decodes its response.
+func adminRequest(router http.Handler, method, path, body string, response any) int {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+adminToken)
+	req.Header.Set("Content-Type", "application/json")
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, req)
+	if response != nil {
+		json.Unmarshal(recorder.Body.Bytes(), response)
+	}
+	return recorder.Code
+}
+
+func TestAdminAuthentication(t *testing.T) {
+	router, _ := newAdmin(t, filepath.Join(t.TempDir(), "services.json"))
+
+	for _, header := range []string{"", "Bearer wrong", adminToken} {
+		req := httptest.NewRequest(http.MethodGet, "/admin/services", nil)
+		req.Header.Set("Authorization", header)
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, req)
+		assert.Equal(t, http.StatusUnauthorized, recorder.Code, header)
+	}
+}
+
+func TestAdminManagesServices(t *testing.T) {
+	router, manager := newAdmin(t, filepath.Join(t.TempDir(), "services.json"))
+	image := service.NewId("image", 0)
+
+	// The seed is the first version.
+	var list struct {
+		Version  int           `json:"version"`
+		Services []admin.Entry `json:"services"`
+	}
+	assert.Equal(t, http.StatusOK, adminRequest(router, http.MethodGet, "/admin/services", "", &list))
+	assert.Equal(t, 1, list.Version)
+	assert.Len(t, list.Services, 1)
+
+	// Create a new tier.
+	var summary admin.Summary
+	assert.Equal(t, http.StatusCreated, adminRequest(router, http.MethodPut, "/admin/services/image:1", `{"price": "500sat", "caveats": [{"type": "expire", "delay": "2h"}]}`, &summary))
+	assert.Equal(t, 2, summary.Number)
+	premium, err := manager.GetService(service.NewId("image", 1))
+	assert.Nil(t, err, err)
+	assert.Equal(t, 500*amount.Satoshi, premium.Price)
+
+	// Update a price.
+	assert.Equal(t, http.StatusOK, adminRequest(router, http.MethodPut, "/admin/services/image:0", `{"price": "200sat"}`, nil))
+	updated, _ := manager.GetService(image)
+	assert.Equal(t, 200*amount.Satoshi, updated.Price)
+
+	// Invalid changes are not recorded.
+	assert.Equal(t, http.StatusBadRequest, adminRequest(router, http.MethodPut, "/admin/services/image:0", `{"price": "200"}`, nil))
+	assert.Equal(t, http.StatusBadRequest, adminRequest(router, http.MethodPut, "/admin/services/image:0", `{"name": "video", "price": "1sat"}`, nil))
+	assert.Equal(t, http.StatusNotFound, adminRequest(router, http.MethodDelete, "/admin/services/video:0", "", nil))
+
+	// A disabled service does not sell tokens, but is kept to verify those sold.
+	assert.Equal(t, http.StatusOK, adminRequest(router, http.MethodPost, "/admin/services/image:1/disable", "", nil))
+	premium, err = manager.GetService(service.NewId("image", 1))
+	assert.Nil(t, err, err)
+	assert.True(t, premium.Disabled)
+	var entry admin.Entry
+	assert.Equal(t, http.StatusOK, adminRequest(router, http.MethodGet, "/admin/services/image:1", "", &entry))
+	assert.True(t, entry.Disabled)
+
+	assert.Equal(t, http.StatusOK, adminRequest(router, http.MethodPost, "/admin/services/image:1/enable", "", nil))
+	assert.Equal(t, http.StatusOK, adminRequest(router, http.MethodDelete, "/admin/services/image:1", "", &summary))
+	assert.Equal(t, 6, summary.Number)
+	assert.Len(t, manager.Services(), 1)
+}
+
+func TestAdminKeepsVerifyingTokens(t *testing.T) {
+	router, manager := newAdmin(t, filepath.Join(t.TempDir(), "services.json"))
+	premium := service.NewId("image", 1)
+	minter := auth.NewMinter(manager, secretStore, mock.NewChallenger())
+
+	now := time.Now()
+	manager.Clock = func() time.Time { return now }
+	assert.Equal(t, http.StatusCreated, adminRequest(router, http.MethodPut, "/admin/services/image:1",
+		`{"price": "5sat", "caveats": [{"type": "expire", "delay": "1h"}], "conditions": [{"type": "expire"}]}`, nil))
+	token := paidToken(t, &minter, premium)
+
+	// The tokens of a disabled service are still verified with its conditions.
+	assert.Equal(t, http.StatusOK, adminRequest(router, http.MethodPost, "/admin/services/image:1/disable", "", nil))
+	_, err := minter.MintToken(secretStore.NewUser(), premium)
+	assert.ErrorIs(t, err, service.ErrDisabled)
+	assert.Nil(t, minter.AuthToken(&token))
+
+	now = now.Add(2 * time.Hour)
+	assert.ErrorIs(t, minter.AuthToken(&token), service.ErrExpired)
+
+	// The tokens of a deleted service are rejected.
+	now = now.Add(-2 * time.Hour)
+	assert.Equal(t, http.StatusOK, adminRequest(router, http.MethodDelete, "/admin/services/image:1", "", nil))
+	assert.ErrorIs(t, minter.AuthToken(&token), service.ErrUnknownService)
+}
+
+func TestAdminRollback(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "services.json")
+	router, manager := newAdmin(t, path)
+	image := service.NewId("image", 0)
+
+	assert.Equal(t, http.StatusOK, adminRequest(router, http.MethodPut, "/admin/services/image:0", `{"price": "100000sat"}`, nil))
+
+	var versions []admin.Summary
+	assert.Equal(t, http.StatusOK, adminRequest(router, http.MethodGet, "/admin/versions", "", &versions))
+	assert.Len(t, versions, 2)
+	assert.Equal(t, "update image:0", versions[1].Change)
+
+	var summary admin.Summary
+	assert.Equal(t, http.StatusOK, adminRequest(router, http.MethodPost, "/admin/versions/1/rollback", "", &summary))
+	assert.Equal(t, 3, summary.Number)
+	assert.Equal(t, "rollback to 1", summary.Change)
+	restored, _ := manager.GetService(image)
+	assert.Equal(t, 100*amount.Satoshi, restored.Price)
+
+	assert.Equal(t, http.StatusNotFound, adminRequest(router, http.MethodPost, "/admin/versions/9/rollback", "", nil))
+
+	// The versions are persisted, and the seed is ignored once the file exists.
+	store, err := admin.Open(path, nil)
+	assert.Nil(t, err, err)
+	assert.Equal(t, 3, store.Current().Number)
+	assert.Equal(t, "100sat", store.Current().Services[0].Price)
+	previous, err := store.Version(2)
+	assert.Nil(t, err, err)
+	assert.Equal(t, "100000sat", previous.Services[0].Price)
+}
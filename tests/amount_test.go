@@ -0,0 +1,88 @@
+package tests
+
+import (
+	"context"
+	"lsat/amount"
+	"lsat/challenge"
+	"lsat/mock"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseAmount(t *testing.T) {
+	cases := map[string]amount.MilliSatoshi{
+		"100sat":    100 * amount.Satoshi,
+		"2500msat":  2500 * amount.MilliSat,
+		"1 sats":    amount.Satoshi,
+		"1.5sat":    1500 * amount.MilliSat,
+		"0.001 BTC": 100_000 * amount.Satoshi,
+		"0msat":     0,
+	}
+
+	for input, expected := range cases {
+		parsed, err := amount.Parse(input)
+		assert.Nil(t, err, err)
+		assert.Equal(t, expected, parsed, input)
+	}
+}
+
+func TestParseAmountInvalid(t *testing.T) {
+	for _, input := range []string{"100", "sat", "1.5msat", "100 usd", "-1sat", "1..2sat"} {
+		_, err := amount.Parse(input)
+		assert.NotNil(t, err, input)
+	}
+}
+
+func TestAmountString(t *testing.T) {
+	for _, value := range []amount.MilliSatoshi{0, 1, 1500, 2000, 12_345_678} {
+		parsed, err := amount.Parse(value.String())
+		assert.Nil(t, err, err)
+		assert.Equal(t, value, parsed)
+	}
+
+	assert.Equal(t, "2sat", (2 * amount.Satoshi).String())
+	assert.Equal(t, "2500msat", amount.MilliSatoshi(2500).String())
+}
+
+func TestAmountRounding(t *testing.T) {
+	value := amount.MilliSatoshi(1500)
+
+	sats, _ := value.ToSatoshis(amount.RoundUp)
+	assert.Equal(t, uint64(2), sats)
+
+	sats, _ = value.ToSatoshis(amount.RoundDown)
+	assert.Equal(t, uint64(1), sats)
+
+	sats, _ = value.ToSatoshis(amount.RoundNearest)
+	assert.Equal(t, uint64(2), sats)
+
+	sats, _ = amount.MilliSatoshi(1499).ToSatoshis(amount.RoundNearest)
+	assert.Equal(t, uint64(1), sats)
+
+	_, err := value.ToSatoshis(amount.RoundExact)
+	assert.ErrorIs(t, err, amount.ErrNotWholeSatoshi)
+
+	sats, err = amount.MilliSatoshi(2000).ToSatoshis(amount.RoundExact)
+	assert.Nil(t, err, err)
+	assert.Equal(t, uint64(2), sats)
+}
+
+func TestMockChargesExactAmount(t *testing.T) {
+	payer := mock.NewLightningNode(2500 * amount.MilliSat)
+	payee := mock.NewLightningNode(0)
+
+	invoice, err := payee.CreateInvoice(context.Background(), challenge.CreateInvoiceRequest{Amount: 1001 * amount.MilliSat})
+	assert.Nil(t, err, err)
+	assert.Equal(t, 1001*amount.MilliSat, invoice.Amount)
+
+	_, err = payer.PayInvoice(context.Background(), challenge.PayInvoiceRequest{Invoice: invoice.Invoice})
+	assert.Nil(t, err, err)
+	assert.Equal(t, 1499*amount.MilliSat, payer.Balance)
+	assert.Equal(t, 1001*amount.MilliSat, payee.Balance)
+
+	// A second payment would overdraw the balance by a single millisatoshi.
+	invoice, _ = payee.CreateInvoice(context.Background(), challenge.CreateInvoiceRequest{Amount: 1500 * amount.MilliSat})
+	_, err = payer.PayInvoice(context.Background(), challenge.PayInvoiceRequest{Invoice: invoice.Invoice})
+	assert.ErrorIs(t, err, mock.ErrInsufficientFunds)
+}
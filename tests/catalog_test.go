@@ -0,0 +1,122 @@
+package tests
+
+import (
+	"encoding/json"
+	"lsat/auth"
+	"lsat/config"
+	"lsat/mock"
+	"lsat/problem"
+	"lsat/proxy"
+	"lsat/service"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCatalog(t *testing.T) {
+	data := `services:
+  - name: image
+    tier: 1
+    price: 2sat
+    caveats:
+      - { type: expire, delay: 1h }
+      - { type: static, key: format, value: png }
+    conditions:
+      - { type: expire }
+      - { type: capabilities, key: resolution }
+  - name: image
+    price: 1sat
+    per_request: true
+  - name: audio
+    price: 5sat
+lightning:
+  backend: mock
+`
+	cfg, err := config.Parse([]byte(data), config.YAML)
+	assert.Nil(t, err, err)
+
+	catalog := service.NewCatalog(cfg.Services...)
+	assert.Len(t, catalog.Services, 2)
+	assert.Equal(t, "audio", catalog.Services[0].Name)
+
+	image := catalog.Services[1]
+	assert.Len(t, image.Tiers, 2)
+	assert.Equal(t, "image:0", image.Tiers[0].ID)
+	assert.True(t, image.Tiers[0].PerRequest)
+	assert.Equal(t, service.CatalogPrice{Amount: 1000, Unit: "msat"}, image.Tiers[0].Price)
+
+	premium := image.Tiers[1]
+	assert.Equal(t, []service.CaveatDescription{
+		{Type: "expire", Key: "expiry_date", Delay: "1h0m0s"},
+		{Type: "static", Key: "format", Value: "png"},
+	}, premium.Caveats)
+	assert.Equal(t, []service.ConditionDescription{{Type: "expire"}, {Type: "capabilities", Key: "resolution"}}, premium.Conditions)
+
+	// The schema accepts the built-in caveats, and those of the conditions.
+	caveats := premium.Attenuation["items"].(map[string]any)["oneOf"].([]any)
+	var keys []any
+	for _, caveat := range caveats {
+		keys = append(keys, caveat.(map[string]any)["properties"].(map[string]any)["key"].(map[string]any)["const"])
+	}
+	assert.Equal(t, []any{"route", "method", "expiry_date", "resolution"}, keys)
+
+	// The disabled services are not sold.
+	disabled := service.NewService("video", servicePrice)
+	disabled.Disabled = true
+	assert.Empty(t, service.NewCatalog(disabled).Services)
+}
+
+func TestProxyCatalog(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	config := service.NewConfig(testService)
+	minter := auth.NewMinter(config, secretStore, mock.NewChallenger())
+	server := proxy.L402ProxyServer{Minter: &minter}
+	router := server.Router()
+
+	get := func(etag string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/services", nil)
+		if etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		return resp
+	}
+
+	resp := get("")
+	assert.Equal(t, http.StatusOK, resp.Code)
+	var catalog service.Catalog
+	assert.Nil(t, json.NewDecoder(resp.Body).Decode(&catalog))
+	assert.Equal(t, serviceName, catalog.Services[0].Name)
+
+	etag := resp.Header().Get("ETag")
+	assert.NotEmpty(t, etag)
+	assert.Equal(t, http.StatusNotModified, get(etag).Code)
+	assert.Equal(t, http.StatusNotModified, get(`"other", W/`+etag).Code)
+
+	// The ETag changes with the services.
+	config.SetService(service.NewService("other", servicePrice))
+	resp = get(etag)
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.NotEqual(t, etag, resp.Header().Get("ETag"))
+}
+
+// unlistedManager is a ServiceManager that cannot list its services.
+type unlistedManager struct {
+	service.ServiceManager
+}
+
+func TestProxyCatalogWithoutLister(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	minter := auth.NewMinter(unlistedManager{service.NewConfig(testService)}, secretStore, mock.NewChallenger())
+	server := proxy.L402ProxyServer{Minter: &minter}
+
+	// The catalog is not implemented by a manager that cannot list its services.
+	resp := httptest.NewRecorder()
+	server.Router().ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/services", nil))
+	assert.Equal(t, http.StatusNotImplemented, resp.Code)
+	assert.Equal(t, problem.CodeNotImplemented, readProblem(t, resp).Code)
+}
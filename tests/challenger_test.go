@@ -1,18 +1,20 @@
 package tests
 
 import (
+	"context"
+	"lsat/amount"
 	"lsat/mock"
 	"testing"
 )
 
-const defaultPrice uint64 = 1000
+const defaultPrice = 1000 * amount.MilliSat
 
 func TestPreimage(t *testing.T) {
 	// Should be replaced by LndClient
 	var challenger = mock.NewChallenger()
 
-	resultA, _ := challenger.Challenge(defaultPrice)
-	resultB, _ := challenger.Challenge(defaultPrice)
+	resultA, _ := challenger.Challenge(context.Background(), defaultPrice)
+	resultB, _ := challenger.Challenge(context.Background(), defaultPrice)
 
 	t.Log(resultA.PaymentHash)
 	t.Log(resultB.PaymentHash)
@@ -26,7 +28,7 @@ func TestChallenge(t *testing.T) {
 	// Should be replaced by LndClient
 	var challenger = mock.NewChallenger()
 
-	result, err := challenger.Challenge(0)
+	result, err := challenger.Challenge(context.Background(), 0)
 
 	t.Log(result.PaymentHash)
 
@@ -0,0 +1,270 @@
+package tests
+
+import (
+	"errors"
+	"lsat/amount"
+	"lsat/config"
+	"lsat/macaroon"
+	"lsat/rates"
+	"lsat/service"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const yamlConfig = `services:
+  - name: image
+    tier: 1
+    price: 5sat
+    caveats:
+      - { type: expire, delay: 1h }
+      - { type: static, key: capabilities, value: read }
+    conditions:
+      - { type: expire }
+      - type: capabilities
+        key: capabilities
+routes:
+  - service: "image:1"
+    path: /images
+    upstream: http://localhost:3000/v1
+    timeout: 5s
+    headers:
+      X-Api-Key: backend-secret
+lightning:
+  backend: mock
+secrets:
+  root: ${L402_TEST_ROOT}
+`
+
+const tomlConfig = `[[services]]
+name = "image"
+tier = 1
+price = "5sat"
+conditions = [{ type = "expire" }, { type = "capabilities", key = "capabilities" }]
+
+[[services.caveats]]
+type = "expire"
+delay = "1h"
+
+[[services.caveats]]
+type = "static"
+key = "capabilities"
+value = "read"
+
+[[routes]]
+service = "image:1"
+path = "/images"
+upstream = "http://localhost:3000/v1"
+timeout = "5s"
+headers = { X-Api-Key = "backend-secret" }
+
+[lightning]
+backend = "mock"
+
+[secrets]
+root = "${L402_TEST_ROOT}"
+`
+
+func TestParseConfig(t *testing.T) {
+	root := secretStore.GetRoot()
+	t.Setenv("L402_TEST_ROOT", root.String())
+
+	for format, data := range map[config.Format]string{config.YAML: yamlConfig, config.TOML: tomlConfig} {
+		parsed, err := config.Parse([]byte(data), format)
+		assert.Nil(t, err, err)
+		if err != nil {
+			continue
+		}
+
+		assert.Len(t, parsed.Services, 1, format)
+		image := parsed.Services[0]
+		assert.Equal(t, service.NewId("image", 1), image.Id(), format)
+		assert.Equal(t, 5*amount.Satoshi, image.Price, format)
+		assert.Equal(t, []service.Caveat{service.Expire{Delay: time.Hour}, macaroon.NewCaveat("capabilities", "read")}, image.FirstPartyCaveats, format)
+		assert.Equal(t, []service.Condition{service.Expire{}, service.Capabilities{Key: "capabilities"}}, image.Conditions, format)
+
+		assert.Len(t, parsed.Routes, 1, format)
+		route := parsed.Routes[0]
+		assert.Equal(t, "/images", route.PathPrefix, format)
+		assert.Equal(t, "localhost:3000", route.Upstream.Host, format)
+		assert.Equal(t, 5*time.Second, route.Timeout, format)
+		assert.Equal(t, "backend-secret", route.Headers.Get("X-Api-Key"), format)
+
+		// The root secret is the one of the environment, so the tokens of secretStore are valid.
+		minter := parsed.Minter()
+		token := paidToken(t, &minter, image.Id())
+		assert.Nil(t, minter.AuthToken(&token), format)
+	}
+}
+
+func TestConfigErrorLines(t *testing.T) {
+	data := `services:
+  - name: image
+    price: 100
+    caveats:
+      - { type: expire }
+routes:
+  - { service: "video:0", path: /videos, upstream: "http://localhost" }
+lightning:
+  backend: lnd
+`
+	_, err := config.Parse([]byte(data), config.YAML)
+
+	var errs config.Errors
+	assert.True(t, errors.As(err, &errs))
+	lines := map[string]int{}
+	for _, e := range errs {
+		lines[e.Path] = e.Line
+	}
+	assert.Equal(t, map[string]int{
+		"services[0].price":            3,
+		"services[0].caveats[0].delay": 5,
+		"routes[0].service":            7,
+		"lightning.backend":            9,
+	}, lines)
+	assert.Contains(t, err.Error(), "line 3: services[0].price: invalid amount")
+}
+
+func TestConfigTOMLErrorLines(t *testing.T) {
+	data := `[[services]]
+name = "image"
+price = "100sat"
+
+[[services]]
+name = "video"
+price = "1btc"
+
+[[services.conditions]]
+type = "unique"
+
+[lightning]
+backend = "mock"
+`
+	_, err := config.Parse([]byte(data), config.TOML)
+
+	var e *config.Error
+	assert.True(t, errors.As(err, &e))
+	assert.Equal(t, "services[1].conditions[0].key", e.Path)
+	assert.Equal(t, 9, e.Line)
+}
+
+func TestConfigUnknownFields(t *testing.T) {
+	_, err := config.Parse([]byte("lightning:\n  backend: mock\n  colour: blue\n"), config.YAML)
+	var e *config.Error
+	assert.True(t, errors.As(err, &e))
+	assert.Equal(t, 3, e.Line)
+
+	_, err = config.Parse([]byte("[lightning]\nbackend = \"mock\"\ncolour = \"blue\"\n"), config.TOML)
+	assert.True(t, errors.As(err, &e))
+	assert.Equal(t, 3, e.Line)
+	assert.Equal(t, "lightning.colour", e.Path)
+
+	_, err = config.Parse([]byte("[lightning\n"), config.TOML)
+	assert.True(t, errors.As(err, &e))
+	assert.Equal(t, 1, e.Line)
+}
+
+func TestLoadConfig(t *testing.T) {
+	t.Setenv("L402_METRICS_TOKEN", metricsToken)
+	for _, name := range []string{"l402.yaml", "l402.toml"} {
+		loaded, err := config.Load(filepath.Join("..", "examples", "config", name))
+		assert.Nil(t, err, err)
+		assert.Len(t, loaded.Routes, 1, name)
+	}
+
+	path := filepath.Join(t.TempDir(), "l402.yml")
+	assert.Nil(t, os.WriteFile(path, []byte("lightning:\n  backend: lnd\n"), 0600))
+	_, err := config.Load(path)
+	assert.ErrorContains(t, err, path+":2: lightning.backend")
+
+	_, err = config.Load("l402.json")
+	assert.ErrorIs(t, err, config.ErrUnknownFormat)
+
+	// The metrics are not served without a token.
+	_, err = config.Parse([]byte("lightning:\n  backend: mock\nmetrics:\n  enabled: true\n"), config.YAML)
+	assert.ErrorContains(t, err, "metrics.token")
+}
+
+func TestReloadKeepsReplayStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "l402.yaml")
+	assert.Nil(t, os.WriteFile(path, []byte("lightning:\n  backend: mock\n"), 0600))
+	previous, err := config.Load(path)
+	assert.Nil(t, err, err)
+
+	// The tokens spent stay spent across reloads, unless the store changes.
+	reloaded, err := config.Reload(path, previous)
+	assert.Nil(t, err, err)
+	assert.Same(t, previous.Replay, reloaded.Replay)
+
+	replayFile := filepath.Join(t.TempDir(), "spent")
+	assert.Nil(t, os.WriteFile(path, []byte("lightning:\n  backend: mock\nreplay:\n  file: "+replayFile+"\n"), 0600))
+	reloaded, err = config.Reload(path, previous)
+	assert.Nil(t, err, err)
+	assert.NotSame(t, previous.Replay, reloaded.Replay)
+}
+
+func TestReloadKeepsSecrets(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "l402.yaml")
+	assert.Nil(t, os.WriteFile(path, []byte("services:\n  - { name: image, price: 1sat }\nlightning:\n  backend: mock\n"), 0600))
+	previous, err := config.Load(path)
+	assert.Nil(t, err, err)
+	minter := previous.Minter()
+	token := paidToken(t, &minter, service.NewId("image", 0))
+
+	// The tokens sold before the reload are still valid, with generated secrets.
+	reloaded, err := config.Reload(path, previous)
+	assert.Nil(t, err, err)
+	minter = reloaded.Minter()
+	assert.Nil(t, minter.AuthToken(&token))
+}
+
+func TestConfigFiatPrices(t *testing.T) {
+	ratesFile := filepath.Join(t.TempDir(), "rates.json")
+	assert.Nil(t, os.WriteFile(ratesFile, []byte(`{"USD": 50000}`), 0600))
+
+	data := `services:
+  - { name: image, fiat_price: 1.00 USD }
+lightning:
+  backend: mock
+rates:
+  file: ` + ratesFile + `
+  ttl: 5m
+`
+	parsed, err := config.Parse([]byte(data), config.YAML)
+	assert.Nil(t, err, err)
+	if err != nil {
+		return
+	}
+	assert.Equal(t, rates.Price{Amount: 100, Currency: rates.USD}, parsed.Services[0].FiatPrice)
+
+	// The price is converted with the rates of the configuration.
+	minter := parsed.Minter()
+	preToken, err := minter.MintToken(secretStore.NewUser(), parsed.Services[0].Id())
+	assert.Nil(t, err, err)
+	assert.Equal(t, 2000*amount.Satoshi, preToken.InvoiceResponse.Amount)
+}
+
+func TestConfigFiatPriceErrors(t *testing.T) {
+	for data, path := range map[string]string{
+		"services:\n  - { name: image, fiat_price: 1 USD }\n":                                 "services[0].fiat_price",
+		"services:\n  - { name: image, price: 1sat, fiat_price: 1 USD }\nrates:\n  file: x\n": "services[0]",
+		"services:\n  - { name: image, fiat_price: 0.001 USD }\n":                             "services[0].fiat_price",
+		"services:\n  - { name: image, fiat_price: 1 dollar }\n":                              "services[0].fiat_price",
+		"rates:\n  url: https://api.coinbase.com/v2/prices/BTC-{currency}/spot\n":             "rates.field",
+		"rates:\n  url: http://localhost\n  field: price\n  file: rates.json\n":               "rates",
+		"rates:\n  url: http://localhost\n  field: price\n  max_age: -1m\n":                   "rates.max_age",
+	} {
+		_, err := config.Parse([]byte("lightning:\n  backend: mock\n"+data), config.YAML)
+		var errs config.Errors
+		if assert.True(t, errors.As(err, &errs), data) {
+			paths := make([]string, len(errs))
+			for i, e := range errs {
+				paths[i] = e.Path
+			}
+			assert.Contains(t, paths, path, data)
+		}
+	}
+}
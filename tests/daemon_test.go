@@ -0,0 +1,187 @@
+package tests
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"lsat/auth"
+	"lsat/challenge"
+	"lsat/config"
+	"lsat/daemon"
+	"lsat/mock"
+	"lsat/service"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lightningnetwork/lnd/lntypes"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeDaemonConfig writes a configuration routing a path to an upstream, with the root of secretStore.
+func writeDaemonConfig(t *testing.T, path, prefix, upstream string) {
+	root := secretStore.GetRoot()
+	data := fmt.Sprintf(`services:
+  - { name: image, price: 1sat }
+routes:
+  - { service: "image:0", path: %s, upstream: %q }
+lightning:
+  backend: mock
+secrets:
+  root: %s
+`, prefix, upstream, root)
+	assert.Nil(t, os.WriteFile(path, []byte(data), 0600))
+}
+
+// startDaemon serves a daemon until the end of the test.
+func startDaemon(t *testing.T, d *daemon.Daemon) (string, context.CancelFunc, <-chan error) {
+	gin.SetMode(gin.TestMode)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- d.Serve(ctx, listener) }()
+	t.Cleanup(cancel)
+
+	return "http://" + listener.Addr().String(), cancel, done
+}
+
+// getStatus returns the status of a GET request.
+func getStatus(t *testing.T, url string) int {
+	resp, err := http.Get(url)
+	assert.Nil(t, err, err)
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestDaemonReload(t *testing.T) {
+	upstream := httptest.NewServer(http.NotFoundHandler())
+	defer upstream.Close()
+
+	path := filepath.Join(t.TempDir(), "l402.yaml")
+	writeDaemonConfig(t, path, "/api", upstream.URL)
+
+	d := daemon.New(path)
+	url, _, _ := startDaemon(t, d)
+
+	// The route of the configuration is challenged.
+	assert.Equal(t, http.StatusPaymentRequired, getStatus(t, url+"/api/images"))
+
+	// An invalid configuration is not loaded.
+	assert.Nil(t, os.WriteFile(path, []byte("lightning:\n  backend: lnd\n"), 0600))
+	assert.ErrorContains(t, d.Load(), path+":2: lightning.backend")
+	assert.Equal(t, http.StatusPaymentRequired, getStatus(t, url+"/api/images"))
+
+	// The new routes are served after the reload.
+	writeDaemonConfig(t, path, "/v2", upstream.URL)
+	assert.Nil(t, d.Load())
+	assert.Equal(t, http.StatusNotFound, getStatus(t, url+"/api/images"))
+	assert.Equal(t, http.StatusPaymentRequired, getStatus(t, url+"/v2/images"))
+}
+
+func TestDaemonReloadKeepsState(t *testing.T) {
+	fake := mock.NewFakePhoenixd(phoenixPassword, 0)
+	defer fake.Close()
+	phoenix := httptest.NewServer(fake)
+	defer phoenix.Close()
+
+	path := filepath.Join(t.TempDir(), "l402.yaml")
+	write := func(price string) {
+		data := fmt.Sprintf(`services:
+  - { name: image, price: %s }
+lightning:
+  backend: phoenixd
+  url: %s
+  password: %s
+  webhook_secret: %s
+metrics:
+  enabled: true
+  token: %s
+`, price, phoenix.URL, phoenixPassword, webhookSecret, metricsToken)
+		assert.Nil(t, os.WriteFile(path, []byte(data), 0600))
+	}
+	write("1sat")
+
+	d := daemon.New(path)
+	url, _, _ := startDaemon(t, d)
+
+	req, _ := http.NewRequest(http.MethodPut, url+"/service/image:0", nil)
+	resp, err := http.DefaultClient.Do(req)
+	assert.Nil(t, err, err)
+	resp.Body.Close()
+	challenges, err := auth.ParseChallenges(resp.Header.Values("WWW-Authenticate")...)
+	assert.Nil(t, err, err)
+	invoice, err := challenge.DecodeInvoice(challenges[0].Invoice, mock.Network)
+	assert.Nil(t, err, err)
+	hash := lntypes.Hash(*invoice.PaymentHash)
+
+	write("2sat")
+	assert.Nil(t, d.Load())
+
+	// The challenge issued before the reload is still tracked, and counted.
+	assert.Equal(t, http.StatusOK, getStatus(t, url+"/challenge/"+hash.String()))
+	assert.Contains(t, scrape(t, url), `l402_challenges_total{service="image:0"} 1`)
+}
+
+func TestDaemonGracefulShutdown(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		w.Write([]byte("done"))
+	}))
+	defer upstream.Close()
+
+	path := filepath.Join(t.TempDir(), "l402.yaml")
+	writeDaemonConfig(t, path, "/api", upstream.URL)
+
+	d := daemon.New(path)
+	d.ShutdownTimeout = 5 * time.Second
+	url, stop, done := startDaemon(t, d)
+
+	// A paid request in flight when the shutdown starts.
+	cfg, err := config.Load(path)
+	assert.Nil(t, err, err)
+	minter := cfg.Minter()
+	token := paidToken(t, &minter, service.NewId("image", 0))
+
+	req, _ := http.NewRequest(http.MethodGet, url+"/api/slow", nil)
+	req.Header.Set("Authorization", auth.NewAuthorization(token).String())
+
+	body := make(chan string, 1)
+	go func() {
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			body <- err.Error()
+			return
+		}
+		defer resp.Body.Close()
+		content, _ := io.ReadAll(resp.Body)
+		body <- string(content)
+	}()
+
+	<-started
+	stop()
+
+	// New connections are refused while the request in flight completes.
+	require.Eventually(t, func() bool {
+		resp, err := http.Get(url + "/api/images")
+		if err == nil {
+			resp.Body.Close()
+		}
+		return err != nil
+	}, 5*time.Second, 5*time.Millisecond)
+
+	close(release)
+	assert.Equal(t, "done", <-body)
+	assert.Nil(t, <-done)
+}
@@ -0,0 +1,150 @@
+package tests
+
+import (
+	"context"
+	"fmt"
+	"lsat/amount"
+	"lsat/auth"
+	"lsat/challenge"
+	"lsat/mock"
+	"lsat/phoenixd"
+	"lsat/phoenixd/webhook"
+	"lsat/proxy"
+	"lsat/service"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lightningnetwork/lnd/lntypes"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newFakePhoenixd serves a fake phoenixd and returns a client connected to it.
+func newFakePhoenixd(t *testing.T, balance amount.MilliSatoshi) (*mock.FakePhoenixd, *phoenixd.PhoenixClient) {
+	fake := mock.NewFakePhoenixd(phoenixPassword, balance)
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	t.Cleanup(fake.Close)
+
+	return fake, phoenixd.NewPhoenixClient(server.URL, phoenixPassword)
+}
+
+func TestFakePhoenixdPayEachOther(t *testing.T) {
+	fakeAlice, alice := newFakePhoenixd(t, 0)
+	_, bob := newFakePhoenixd(t, 100*amount.Satoshi)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events := alice.Events(ctx)
+
+	invoice, err := alice.CreateInvoice(context.Background(), &phoenixd.CreateInvoiceRequest{Description: "tea & cake", AmountSat: 30, ExternalId: "order-1"})
+	assert.Nil(t, err, err)
+
+	decoded, err := bob.DecodeInvoice(context.Background(), invoice.Serialized)
+	assert.Nil(t, err, err)
+	assert.Equal(t, "tea & cake", decoded.Description)
+	assert.Equal(t, 30*amount.Satoshi, decoded.Amount)
+
+	// Wait for the websocket to be connected before paying.
+	require.Eventually(t, func() bool { return fakeAlice.Subscribers() == 1 }, 5*time.Second, 5*time.Millisecond)
+
+	payment, err := bob.PayInvoice(context.Background(), &phoenixd.PayInvoiceRequest{Invoice: invoice.Serialized})
+	assert.Nil(t, err, err)
+	preimage, err := lntypes.MakePreimageFromStr(payment.PaymentPreimage)
+	assert.Nil(t, err, err)
+	assert.Equal(t, invoice.PaymentHash, preimage.Hash().String())
+
+	incoming, err := alice.GetIncomingPayment(context.Background(), invoice.PaymentHash)
+	assert.Nil(t, err, err)
+	assert.True(t, incoming.IsPaid)
+	assert.Equal(t, uint64(30), incoming.ReceivedSat)
+
+	listed, err := alice.ListIncomingPayments(context.Background(), &phoenixd.ListIncomingPaymentsRequest{ExternalId: "order-1"})
+	assert.Nil(t, err, err)
+	assert.Len(t, listed, 1)
+
+	outgoing, err := bob.GetOutgoingPaymentByHash(context.Background(), invoice.PaymentHash)
+	assert.Nil(t, err, err)
+	assert.Equal(t, payment.PaymentId, outgoing.PaymentId)
+
+	aliceBalance, _ := alice.GetBalance(context.Background())
+	bobBalance, _ := bob.GetBalance(context.Background())
+	assert.Equal(t, uint64(30), aliceBalance.BalanceSat)
+	assert.Equal(t, uint64(70), bobBalance.BalanceSat)
+
+	event := receive(t, events)
+	assert.Equal(t, invoice.PaymentHash, event.PaymentHash)
+	assert.Equal(t, "order-1", event.ExternalId)
+
+	// An invoice cannot be paid twice.
+	_, err = bob.PayInvoice(context.Background(), &phoenixd.PayInvoiceRequest{Invoice: invoice.Serialized})
+	var phoenixErr *phoenixd.Error
+	assert.ErrorAs(t, err, &phoenixErr)
+	assert.Equal(t, phoenixd.PaymentFailedCode, phoenixErr.Code)
+}
+
+func TestFakePhoenixdChecksAuthentication(t *testing.T) {
+	_, client := newFakePhoenixd(t, 0)
+	client.APIKey = "wrong"
+
+	_, err := client.GetInfo(context.Background())
+	var phoenixErr *phoenixd.Error
+	assert.ErrorAs(t, err, &phoenixErr)
+	assert.Equal(t, http.StatusUnauthorized, phoenixErr.StatusCode)
+}
+
+func TestFakePhoenixdEndToEnd(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	serverPhoenix, serverClient := newFakePhoenixd(t, 0)
+	_, clientClient := newFakePhoenixd(t, 10*amount.Satoshi)
+
+	pending := challenge.NewPendingChallenges()
+	challenger := &challenge.TrackingChallenger{
+		Challenger: &challenge.ChallengeFactory{LightningNode: &phoenixd.PhoenixNode{Client: serverClient}},
+		Pending:    pending,
+	}
+	minter := auth.NewMinter(service.NewConfig(service.NewService(serviceName, 2*amount.Satoshi)), secretStore, challenger)
+	l402 := proxy.L402ProxyServer{Minter: &minter, Pending: pending, Webhook: webhook.NewHandler(webhookSecret)}
+
+	server := httptest.NewServer(l402.Router())
+	t.Cleanup(server.Close)
+	serverPhoenix.Webhook = server.URL + "/webhook/phoenixd"
+	serverPhoenix.WebhookSecret = webhookSecret
+
+	serviceURL := fmt.Sprintf("%s/service/%s:0", server.URL, serviceName)
+
+	// Request a challenge.
+	req, _ := http.NewRequest(http.MethodPut, serviceURL, nil)
+	resp, err := http.DefaultClient.Do(req)
+	assert.Nil(t, err, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusPaymentRequired, resp.StatusCode)
+
+	challenges, err := auth.ParseChallenges(resp.Header.Values("WWW-Authenticate")...)
+	assert.Nil(t, err, err)
+	preToken, err := challenges[0].PreToken()
+	assert.Nil(t, err, err)
+
+	// Pay it from the other node.
+	token, err := preToken.PayWithPolicy(&phoenixd.PhoenixNode{Client: clientClient}, challenge.InvoicePolicy{Network: mock.Network})
+	assert.Nil(t, err, err)
+
+	// The settlement was notified to the webhook.
+	assert.True(t, pending.IsPaid(token.Preimage.Hash()))
+
+	// Access the service.
+	req, _ = http.NewRequest(http.MethodGet, serviceURL, nil)
+	req.Header.Set("Authorization", auth.NewAuthorization(token).String())
+	resp, err = http.DefaultClient.Do(req)
+	assert.Nil(t, err, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	balance, err := clientClient.GetBalance(context.Background())
+	assert.Nil(t, err, err)
+	assert.Equal(t, uint64(8), balance.BalanceSat)
+}
@@ -0,0 +1,144 @@
+package tests
+
+import (
+	"context"
+	"lsat/amount"
+	"lsat/auth"
+	"lsat/challenge"
+	"lsat/mock"
+	"lsat/service"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFaultyNodeErrorRate(t *testing.T) {
+	node := mock.NewFaultyLightningNode(mock.NewLightningNode(0), mock.FaultConfig{ErrorRate: 1})
+
+	_, err := node.CreateInvoice(context.Background(), challenge.CreateInvoiceRequest{Amount: amount.Satoshi})
+	assert.ErrorIs(t, err, mock.ErrInjectedFault)
+	assert.Equal(t, 1, node.Stats().Errors)
+}
+
+func TestFaultyNodeDeterministic(t *testing.T) {
+	config := mock.FaultConfig{Seed: 42, ErrorRate: 0.5}
+
+	outcomes := func() []bool {
+		node := mock.NewFaultyLightningNode(mock.NewLightningNode(0), config)
+		var failed []bool
+		for i := 0; i < 20; i++ {
+			_, err := node.CreateInvoice(context.Background(), challenge.CreateInvoiceRequest{Amount: amount.Satoshi})
+			failed = append(failed, err != nil)
+		}
+		return failed
+	}
+
+	first := outcomes()
+	assert.Equal(t, first, outcomes(), "The same seed should inject the same failures")
+	assert.Contains(t, first, true)
+	assert.Contains(t, first, false)
+}
+
+func TestFaultyNodeTimeout(t *testing.T) {
+	node := mock.NewFaultyLightningNode(mock.NewLightningNode(0), mock.FaultConfig{TimeoutRate: 1})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	_, err := node.CreateInvoice(ctx, challenge.CreateInvoiceRequest{Amount: amount.Satoshi})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// Without a deadline, the call hangs for the configured timeout.
+	node.Config.Timeout = 10 * time.Millisecond
+
+	_, err = node.CreateInvoice(context.Background(), challenge.CreateInvoiceRequest{Amount: amount.Satoshi})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestFaultyNodeLatency(t *testing.T) {
+	node := mock.NewFaultyLightningNode(mock.NewLightningNode(0), mock.FaultConfig{Latency: 20 * time.Millisecond})
+
+	start := time.Now()
+	_, err := node.CreateInvoice(context.Background(), challenge.CreateInvoiceRequest{Amount: amount.Satoshi})
+	assert.Nil(t, err, err)
+	assert.GreaterOrEqual(t, time.Since(start), 20*time.Millisecond)
+}
+
+func TestFaultyNodeLostResponse(t *testing.T) {
+	payee := mock.NewLightningNode(0)
+	wallet := mock.NewLightningNode(10 * amount.Satoshi)
+	payer := mock.NewFaultyLightningNode(wallet, mock.FaultConfig{LostResponseRate: 1})
+
+	invoice, _ := payee.CreateInvoice(context.Background(), challenge.CreateInvoiceRequest{Amount: amount.Satoshi})
+
+	_, err := payer.PayInvoice(context.Background(), challenge.PayInvoiceRequest{Invoice: invoice.Invoice})
+	assert.ErrorIs(t, err, mock.ErrLostResponse)
+
+	// The payment went through even though the payer never learnt the preimage.
+	assert.Equal(t, amount.Satoshi, payee.Balance)
+	assert.Equal(t, 9*amount.Satoshi, wallet.Balance)
+
+	// A lost invoice is not a payment in flight.
+	_, err = payer.CreateInvoice(context.Background(), challenge.CreateInvoiceRequest{Amount: amount.Satoshi})
+	assert.ErrorIs(t, err, mock.ErrLostInvoice)
+	assert.NotErrorIs(t, err, challenge.ErrPaymentInFlight)
+}
+
+func TestFaultyNodeDelayedSettlement(t *testing.T) {
+	payee := mock.NewLightningNode(0)
+	payer := mock.NewFaultyLightningNode(mock.NewLightningNode(10*amount.Satoshi), mock.FaultConfig{SettlementDelay: 100 * time.Millisecond})
+
+	invoice, _ := payee.CreateInvoice(context.Background(), challenge.CreateInvoiceRequest{Amount: amount.Satoshi})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	// The payment is in flight when the caller gives up, and settles afterwards.
+	_, err := payer.PayInvoice(ctx, challenge.PayInvoiceRequest{Invoice: invoice.Invoice})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.ErrorIs(t, err, challenge.ErrPaymentInFlight)
+	assert.Equal(t, amount.MilliSatoshi(0), payee.GetBalance(), "The payment should not be settled yet")
+	assert.Eventually(t, func() bool { return payee.GetBalance() == amount.Satoshi }, time.Second, 10*time.Millisecond,
+		"The payment should be settled despite the timeout")
+	assert.Equal(t, 1, payer.Stats().DelayedSettles)
+}
+
+func TestChallengeHonoursContext(t *testing.T) {
+	node := mock.NewFaultyLightningNode(mock.NewLightningNode(0), mock.FaultConfig{TimeoutRate: 1})
+	minter := auth.NewMinter(
+		service.NewConfig(service.NewService(serviceName, servicePrice)),
+		secretStore,
+		&challenge.ChallengeFactory{LightningNode: node},
+	)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := minter.MintTokenContext(ctx, secretStore.NewUser(), service.NewId(serviceName, 0))
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+func TestMintWithFaultyNode(t *testing.T) {
+	node := mock.NewFaultyLightningNode(mock.NewLightningNode(0), mock.FaultConfig{Seed: 7, ErrorRate: 0.5})
+	minter := auth.NewMinter(
+		service.NewConfig(service.NewService(serviceName, servicePrice)),
+		secretStore,
+		&challenge.ChallengeFactory{LightningNode: node},
+	)
+
+	var failures int
+	for i := 0; i < 10; i++ {
+		preToken, err := minter.MintToken(secretStore.NewUser(), service.NewId(serviceName, 0))
+		if err != nil {
+			assert.ErrorIs(t, err, mock.ErrInjectedFault)
+			failures++
+			continue
+		}
+		assert.NotEmpty(t, preToken.InvoiceResponse.Invoice)
+	}
+
+	assert.Equal(t, node.Stats().Errors, failures)
+}
@@ -0,0 +1,175 @@
+package tests
+
+import (
+	"context"
+	"lsat/amount"
+	"lsat/auth"
+	"lsat/challenge"
+	"lsat/middleware"
+	"lsat/mock"
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// newGRPCServer serves the health service behind the L402 interceptors, in process.
+func newGRPCServer(t *testing.T, options ...grpc.DialOption) (*auth.Minter, *grpc.ClientConn) {
+	minter := newMiddlewareMinter()
+	server := grpc.NewServer(
+		grpc.UnaryInterceptor(middleware.UnaryServerInterceptor(minter, testService.Id())),
+		grpc.StreamInterceptor(middleware.StreamServerInterceptor(minter, testService.Id())),
+	)
+	healthpb.RegisterHealthServer(server, health.NewServer())
+
+	listener := bufconn.Listen(1 << 20)
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+
+	options = append(options,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+	)
+	conn, err := grpc.Dial("bufnet", options...)
+	assert.Nil(t, err, err)
+	t.Cleanup(func() { conn.Close() })
+	return minter, conn
+}
+
+func TestGRPCChallenges(t *testing.T) {
+	_, conn := newGRPCServer(t)
+
+	var trailer metadata.MD
+	_, err := healthpb.NewHealthClient(conn).Check(context.Background(), &healthpb.HealthCheckRequest{}, grpc.Trailer(&trailer))
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	preToken, err := auth.Challenge{Macaroon: trailer.Get(middleware.MacaroonTrailer)[0], Invoice: trailer.Get(middleware.InvoiceTrailer)[0]}.PreToken()
+	assert.Nil(t, err, err)
+	assert.Nil(t, preToken.Verify(challenge.InvoicePolicy{Network: mock.Network}))
+}
+
+func TestGRPCAuthorizes(t *testing.T) {
+	minter, conn := newGRPCServer(t)
+	client := healthpb.NewHealthClient(conn)
+
+	token := paidToken(t, minter, testService.Id())
+	ctx := metadata.AppendToOutgoingContext(context.Background(), middleware.AuthorizationMetadata, auth.NewAuthorization(token).String())
+	resp, err := client.Check(ctx, &healthpb.HealthCheckRequest{})
+	assert.Nil(t, err, err)
+	assert.Equal(t, healthpb.HealthCheckResponse_SERVING, resp.Status)
+
+	ctx = metadata.AppendToOutgoingContext(context.Background(), middleware.AuthorizationMetadata, "L402 abc")
+	_, err = client.Check(ctx, &healthpb.HealthCheckRequest{})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	// Credentials of another scheme are not L402 credentials, and are challenged.
+	var trailer metadata.MD
+	ctx = metadata.AppendToOutgoingContext(context.Background(), middleware.AuthorizationMetadata, "Bearer abc")
+	_, err = client.Check(ctx, &healthpb.HealthCheckRequest{}, grpc.Trailer(&trailer))
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	assert.NotEmpty(t, trailer.Get(middleware.InvoiceTrailer))
+
+	token.Preimage[0] ^= 1
+	ctx = metadata.AppendToOutgoingContext(context.Background(), middleware.AuthorizationMetadata, auth.NewAuthorization(token).String())
+	_, err = client.Check(ctx, &healthpb.HealthCheckRequest{})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+}
+
+func TestGRPCPayerUnary(t *testing.T) {
+	node := mock.NewLightningNode(10 * amount.Satoshi)
+	payer := middleware.NewPayer(node, challenge.InvoicePolicy{Network: mock.Network})
+	_, conn := newGRPCServer(t, grpc.WithUnaryInterceptor(payer.UnaryClientInterceptor()))
+	client := healthpb.NewHealthClient(conn)
+
+	resp, err := client.Check(context.Background(), &healthpb.HealthCheckRequest{})
+	assert.Nil(t, err, err)
+	assert.Equal(t, healthpb.HealthCheckResponse_SERVING, resp.Status)
+
+	// The token is reused without paying again.
+	token, ok := payer.Token(conn.Target(), "/grpc.health.v1.Health/Check")
+	assert.True(t, ok)
+	_, err = client.Check(context.Background(), &healthpb.HealthCheckRequest{})
+	assert.Nil(t, err, err)
+	again, _ := payer.Token(conn.Target(), "/grpc.health.v1.Health/Check")
+	assert.Equal(t, token.Preimage, again.Preimage)
+}
+
+func TestGRPCPayerStream(t *testing.T) {
+	node := mock.NewLightningNode(10 * amount.Satoshi)
+	payer := middleware.NewPayer(node, challenge.InvoicePolicy{Network: mock.Network})
+	_, conn := newGRPCServer(t, grpc.WithStreamInterceptor(payer.StreamClientInterceptor()))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	stream, err := healthpb.NewHealthClient(conn).Watch(ctx, &healthpb.HealthCheckRequest{})
+	assert.Nil(t, err, err)
+
+	resp, err := stream.Recv()
+	assert.Nil(t, err, err)
+	assert.Equal(t, healthpb.HealthCheckResponse_SERVING, resp.Status)
+
+	_, ok := payer.Token(conn.Target(), "/grpc.health.v1.Health/Watch")
+	assert.True(t, ok)
+}
+
+func TestGRPCPayerSharesTokensByService(t *testing.T) {
+	node := mock.NewLightningNode(10 * amount.Satoshi)
+	payer := middleware.NewPayer(node, challenge.InvoicePolicy{Network: mock.Network})
+	_, conn := newGRPCServer(t,
+		grpc.WithUnaryInterceptor(payer.UnaryClientInterceptor()),
+		grpc.WithStreamInterceptor(payer.StreamClientInterceptor()),
+	)
+	client := healthpb.NewHealthClient(conn)
+
+	// Concurrent calls challenged together pay a single token.
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := client.Check(context.Background(), &healthpb.HealthCheckRequest{})
+			assert.Nil(t, err, err)
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 10*amount.Satoshi-servicePrice, node.GetBalance())
+
+	// The other methods of the service reuse the token.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream, err := client.Watch(ctx, &healthpb.HealthCheckRequest{})
+	assert.Nil(t, err, err)
+	_, err = stream.Recv()
+	assert.Nil(t, err, err)
+	assert.Equal(t, 10*amount.Satoshi-servicePrice, node.GetBalance())
+
+	check, _ := payer.Token(conn.Target(), "/grpc.health.v1.Health/Check")
+	watch, _ := payer.Token(conn.Target(), "/grpc.health.v1.Health/Watch")
+	assert.Equal(t, check.Preimage, watch.Preimage)
+	_, ok := payer.Token("other:443", "/grpc.health.v1.Health/Check")
+	assert.False(t, ok)
+}
+
+func TestGRPCPayerPolicy(t *testing.T) {
+	node := mock.NewLightningNode(10 * amount.Satoshi)
+	payer := middleware.NewPayer(node, challenge.InvoicePolicy{Network: mock.Network, MaxAmount: amount.MilliSat})
+	_, conn := newGRPCServer(t, grpc.WithUnaryInterceptor(payer.UnaryClientInterceptor()))
+
+	// The invoice is above the policy, so it is not paid.
+	_, err := healthpb.NewHealthClient(conn).Check(context.Background(), &healthpb.HealthCheckRequest{})
+	assert.NotNil(t, err)
+	_, ok := payer.Token(conn.Target(), "/grpc.health.v1.Health/Check")
+	assert.False(t, ok)
+}
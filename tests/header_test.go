@@ -0,0 +1,115 @@
+package tests
+
+import (
+	"lsat/amount"
+	"lsat/auth"
+	"lsat/challenge"
+	"lsat/mock"
+	"lsat/service"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Samples of the L402 specification.
+const (
+	specMacaroon      = "AGIAJEemVQUTEyNCR0exk7ek90Cg=="
+	specInvoice       = "lnbc1500n1pw5kjhmpp5fu6xhthlt2vucmzkx6c7wtlh2r625r30cyjsfqhu8rsx4xpz5lwqdpa2fjkzep6yptksct5yp5hxgrrv96hx6twvusycn3qv9jx7ur5d9hx2ucxqzpgretyakx5sfkxmmj4khdfzzc2zhvvfqqqx86kw2njzjmwvv8qgv3u5w5utmx9k9xdaxlwa9pn06lyd8f63rdfqsnz2yxxeqwy5qzvmw95p2lf6ezm9qg9ud9gpqvyuqjrtynqsl7ucmdpa3q"
+	specChallenge     = `L402 macaroon="` + specMacaroon + `", invoice="` + specInvoice + `"`
+	specAuthorization = "L402 " + specMacaroon + ":1234abcd1234abcd1234abcd"
+)
+
+func TestParseSpecChallenge(t *testing.T) {
+	challenges, err := auth.ParseChallenges(specChallenge)
+	assert.Nil(t, err, err)
+	assert.Equal(t, []auth.Challenge{{Scheme: auth.SchemeL402, Macaroon: specMacaroon, Invoice: specInvoice}}, challenges)
+	assert.Equal(t, specChallenge, challenges[0].String())
+}
+
+func TestParseSpecAuthorization(t *testing.T) {
+	authorization, err := auth.ParseAuthorization(specAuthorization)
+	assert.Nil(t, err, err)
+	assert.Equal(t, auth.SchemeL402, authorization.Scheme)
+	assert.Equal(t, []string{specMacaroon}, authorization.Macaroons)
+	assert.Equal(t, "1234abcd1234abcd1234abcd", authorization.Preimage)
+	assert.Equal(t, specAuthorization, authorization.String())
+
+	// The preimage of the sample is shortened, so it is not a valid token.
+	_, err = authorization.Token()
+	assert.ErrorIs(t, err, auth.ErrInvalidAuth)
+}
+
+func TestParseLegacyChallenges(t *testing.T) {
+	header := `Basic realm="api", lsat Macaroon=` + specMacaroon + `, INVOICE="` + specInvoice + `", L402 macaroon="a\"b", invoice="lnbc1"`
+
+	challenges, err := auth.ParseChallenges(header)
+	assert.Nil(t, err, err)
+	assert.Len(t, challenges, 2)
+
+	// Unquoted values and names in any case.
+	assert.Equal(t, auth.SchemeLSAT, challenges[0].Scheme)
+	assert.Equal(t, specMacaroon, challenges[0].Macaroon)
+	assert.Equal(t, specInvoice, challenges[0].Invoice)
+
+	// Escaped quotes.
+	assert.Equal(t, `a"b`, challenges[1].Macaroon)
+	assert.Equal(t, `L402 macaroon="a\"b", invoice="lnbc1"`, challenges[1].String())
+
+	// The challenges may be split across headers.
+	challenges, err = auth.ParseChallenges(`Bearer realm="api"`, `LSAT macaroon="a", invoice="b"`, specChallenge)
+	assert.Nil(t, err, err)
+	assert.Len(t, challenges, 2)
+}
+
+func TestParseInvalidChallenges(t *testing.T) {
+	_, err := auth.ParseChallenges(`Bearer realm="api"`)
+	assert.ErrorIs(t, err, auth.ErrNoChallenge)
+
+	_, err = auth.ParseChallenges(`L402 macaroon="` + specMacaroon + `"`)
+	assert.ErrorIs(t, err, auth.ErrInvalidChallenge)
+
+	_, err = auth.ParseChallenges(`L402 macaroon="` + specMacaroon)
+	assert.ErrorIs(t, err, auth.ErrInvalidChallenge)
+}
+
+func TestParseAuthorizations(t *testing.T) {
+	authorization, err := auth.ParseAuthorization("lsat " + specMacaroon + ", " + specMacaroon + ":1234")
+	assert.Nil(t, err, err)
+	assert.Equal(t, auth.SchemeLSAT, authorization.Scheme)
+	assert.Equal(t, []string{specMacaroon, specMacaroon}, authorization.Macaroons)
+
+	// The macaroons after the first one are not silently ignored.
+	_, err = authorization.Token()
+	assert.ErrorIs(t, err, auth.ErrInvalidAuth)
+	assert.ErrorContains(t, err, "got 2")
+	_, err = auth.Authorization{Preimage: "1234"}.Token()
+	assert.ErrorIs(t, err, auth.ErrInvalidAuth)
+
+	for _, header := range []string{"Bearer abc", "L402", "L402 " + specMacaroon, "L402 :1234", "L402 " + specMacaroon + ":"} {
+		_, err := auth.ParseAuthorization(header)
+		assert.NotNil(t, err, header)
+	}
+}
+
+func TestHeaderRoundTrip(t *testing.T) {
+	minter := auth.NewMinter(service.NewConfig(testService), secretStore, mock.NewChallenger())
+	preToken, err := minter.MintToken(secretStore.NewUser(), testService.Id())
+	assert.Nil(t, err, err)
+
+	challenges, err := auth.ParseChallenges(auth.NewChallenge(preToken).String())
+	assert.Nil(t, err, err)
+	parsed, err := challenges[0].PreToken()
+	assert.Nil(t, err, err)
+	assert.Equal(t, preToken.Macaroon.Signature(), parsed.Macaroon.Signature())
+	assert.Equal(t, preToken.InvoiceResponse.Invoice, parsed.InvoiceResponse.Invoice)
+
+	token, err := parsed.PayWithPolicy(mock.NewLightningNode(10*amount.Satoshi), challenge.InvoicePolicy{Network: mock.Network})
+	assert.Nil(t, err, err)
+
+	authorization, err := auth.ParseAuthorization(auth.NewAuthorization(token).String())
+	assert.Nil(t, err, err)
+	decoded, err := authorization.Token()
+	assert.Nil(t, err, err)
+	assert.Equal(t, token.Preimage, decoded.Preimage)
+	assert.Nil(t, minter.AuthToken(&decoded))
+}
@@ -0,0 +1,139 @@
+package tests
+
+import (
+	"errors"
+	"lsat/amount"
+	"lsat/challenge"
+	"lsat/macaroon"
+	"lsat/mock"
+	"lsat/secrets"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/ecdsa"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/lightningnetwork/lnd/lntypes"
+	"github.com/lightningnetwork/lnd/zpay32"
+	"github.com/stretchr/testify/assert"
+)
+
+// signInvoice creates a BOLT11 invoice signed by a random key.
+func signInvoice(t *testing.T, network *chaincfg.Params, hash lntypes.Hash, timestamp time.Time, options ...func(*zpay32.Invoice)) string {
+	key, err := btcec.NewPrivateKey()
+	assert.Nil(t, err, err)
+
+	options = append(options, zpay32.Description("L402"), zpay32.PaymentAddr([32]byte(secrets.NewSecret())))
+
+	invoice, err := zpay32.NewInvoice(network, hash, timestamp, options...)
+	assert.Nil(t, err, err)
+
+	encoded, err := invoice.Encode(zpay32.MessageSigner{
+		SignCompact: func(msg []byte) ([]byte, error) {
+			return ecdsa.SignCompact(key, chainhash.HashB(msg), true)
+		},
+	})
+	assert.Nil(t, err, err)
+
+	return encoded
+}
+
+// bolt11PreToken creates a pre-token whose macaroon is bound to the hash.
+func bolt11PreToken(t *testing.T, hash lntypes.Hash, invoice string) macaroon.PreToken {
+	uid := secretStore.NewUser()
+	root, _ := secretStore.NewSecret(uid)
+
+	mac, err := macaroon.NewOven(root).WithUserId(uid).WithFirstPartyCaveats(
+		macaroon.NewCaveat(macaroon.PaymentHashKey, hash.String()),
+	).Bake()
+	assert.Nil(t, err, err)
+
+	return macaroon.PreToken{
+		Macaroon:        mac,
+		InvoiceResponse: challenge.InvoiceResponse{PaymentHash: hash, Invoice: invoice},
+	}
+}
+
+func assertInvoiceCheck(t *testing.T, err error, check challenge.InvoiceCheck) {
+	var invoiceErr *challenge.InvoiceError
+	if assert.True(t, errors.As(err, &invoiceErr), "expected an InvoiceError, got %v", err) {
+		assert.Equal(t, check, invoiceErr.Check, invoiceErr.Error())
+	}
+}
+
+var regtestPolicy = challenge.InvoicePolicy{
+	MaxAmount: 1000 * amount.Satoshi,
+	Network:   &chaincfg.RegressionNetParams,
+}
+
+func TestVerifyInvoice(t *testing.T) {
+	hash := lntypes.Hash(secrets.NewSecret())
+	invoice := signInvoice(t, &chaincfg.RegressionNetParams, hash, time.Now(), zpay32.Amount(100_000))
+
+	err := bolt11PreToken(t, hash, invoice).Verify(regtestPolicy)
+	assert.Nil(t, err, err)
+}
+
+func TestVerifyInvoiceAmount(t *testing.T) {
+	hash := lntypes.Hash(secrets.NewSecret())
+	invoice := signInvoice(t, &chaincfg.RegressionNetParams, hash, time.Now(), zpay32.Amount(1_000_001))
+
+	err := bolt11PreToken(t, hash, invoice).Verify(regtestPolicy)
+	assertInvoiceCheck(t, err, challenge.CheckAmount)
+
+	// An invoice without amount cannot be checked against the maximum.
+	invoice = signInvoice(t, &chaincfg.RegressionNetParams, hash, time.Now())
+
+	err = bolt11PreToken(t, hash, invoice).Verify(regtestPolicy)
+	assertInvoiceCheck(t, err, challenge.CheckAmount)
+}
+
+func TestVerifyInvoicePaymentHash(t *testing.T) {
+	hash := lntypes.Hash(secrets.NewSecret())
+	invoice := signInvoice(t, &chaincfg.RegressionNetParams, lntypes.Hash(secrets.NewSecret()), time.Now(), zpay32.Amount(100_000))
+
+	err := bolt11PreToken(t, hash, invoice).Verify(regtestPolicy)
+	assertInvoiceCheck(t, err, challenge.CheckPaymentHash)
+}
+
+func TestVerifyInvoiceExpiry(t *testing.T) {
+	hash := lntypes.Hash(secrets.NewSecret())
+	invoice := signInvoice(t, &chaincfg.RegressionNetParams, hash, time.Now().Add(-2*time.Hour),
+		zpay32.Amount(100_000), zpay32.Expiry(time.Hour))
+
+	err := bolt11PreToken(t, hash, invoice).Verify(regtestPolicy)
+	assertInvoiceCheck(t, err, challenge.CheckExpiry)
+}
+
+func TestVerifyInvoiceNetwork(t *testing.T) {
+	hash := lntypes.Hash(secrets.NewSecret())
+	invoice := signInvoice(t, &chaincfg.MainNetParams, hash, time.Now(), zpay32.Amount(100_000))
+
+	err := bolt11PreToken(t, hash, invoice).Verify(regtestPolicy)
+	assertInvoiceCheck(t, err, challenge.CheckNetwork)
+
+	// A mainnet policy rejects a regtest invoice even though "lnbc" prefixes "lnbcrt".
+	invoice = signInvoice(t, &chaincfg.RegressionNetParams, hash, time.Now(), zpay32.Amount(100_000))
+
+	err = bolt11PreToken(t, hash, invoice).Verify(challenge.InvoicePolicy{})
+	assertInvoiceCheck(t, err, challenge.CheckNetwork)
+}
+
+func TestVerifyInvoiceDecoding(t *testing.T) {
+	hash := lntypes.Hash(secrets.NewSecret())
+
+	err := bolt11PreToken(t, hash, "lnbcrt1notaninvoice").Verify(regtestPolicy)
+	assertInvoiceCheck(t, err, challenge.CheckDecoding)
+}
+
+func TestPayWithPolicyRefuses(t *testing.T) {
+	ln := mock.TestLightningNode{Balance: 10_000 * amount.Satoshi}
+
+	hash := lntypes.Hash(secrets.NewSecret())
+	invoice := signInvoice(t, &chaincfg.RegressionNetParams, hash, time.Now(), zpay32.Amount(2_000_000))
+
+	_, err := bolt11PreToken(t, hash, invoice).PayWithPolicy(&ln, regtestPolicy)
+	assertInvoiceCheck(t, err, challenge.CheckAmount)
+	assert.Equal(t, 10_000*amount.Satoshi, ln.Balance, "Nothing should be paid")
+}
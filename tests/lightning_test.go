@@ -2,11 +2,18 @@ package tests
 
 import (
 	"context"
+	"lsat/amount"
+	"lsat/auth"
 	"lsat/challenge"
 	"lsat/mock"
 	"lsat/secrets"
+	"lsat/service"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/lightningnetwork/lnd/lntypes"
+	"github.com/lightningnetwork/lnd/zpay32"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -24,23 +31,108 @@ func TestPayInvoice(t *testing.T) {
 	}
 
 	assert.Equal(t, invoice.PaymentHash, payment.PaymentHash)
+	assert.Equal(t, invoice.PaymentHash, payment.Preimage.Hash())
 }
 
-func TestXor(t *testing.T) {
-	secret := secrets.NewSecret()
+func TestMockInvoiceIsBolt11(t *testing.T) {
+	ln := mock.NewLightningNode(0)
 
-	xorA := xor(secret[:])
-	xorB := xor(xorA)
+	invoice, err := ln.CreateInvoice(context.Background(), challenge.CreateInvoiceRequest{
+		Amount:      1500 * amount.MilliSat,
+		Description: "L402",
+	})
+	assert.Nil(t, err, err)
 
-	assert.Equal(t, secret[:], xorB)
-	assert.NotEqual(t, xorA, xorB)
+	t.Log(invoice.Invoice)
+
+	decoded, err := zpay32.Decode(invoice.Invoice, mock.Network)
+	assert.Nil(t, err, err)
+	assert.Equal(t, invoice.PaymentHash, lntypes.Hash(*decoded.PaymentHash))
+	assert.Equal(t, 1500*amount.MilliSat, amount.FromLnwire(*decoded.MilliSat))
+	assert.Equal(t, "L402", *decoded.Description)
+
+	pubKey, _ := ln.PubKey()
+	assert.True(t, pubKey.IsEqual(decoded.Destination), "The invoice should be signed by the node")
+}
+
+func TestMockInvoicePaidOnce(t *testing.T) {
+	payee := mock.NewLightningNode(0)
+	payer := mock.NewLightningNode(10 * amount.Satoshi)
+
+	invoice, _ := payee.CreateInvoice(context.Background(), challenge.CreateInvoiceRequest{Amount: amount.Satoshi})
+
+	_, err := payer.PayInvoice(context.Background(), challenge.PayInvoiceRequest{Invoice: invoice.Invoice})
+	assert.Nil(t, err, err)
+
+	_, err = payer.PayInvoice(context.Background(), challenge.PayInvoiceRequest{Invoice: invoice.Invoice})
+	assert.ErrorIs(t, err, mock.ErrAlreadyPaid)
+	assert.Equal(t, 9*amount.Satoshi, payer.Balance, "A failed payment should be refunded")
+}
+
+func TestMockUnknownNode(t *testing.T) {
+	payer := mock.NewLightningNode(10 * amount.Satoshi)
+
+	// An invoice signed by a key outside of the mock network cannot be paid.
+	invoice := signInvoice(t, mock.Network, lntypes.Hash(secrets.NewSecret()), time.Now(), zpay32.Amount(1000))
+
+	_, err := payer.PayInvoice(context.Background(), challenge.PayInvoiceRequest{Invoice: invoice})
+	assert.ErrorIs(t, err, mock.ErrUnknownNode)
+}
+
+func TestMockClosedNode(t *testing.T) {
+	payee := mock.NewLightningNode(0)
+	payer := mock.NewLightningNode(10 * amount.Satoshi)
+
+	// A closed node leaves the network, its invoices cannot be paid anymore.
+	invoice, err := payee.CreateInvoice(context.Background(), challenge.CreateInvoiceRequest{Amount: amount.Satoshi})
+	assert.Nil(t, err, err)
+	payee.Close()
+
+	_, err = payer.PayInvoice(context.Background(), challenge.PayInvoiceRequest{Invoice: invoice.Invoice})
+	assert.ErrorIs(t, err, mock.ErrUnknownNode)
+	assert.Equal(t, 10*amount.Satoshi, payer.Balance)
+
+	// It can still pay the others.
+	payer.Close()
+	invoice, err = mock.NewLightningNode(0).CreateInvoice(context.Background(), challenge.CreateInvoiceRequest{Amount: amount.Satoshi})
+	assert.Nil(t, err, err)
+	_, err = payer.PayInvoice(context.Background(), challenge.PayInvoiceRequest{Invoice: invoice.Invoice})
+	assert.Nil(t, err, err)
 }
 
-func xor(data []byte) []byte {
-	maxByte := byte(255)
-	result := make([]byte, len(data))
-	for i, b := range data {
-		result[i] = b ^ maxByte
+func TestMockConcurrentPayments(t *testing.T) {
+	payee := mock.NewLightningNode(0)
+	payer := mock.NewLightningNode(100 * amount.Satoshi)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			invoice, err := payee.CreateInvoice(context.Background(), challenge.CreateInvoiceRequest{Amount: amount.Satoshi})
+			if assert.Nil(t, err, err) {
+				_, err = payer.PayInvoice(context.Background(), challenge.PayInvoiceRequest{Invoice: invoice.Invoice})
+				assert.Nil(t, err, err)
+			}
+		}()
 	}
-	return result
+	wg.Wait()
+
+	assert.Equal(t, 50*amount.Satoshi, payer.Balance)
+	assert.Equal(t, 50*amount.Satoshi, payee.Balance)
+}
+
+func TestPayWithPolicy(t *testing.T) {
+	minter := auth.NewMinter(service.NewConfig(service.NewService(serviceName, servicePrice)), secretStore, mock.NewChallenger())
+
+	preToken, err := minter.MintToken(secretStore.NewUser(), service.NewId(serviceName, 0))
+	assert.Nil(t, err, err)
+
+	wallet := mock.NewLightningNode(10 * amount.Satoshi)
+
+	token, err := preToken.PayWithPolicy(wallet, challenge.InvoicePolicy{MaxAmount: amount.Satoshi, Network: mock.Network})
+	assert.Nil(t, err, err)
+
+	err = minter.AuthToken(&token)
+	assert.Nil(t, err, err)
 }
@@ -0,0 +1,253 @@
+package tests
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"lsat/amount"
+	"lsat/auth"
+	"lsat/challenge"
+	"lsat/config"
+	"lsat/metered"
+	"lsat/middleware"
+	"lsat/mock"
+	"lsat/proxy"
+	"lsat/service"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lightningnetwork/lnd/lntypes"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/websocket"
+)
+
+const streamEvents = 10
+
+// events streams numbered server-sent events.
+var events = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	for i := 0; i < streamEvents; i++ {
+		fmt.Fprintf(w, "data: event %d\n\n", i)
+		w.(http.Flusher).Flush()
+	}
+})
+
+// payInvoice pays an invoice and returns its preimage.
+func payInvoice(t *testing.T, node challenge.LightningNode, invoice string) string {
+	response, err := node.PayInvoice(context.Background(), challenge.PayInvoiceRequest{Invoice: invoice})
+	assert.Nil(t, err, err)
+	return response.Preimage.String()
+}
+
+// postPreimage posts a preimage to the payments of a meter, and returns the status.
+func postPreimage(t *testing.T, url string, preimage string) int {
+	resp, err := http.Post(url, "application/json", strings.NewReader(fmt.Sprintf(`{"preimage":%q}`, preimage)))
+	assert.Nil(t, err, err)
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+// readStream reads the events of a metered stream, paying the invoices to payments if set,
+// and returns the data of the events with the number of payments.
+func readStream(t *testing.T, url string, minter *auth.Minter, id service.ServiceID, payments string) ([]string, int) {
+	req, _ := http.NewRequest(http.MethodGet, url, nil)
+	req.Header.Set("Authorization", auth.NewAuthorization(paidToken(t, minter, id)).String())
+	resp, err := http.DefaultClient.Do(req)
+	assert.Nil(t, err, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	node := mock.NewLightningNode(100 * amount.Satoshi)
+	var data []string
+	paid, event := 0, ""
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "event: "):
+			event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: ") && event == metered.PaymentRequiredEvent:
+			var required metered.PaymentRequired
+			assert.Nil(t, json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &required))
+			assert.Equal(t, uint64(servicePrice), required.AmountMsat)
+			if payments != "" {
+				paid++
+				assert.Equal(t, http.StatusNoContent, postPreimage(t, payments, payInvoice(t, node, required.Invoice)))
+			}
+		case strings.HasPrefix(line, "data: ") && event == "":
+			data = append(data, strings.TrimPrefix(line, "data: "))
+		case line == "":
+			event = ""
+		}
+	}
+	return data, paid
+}
+
+func TestMeteredSSEProxy(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	backend := httptest.NewServer(events)
+	defer backend.Close()
+
+	// Each payment buys two events of 15 bytes.
+	route, err := proxy.NewRoute(testService.Id(), "/stream", backend.URL)
+	assert.Nil(t, err, err)
+	route.Metered = metered.Allowance{Bytes: 30}
+
+	minter := auth.NewMinter(service.NewConfig(testService), secretStore, mock.NewChallenger())
+	server := proxy.L402ProxyServer{Minter: &minter, Routes: []proxy.Route{route}}
+	frontend := httptest.NewServer(server.Router())
+	defer frontend.Close()
+
+	data, payments := readStream(t, frontend.URL+"/stream", &minter, testService.Id(), frontend.URL+proxy.PreimagePath)
+	assert.Len(t, data, streamEvents)
+	assert.Equal(t, "event 9", data[streamEvents-1])
+	assert.Equal(t, streamEvents/2-1, payments)
+
+	// The preimages of the invoices no stream is waiting for are refused.
+	assert.Equal(t, http.StatusNotFound, postPreimage(t, frontend.URL+proxy.PreimagePath, lntypes.Preimage{1}.String()))
+	assert.Equal(t, http.StatusBadRequest, postPreimage(t, frontend.URL+proxy.PreimagePath, "not a preimage"))
+}
+
+func TestMeteredSSEForwardsBody(t *testing.T) {
+	minter := auth.NewMinter(service.NewConfig(testService), secretStore, mock.NewChallenger())
+	meter := metered.NewMeter(&minter, testService.Id(), metered.Allowance{Bytes: 30})
+	echo := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprintf(w, "data: %s\n\n", body)
+	})
+
+	server := httptest.NewServer(middleware.L402(&minter, testService.Id())(meter.SSE(echo)))
+	defer server.Close()
+
+	// The body of the request reaches the handler.
+	req, _ := http.NewRequest(http.MethodPost, server.URL, strings.NewReader(`{"prompt":"hello"}`))
+	req.Header.Set("Authorization", auth.NewAuthorization(paidToken(t, &minter, testService.Id())).String())
+	resp, err := http.DefaultClient.Do(req)
+	assert.Nil(t, err, err)
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	assert.Equal(t, "data: {\"prompt\":\"hello\"}\n\n", string(body))
+}
+
+func TestMeteredSSETimeout(t *testing.T) {
+	minter := auth.NewMinter(service.NewConfig(testService), secretStore, mock.NewChallenger())
+	meter := metered.NewMeter(&minter, testService.Id(), metered.Allowance{Bytes: 30})
+	meter.PaymentTimeout = 50 * time.Millisecond
+
+	server := httptest.NewServer(middleware.L402(&minter, testService.Id())(meter.SSE(events)))
+	defer server.Close()
+
+	// The stream ends when the invoice is not paid.
+	data, _ := readStream(t, server.URL, &minter, testService.Id(), "")
+	assert.Equal(t, []string{"event 0", "event 1"}, data)
+}
+
+func TestMeteredSSELineEndings(t *testing.T) {
+	minter := auth.NewMinter(service.NewConfig(testService), secretStore, mock.NewChallenger())
+	meter := metered.NewMeter(&minter, testService.Id(), metered.Allowance{Bytes: 30})
+	meter.PaymentTimeout = 50 * time.Millisecond
+
+	// The events end with any line ending, split across the writes.
+	sent := []string{"data: 0\r\n\r\n", "data: 1\n\r\n", "data: 2\r\r", "data: 3\n\n"}
+	stream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, event := range sent {
+			io.WriteString(w, event[:len(event)-1])
+			w.(http.Flusher).Flush()
+			io.WriteString(w, event[len(event)-1:])
+			w.(http.Flusher).Flush()
+		}
+	})
+
+	server := httptest.NewServer(middleware.L402(&minter, testService.Id())(meter.SSE(stream)))
+	defer server.Close()
+
+	// The events within the allowance are sent whole, the stream ends when the next is not paid.
+	req, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+	req.Header.Set("Authorization", auth.NewAuthorization(paidToken(t, &minter, testService.Id())).String())
+	resp, err := http.DefaultClient.Do(req)
+	assert.Nil(t, err, err)
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	assert.True(t, strings.HasPrefix(string(body), sent[0]+sent[1]+sent[2]+"event: "+metered.PaymentRequiredEvent), string(body))
+	assert.NotContains(t, string(body), "data: 3")
+}
+
+func TestMeteredWebSocket(t *testing.T) {
+	var mu sync.Mutex
+	now := time.Now()
+	clock := func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		return now
+	}
+
+	minter := auth.NewMinter(service.NewConfig(testService), secretStore, mock.NewChallenger())
+	meter := metered.NewMeter(&minter, testService.Id(), metered.Allowance{Duration: time.Minute})
+	meter.Clock = clock
+
+	// A minute passes between the messages, so each message after the first needs a payment.
+	server := httptest.NewServer(websocket.Handler(func(ws *websocket.Conn) {
+		conn := meter.WebSocket(ws)
+		for i := 0; i < 3; i++ {
+			if err := conn.Send(fmt.Sprintf("message %d", i)); err != nil {
+				return
+			}
+			mu.Lock()
+			now = now.Add(time.Minute)
+			mu.Unlock()
+		}
+	}))
+	defer server.Close()
+
+	ws, err := websocket.Dial("ws"+strings.TrimPrefix(server.URL, "http"), "", server.URL)
+	assert.Nil(t, err, err)
+	defer ws.Close()
+
+	node := mock.NewLightningNode(100 * amount.Satoshi)
+	var messages []string
+	for len(messages) < 3 {
+		var message string
+		assert.Nil(t, websocket.Message.Receive(ws, &message))
+
+		var event metered.Event
+		if json.Unmarshal([]byte(message), &event) != nil || event.Event == "" {
+			messages = append(messages, message)
+			continue
+		}
+		if event.Event == metered.PaymentRequiredEvent {
+			var required metered.PaymentRequired
+			assert.Nil(t, json.Unmarshal(event.Data, &required))
+			websocket.JSON.Send(ws, map[string]string{"preimage": payInvoice(t, node, required.Invoice)})
+		}
+	}
+	assert.Equal(t, []string{"message 0", "message 1", "message 2"}, messages)
+}
+
+func TestConfigMeteredRoute(t *testing.T) {
+	data := `services:
+  - { name: inference, price: 1sat, per_request: true }
+routes:
+  - service: "inference:0"
+    path: /generate
+    upstream: "http://localhost:8000"
+    metered: { bytes: 4096, duration: 1m }
+lightning:
+  backend: mock
+`
+	cfg, err := config.Parse([]byte(data), config.YAML)
+	assert.Nil(t, err, err)
+	assert.True(t, cfg.Services[0].PerRequest)
+	assert.Equal(t, metered.Allowance{Bytes: 4096, Duration: time.Minute}, cfg.Routes[0].Metered)
+
+	_, err = config.Parse([]byte(strings.Replace(data, "duration: 1m", "duration: -1m", 1)), config.YAML)
+	assert.ErrorContains(t, err, "line 7: routes[0].metered.duration")
+}
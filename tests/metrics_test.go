@@ -0,0 +1,137 @@
+package tests
+
+import (
+	"io"
+	"lsat/auth"
+	"lsat/challenge"
+	"lsat/metrics"
+	"lsat/middleware"
+	"lsat/mock"
+	"lsat/proxy"
+	"lsat/service"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+// scrape returns the metrics served by a server.
+// metricsToken is the bearer token of the metrics in the tests.
+const metricsToken = "metrics-secret"
+
+func scrape(t *testing.T, url string) string {
+	req, _ := http.NewRequest(http.MethodGet, url+"/metrics", nil)
+	req.Header.Set("Authorization", "Bearer "+metricsToken)
+	resp, err := http.DefaultClient.Do(req)
+	assert.Nil(t, err, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, resp.Header.Get("Content-Type"), "text/plain; version=0.0.4")
+
+	body, _ := io.ReadAll(resp.Body)
+	return string(body)
+}
+
+func TestRegistryFormat(t *testing.T) {
+	registry := metrics.NewRegistry()
+	counter := registry.Counter("requests_total", "The requests.", "path")
+	histogram := registry.Histogram("latency_seconds", "The latency.", []float64{0.1, 1})
+
+	counter.Inc(`/a"b`)
+	counter.Add(2, "/c")
+	histogram.Observe(0.05)
+	histogram.Observe(0.5)
+	histogram.Observe(5)
+
+	var out strings.Builder
+	_, err := registry.WriteTo(&out)
+	assert.Nil(t, err, err)
+	assert.Equal(t, `# HELP requests_total The requests.
+# TYPE requests_total counter
+requests_total{path="/a\"b"} 1
+requests_total{path="/c"} 2
+# HELP latency_seconds The latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{le="0.1"} 1
+latency_seconds_bucket{le="1"} 2
+latency_seconds_bucket{le="+Inf"} 3
+latency_seconds_sum 5.55
+latency_seconds_count 3
+`, out.String())
+}
+
+func TestProxyMetrics(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	m := metrics.New()
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	}))
+	defer backend.Close()
+	route, err := proxy.NewRoute(testService.Id(), "/api", backend.URL)
+	assert.Nil(t, err, err)
+
+	pending := challenge.NewPendingChallenges()
+	pending.OnSettle = m.Settled
+	node := metrics.NewNode(mock.NewLightningNode(math.MaxUint64), m)
+	challenger := &challenge.TrackingChallenger{Challenger: &challenge.ChallengeFactory{LightningNode: node}, Pending: pending}
+
+	other := service.NewService("other", servicePrice)
+	minter := auth.NewMinter(service.NewConfig(testService, other), secretStore, challenger).WithObserver(m)
+	server := proxy.L402ProxyServer{Minter: &minter, Pending: pending, Routes: []proxy.Route{route}, Metrics: m, MetricsToken: metricsToken}
+	frontend := httptest.NewServer(server.Router())
+	defer frontend.Close()
+
+	// A challenge, a paid request, and a token of another service.
+	assert.Equal(t, http.StatusPaymentRequired, getStatus(t, frontend.URL+"/api/images"))
+
+	// The settlement is labeled with the service of its challenge.
+	token := paidToken(t, &minter, testService.Id())
+	assert.Nil(t, pending.Settle(token.Preimage.Hash(), servicePrice))
+
+	for _, token := range []string{auth.NewAuthorization(token).String(), auth.NewAuthorization(paidToken(t, &minter, other.Id())).String()} {
+		req, _ := http.NewRequest(http.MethodGet, frontend.URL+"/api/images", nil)
+		req.Header.Set("Authorization", token)
+		resp, err := http.DefaultClient.Do(req)
+		assert.Nil(t, err, err)
+		resp.Body.Close()
+	}
+
+	// The metrics are not public.
+	assert.Equal(t, http.StatusUnauthorized, getStatus(t, frontend.URL+"/metrics"))
+
+	body := scrape(t, frontend.URL)
+	for _, line := range []string{
+		`l402_challenges_total{service="image:0"} 2`,
+		`l402_challenges_total{service="other:0"} 1`,
+		`l402_tokens_authorized_total{service="image:0"} 1`,
+		`l402_tokens_rejected_total{service="image:0",reason="not_granted"} 1`,
+		`l402_invoiced_sats_total{service="image:0"} 2`,
+		`l402_settled_sats_total{service="image:0"} 1`,
+		`l402_lightning_duration_seconds_count{call="create_invoice",result="ok"} 3`,
+		`l402_upstream_duration_seconds_count{service="image:0",code="418"} 1`,
+	} {
+		assert.Contains(t, body, line+"\n")
+	}
+}
+
+func TestRejectReasons(t *testing.T) {
+	minter := newMiddlewareMinter()
+	token := paidToken(t, minter, testService.Id())
+
+	forged := token
+	forged.Preimage[0] ^= 1
+
+	for header, reason := range map[string]string{
+		"Basic abc":                            metrics.ReasonMalformed,
+		auth.NewAuthorization(forged).String(): metrics.ReasonPaymentHash,
+	} {
+		_, err := middleware.Authorize(minter, header, testService.Id())
+		assert.Equal(t, reason, metrics.Reason(err), header)
+	}
+	assert.Equal(t, metrics.ReasonSignature, metrics.Reason(auth.ErrSignature))
+}
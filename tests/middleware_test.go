@@ -0,0 +1,135 @@
+package tests
+
+import (
+	"lsat/auth"
+	"lsat/macaroon"
+	"lsat/middleware"
+	l402echo "lsat/middleware/echo"
+	l402gin "lsat/middleware/gin"
+	"lsat/mock"
+	"lsat/service"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-chi/chi/v5"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+// protected answers with the service caveat of the verified token.
+var protected = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	if _, ok := middleware.TokenFromContext(r.Context()); !ok {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	for _, caveat := range middleware.CaveatsFromContext(r.Context()) {
+		if caveat.Key == macaroon.ServiceKey {
+			w.Write([]byte(caveat.Value))
+		}
+	}
+})
+
+func newMiddlewareMinter() *auth.Minter {
+	minter := auth.NewMinter(service.NewConfig(testService, service.NewService("other", servicePrice)), secretStore, mock.NewChallenger())
+	return &minter
+}
+
+// serve sends a request to a handler with an optional Authorization header.
+func serve(handler http.Handler, authorization string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/images", nil)
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+	return recorder
+}
+
+func TestMiddlewareRouters(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	minter := newMiddlewareMinter()
+	l402 := middleware.L402(minter, testService.Id())
+
+	ginRouter := gin.New()
+	ginRouter.GET("/images", l402gin.Adapt(l402), gin.WrapH(protected))
+
+	chiRouter := chi.NewRouter()
+	chiRouter.Use(l402)
+	chiRouter.Get("/images", protected)
+
+	echoRouter := echo.New()
+	echoRouter.GET("/images", echo.WrapHandler(protected), l402echo.L402(minter, testService.Id()))
+
+	routers := map[string]http.Handler{
+		"net/http": l402(protected),
+		"gin":      ginRouter,
+		"chi":      chiRouter,
+		"echo":     echoRouter,
+	}
+
+	token := paidToken(t, minter, testService.Id())
+	for name, router := range routers {
+		resp := serve(router, "")
+		assert.Equal(t, http.StatusPaymentRequired, resp.Code, name)
+		challenges, err := auth.ParseChallenges(resp.Header().Values("WWW-Authenticate")...)
+		assert.Nil(t, err, name)
+		assert.Len(t, challenges, 2, name)
+
+		resp = serve(router, auth.NewAuthorization(token).String())
+		assert.Equal(t, http.StatusOK, resp.Code, name)
+		assert.Equal(t, testService.Id().String(), resp.Body.String(), name)
+	}
+}
+
+func TestMiddlewareRejects(t *testing.T) {
+	minter := newMiddlewareMinter()
+	handler := middleware.L402(minter, testService.Id())(protected)
+
+	resp := serve(handler, "L402 abc")
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+
+	// A token of another service.
+	other := paidToken(t, minter, service.NewId("other", 0))
+	resp = serve(handler, auth.NewAuthorization(other).String())
+	assert.Equal(t, http.StatusForbidden, resp.Code)
+
+	// A token without the preimage of its payment.
+	token := paidToken(t, minter, testService.Id())
+	token.Preimage[0] ^= 1
+	resp = serve(handler, auth.NewAuthorization(token).String())
+	assert.Equal(t, http.StatusUnauthorized, resp.Code)
+}
+
+func TestMiddlewareChallengesOtherSchemes(t *testing.T) {
+	minter := newMiddlewareMinter()
+	handler := middleware.L402(minter, testService.Id())(protected)
+
+	for _, header := range []string{"Bearer abc", "Basic YWxpY2U6c2VjcmV0"} {
+		resp := serve(handler, header)
+		assert.Equal(t, http.StatusPaymentRequired, resp.Code, header)
+		challenges, err := auth.ParseChallenges(resp.Header().Values("WWW-Authenticate")...)
+		assert.Nil(t, err, header)
+		assert.Len(t, challenges, 2, header)
+	}
+}
+
+func TestMiddlewareRejectsAddedServices(t *testing.T) {
+	minter := newMiddlewareMinter()
+	handler := middleware.L402(minter, testService.Id())(protected)
+	caveat := macaroon.NewCaveat(macaroon.ServiceKey, testService.Id().String())
+
+	// A holder cannot add the service of another token.
+	other := paidToken(t, minter, service.NewId("other", 0))
+	other.Macaroon, _ = other.Macaroon.Oven().WithFirstPartyCaveats(caveat).Bake()
+	assert.Nil(t, minter.AuthToken(&other))
+	resp := serve(handler, auth.NewAuthorization(other).String())
+	assert.Equal(t, http.StatusForbidden, resp.Code)
+
+	// Repeating the service of the token keeps its access.
+	token := paidToken(t, minter, testService.Id())
+	token.Macaroon, _ = token.Macaroon.Oven().WithFirstPartyCaveats(caveat).Bake()
+	resp = serve(handler, auth.NewAuthorization(token).String())
+	assert.Equal(t, http.StatusOK, resp.Code)
+}
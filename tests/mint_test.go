@@ -53,7 +53,7 @@ func TestMintAuthToken(t *testing.T) {
 
 	t.Log(preToken.Macaroon.ToJSON())
 
-	token, err := preToken.Pay(&lightningNode)
+	token, err := preToken.PayWithPolicy(&lightningNode, challenge.InvoicePolicy{Network: mock.Network})
 
 	if err != nil {
 		t.Error(err)
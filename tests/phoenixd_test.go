@@ -0,0 +1,351 @@
+package tests
+
+import (
+	"context"
+	"fmt"
+	"lsat/amount"
+	"lsat/challenge"
+	"lsat/phoenixd"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/lightningnetwork/lnd/lntypes"
+	"github.com/stretchr/testify/assert"
+)
+
+const phoenixPassword = "secret"
+
+// phoenixStub answers every endpoint with a canned body and records the last request.
+type phoenixStub struct {
+	responses map[string]string
+	path      string
+	query     url.Values
+	form      url.Values
+}
+
+func newPhoenixStub(t *testing.T, responses map[string]string) (*phoenixStub, *phoenixd.PhoenixClient) {
+	stub := &phoenixStub{responses: responses}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, password, ok := r.BasicAuth(); !ok || password != phoenixPassword {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		r.ParseForm()
+		stub.path, stub.query, stub.form = r.URL.Path, r.URL.Query(), r.PostForm
+
+		body, ok := stub.responses[r.Method+" "+r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, body)
+	}))
+	t.Cleanup(server.Close)
+
+	return stub, phoenixd.NewPhoenixClient(server.URL, phoenixPassword)
+}
+
+func TestPhoenixGetBalance(t *testing.T) {
+	_, client := newPhoenixStub(t, map[string]string{
+		"GET /getbalance": `{"balanceSat": 1000, "feeCreditSat": 20}`,
+	})
+
+	balance, err := client.GetBalance(context.Background())
+	assert.Nil(t, err, err)
+	assert.Equal(t, uint64(1000), balance.BalanceSat)
+	assert.Equal(t, uint64(20), balance.FeeCreditSat)
+}
+
+func TestPhoenixUnauthorized(t *testing.T) {
+	_, client := newPhoenixStub(t, nil)
+	client.APIKey = "wrong"
+
+	_, err := client.GetBalance(context.Background())
+	assert.NotNil(t, err)
+}
+
+func TestPhoenixListChannels(t *testing.T) {
+	_, client := newPhoenixStub(t, map[string]string{
+		"GET /listchannels": `[{"state": "Normal", "channelId": "abc", "balanceSat": 500, "capacitySat": 1000}]`,
+	})
+
+	channels, err := client.ListChannels(context.Background())
+	assert.Nil(t, err, err)
+	assert.Len(t, channels, 1)
+	assert.Equal(t, "abc", channels[0].ChannelId)
+}
+
+func TestPhoenixCloseChannel(t *testing.T) {
+	stub, client := newPhoenixStub(t, map[string]string{
+		"POST /closechannel": "f00dtx\n",
+	})
+
+	txId, err := client.CloseChannel(context.Background(), &phoenixd.CloseChannelRequest{ChannelId: "abc", Address: "bcrt1qaddress", FeerateSatByte: 5})
+	assert.Nil(t, err, err)
+	assert.Equal(t, "f00dtx", txId)
+	assert.Equal(t, "abc", stub.form.Get("channelId"))
+	assert.Equal(t, "5", stub.form.Get("feerateSatByte"))
+}
+
+func TestPhoenixOutgoingPayment(t *testing.T) {
+	stub, client := newPhoenixStub(t, map[string]string{
+		"GET /payments/outgoing/id-1":     `{"paymentId": "id-1", "paymentHash": "aa", "isPaid": true, "sent": 10, "fees": 1500}`,
+		"GET /payments/outgoingbyhash/aa": `{"paymentId": "id-1", "paymentHash": "aa", "isPaid": true, "sent": 10, "fees": 1500}`,
+	})
+
+	payment, err := client.GetOutgoingPayment(context.Background(), "id-1")
+	assert.Nil(t, err, err)
+	assert.True(t, payment.IsPaid)
+	assert.Equal(t, 1500*amount.MilliSat, payment.Fees)
+
+	payment, err = client.GetOutgoingPaymentByHash(context.Background(), "aa")
+	assert.Nil(t, err, err)
+	assert.Equal(t, "id-1", payment.PaymentId)
+	assert.Equal(t, "/payments/outgoingbyhash/aa", stub.path)
+}
+
+func TestPhoenixListPayments(t *testing.T) {
+	stub, client := newPhoenixStub(t, map[string]string{
+		"GET /payments/incoming": `[{"paymentHash": "aa", "isPaid": true, "receivedSat": 10}]`,
+		"GET /payments/outgoing": `[{"paymentId": "id-1"}, {"paymentId": "id-2"}]`,
+	})
+
+	from := time.UnixMilli(1717920000000)
+
+	incoming, err := client.ListIncomingPayments(context.Background(), &phoenixd.ListIncomingPaymentsRequest{
+		ListPaymentsRequest: phoenixd.ListPaymentsRequest{From: from, Limit: 10, Offset: 20},
+		ExternalId:          "order & co",
+	})
+	assert.Nil(t, err, err)
+	assert.Len(t, incoming, 1)
+	assert.Equal(t, "1717920000000", stub.query.Get("from"))
+	assert.Equal(t, "10", stub.query.Get("limit"))
+	assert.Equal(t, "20", stub.query.Get("offset"))
+	assert.Equal(t, "order & co", stub.query.Get("externalId"))
+	assert.Empty(t, stub.query.Get("to"))
+
+	outgoing, err := client.ListOutgoingPayments(context.Background(), &phoenixd.ListPaymentsRequest{All: true})
+	assert.Nil(t, err, err)
+	assert.Len(t, outgoing, 2)
+	assert.Equal(t, "true", stub.query.Get("all"))
+}
+
+func TestPhoenixPayOfferAndAddress(t *testing.T) {
+	stub, client := newPhoenixStub(t, map[string]string{
+		"POST /payoffer":     `{"recipientAmountSat": 10, "paymentId": "id-1", "paymentPreimage": "bb"}`,
+		"POST /paylnaddress": `{"recipientAmountSat": 20, "paymentId": "id-2", "paymentPreimage": "cc"}`,
+	})
+
+	payment, err := client.PayOffer(context.Background(), &phoenixd.PayOfferRequest{AmountSat: 10, Offer: "lno1offer", Message: "thanks & bye"})
+	assert.Nil(t, err, err)
+	assert.Equal(t, "id-1", payment.PaymentId)
+	assert.Equal(t, "lno1offer", stub.form.Get("offer"))
+	assert.Equal(t, "thanks & bye", stub.form.Get("message"))
+
+	payment, err = client.PayLnAddress(context.Background(), &phoenixd.PayLnAddressRequest{AmountSat: 20, Address: "alice@example.com"})
+	assert.Nil(t, err, err)
+	assert.Equal(t, uint64(20), payment.RecipientAmountSat)
+	assert.Equal(t, "alice@example.com", stub.form.Get("address"))
+	assert.Equal(t, "20", stub.form.Get("amountSat"))
+}
+
+func TestPhoenixDecode(t *testing.T) {
+	stub, client := newPhoenixStub(t, map[string]string{
+		"POST /decodeinvoice": `{"chain": "regtest", "amount": 1500, "paymentHash": "aa", "description": "L402", "expirySeconds": 3600}`,
+		"POST /decodeoffer":   `{"chain": "regtest", "amount": 2000, "description": "coffee", "nodeId": "02ab"}`,
+	})
+
+	invoice, err := client.DecodeInvoice(context.Background(), "lnbcrt1invoice")
+	assert.Nil(t, err, err)
+	assert.Equal(t, 1500*amount.MilliSat, invoice.Amount)
+	assert.Equal(t, "L402", invoice.Description)
+	assert.Equal(t, "lnbcrt1invoice", stub.form.Get("invoice"))
+
+	offer, err := client.DecodeOffer(context.Background(), "lno1offer")
+	assert.Nil(t, err, err)
+	assert.Equal(t, 2*amount.Satoshi, offer.Amount)
+	assert.Equal(t, "02ab", offer.NodeId)
+}
+
+func TestPhoenixLnurl(t *testing.T) {
+	stub, client := newPhoenixStub(t, map[string]string{
+		"POST /lnurlpay":      `{"recipientAmountSat": 10, "paymentId": "id-1", "paymentPreimage": "bb"}`,
+		"POST /lnurlwithdraw": `{"url": "https://example.com", "minWithdrawable": 1000, "maxWithdrawable": 5000, "invoice": "lnbcrt1invoice"}`,
+		"POST /lnurlauth":     `authentication success`,
+	})
+
+	payment, err := client.LnurlPay(context.Background(), &phoenixd.LnurlPayRequest{AmountSat: 10, Lnurl: "lnurl1pay"})
+	assert.Nil(t, err, err)
+	assert.Equal(t, "id-1", payment.PaymentId)
+	assert.Equal(t, "lnurl1pay", stub.form.Get("lnurl"))
+
+	withdraw, err := client.LnurlWithdraw(context.Background(), "lnurl1withdraw")
+	assert.Nil(t, err, err)
+	assert.Equal(t, 5*amount.Satoshi, withdraw.MaxWithdrawable)
+
+	response, err := client.LnurlAuth(context.Background(), "lnurl1auth")
+	assert.Nil(t, err, err)
+	assert.Equal(t, "authentication success", response)
+}
+
+func TestPhoenixNodeEndpoints(t *testing.T) {
+	stub, client := newPhoenixStub(t, map[string]string{
+		"GET /estimateliquidityfees": `{"miningFeeSat": 300, "serviceFeeSat": 1000}`,
+		"GET /getoffer":              `lno1offer`,
+		"GET /getlnaddress":          `alice@example.com`,
+		"POST /sendtoaddress":        `f00dtx`,
+	})
+
+	fees, err := client.EstimateLiquidityFees(context.Background(), 100_000)
+	assert.Nil(t, err, err)
+	assert.Equal(t, uint64(1000), fees.ServiceFeeSat)
+	assert.Equal(t, "100000", stub.query.Get("amountSat"))
+
+	offer, err := client.GetOffer(context.Background())
+	assert.Nil(t, err, err)
+	assert.Equal(t, "lno1offer", offer)
+
+	address, err := client.GetLnAddress(context.Background())
+	assert.Nil(t, err, err)
+	assert.Equal(t, "alice@example.com", address)
+
+	txId, err := client.SendToAddress(context.Background(), &phoenixd.SendToAddressRequest{AmountSat: 10, Address: "bcrt1qaddress", FeerateSatByte: 2})
+	assert.Nil(t, err, err)
+	assert.Equal(t, "f00dtx", txId)
+}
+
+func TestPhoenixCreateInvoiceEncoding(t *testing.T) {
+	stub, client := newPhoenixStub(t, map[string]string{
+		"POST /createinvoice": `{"amountSat": 10, "paymentHash": "aa", "serialized": "lnbcrt1invoice"}`,
+	})
+
+	_, err := client.CreateInvoice(context.Background(), &phoenixd.CreateInvoiceRequest{Description: "tea & cake = 10", AmountSat: 10})
+	assert.Nil(t, err, err)
+	assert.Equal(t, "tea & cake = 10", stub.form.Get("description"))
+	assert.Equal(t, "10", stub.form.Get("amountSat"))
+	assert.NotContains(t, stub.form, "externalId")
+	assert.NotContains(t, stub.form, "descriptionHash")
+
+	_, err = client.CreateInvoice(context.Background(), &phoenixd.CreateInvoiceRequest{DescriptionHash: "bb", AmountSat: 10})
+	assert.Nil(t, err, err)
+	assert.Equal(t, "bb", stub.form.Get("descriptionHash"))
+	assert.NotContains(t, stub.form, "description")
+}
+
+// newFailingPhoenix answers the requests with the statuses in turn, then with the body.
+func newFailingPhoenix(t *testing.T, body string, statuses ...int) (*int, *phoenixd.PhoenixClient) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if len(statuses) >= requests {
+			w.WriteHeader(statuses[requests-1])
+		}
+		fmt.Fprint(w, body)
+	}))
+	t.Cleanup(server.Close)
+
+	client := phoenixd.NewPhoenixClient(server.URL, phoenixPassword)
+	client.RetryBackoff = time.Millisecond
+	return &requests, client
+}
+
+func TestPhoenixTypedErrors(t *testing.T) {
+	_, client := newFailingPhoenix(t, "Missing parameter amountSat\n", http.StatusBadRequest)
+
+	_, err := client.GetBalance(context.Background())
+	var phoenixErr *phoenixd.Error
+	assert.ErrorAs(t, err, &phoenixErr)
+	assert.Equal(t, http.StatusBadRequest, phoenixErr.StatusCode)
+	assert.Equal(t, "Missing parameter amountSat", phoenixErr.Message)
+	assert.Empty(t, phoenixErr.Code)
+
+	_, client = newFailingPhoenix(t, `{"code": "insufficient_funds", "message": "not enough liquidity"}`, http.StatusBadRequest)
+
+	_, err = client.GetBalance(context.Background())
+	assert.ErrorAs(t, err, &phoenixErr)
+	assert.Equal(t, "insufficient_funds", phoenixErr.Code)
+	assert.Equal(t, "not enough liquidity", phoenixErr.Message)
+}
+
+func TestPhoenixPaymentFailure(t *testing.T) {
+	_, client := newFailingPhoenix(t, `{"type": "payment_failed", "reason": "route not found"}`)
+
+	_, err := client.PayInvoice(context.Background(), &phoenixd.PayInvoiceRequest{Invoice: "lnbcrt1invoice"})
+	var phoenixErr *phoenixd.Error
+	assert.ErrorAs(t, err, &phoenixErr)
+	assert.Equal(t, phoenixd.PaymentFailedCode, phoenixErr.Code)
+	assert.Equal(t, "route not found", phoenixErr.Message)
+}
+
+func TestPhoenixRetries(t *testing.T) {
+	// Transient failures of GET requests are retried.
+	requests, client := newFailingPhoenix(t, `{"balanceSat": 1000}`, http.StatusServiceUnavailable, http.StatusBadGateway)
+	balance, err := client.GetBalance(context.Background())
+	assert.Nil(t, err, err)
+	assert.Equal(t, uint64(1000), balance.BalanceSat)
+	assert.Equal(t, 3, *requests)
+
+	// Client errors are not.
+	requests, client = newFailingPhoenix(t, `not found`, http.StatusNotFound)
+	_, err = client.GetIncomingPayment(context.Background(), "aa")
+	assert.NotNil(t, err)
+	assert.Equal(t, 1, *requests)
+
+	// Neither are POST requests.
+	requests, client = newFailingPhoenix(t, `{}`, http.StatusServiceUnavailable)
+	_, err = client.CreateInvoice(context.Background(), &phoenixd.CreateInvoiceRequest{AmountSat: 10})
+	assert.NotNil(t, err)
+	assert.Equal(t, 1, *requests)
+
+	// The retries are bounded.
+	requests, client = newFailingPhoenix(t, `down`, 500, 500, 500, 500, 500, 500)
+	client.Retries = 2
+	_, err = client.GetInfo(context.Background())
+	assert.NotNil(t, err)
+	assert.Equal(t, 3, *requests)
+
+	// The retries stop with the context.
+	requests, client = newFailingPhoenix(t, `down`, 500, 500, 500, 500)
+	client.RetryBackoff = time.Hour
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err = client.GetInfo(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Equal(t, 1, *requests)
+}
+
+func TestPhoenixNodeRejectsInvalidResponses(t *testing.T) {
+	_, client := newPhoenixStub(t, map[string]string{
+		"POST /createinvoice": `{"amountSat": 10, "paymentHash": "not a hash", "serialized": "lnbcrt1invoice"}`,
+		"POST /payinvoice":    `{"paymentId": "id-1", "paymentHash": "aa", "paymentPreimage": "bb"}`,
+	})
+	node := phoenixd.PhoenixNode{Client: client}
+
+	_, err := node.CreateInvoice(context.Background(), challenge.CreateInvoiceRequest{Amount: 10 * amount.Satoshi})
+	assert.ErrorContains(t, err, "invalid payment hash")
+
+	_, err = node.PayInvoice(context.Background(), challenge.PayInvoiceRequest{Invoice: "lnbcrt1invoice"})
+	assert.ErrorContains(t, err, "invalid payment hash")
+}
+
+func TestPhoenixNodePaysExactAmounts(t *testing.T) {
+	preimage := lntypes.Preimage{1}
+	stub, client := newPhoenixStub(t, map[string]string{
+		"POST /payinvoice": `{"paymentId": "id-1", "paymentHash": "` + preimage.Hash().String() + `", "paymentPreimage": "` + preimage.String() + `"}`,
+	})
+	node := phoenixd.PhoenixNode{Client: client}
+
+	// The invoices are created rounded up, but never paid rounded up.
+	_, err := node.PayInvoice(context.Background(), challenge.PayInvoiceRequest{Invoice: "lnbcrt1invoice", Amount: 1500})
+	assert.ErrorIs(t, err, amount.ErrNotWholeSatoshi)
+	assert.Empty(t, stub.path)
+
+	_, err = node.PayInvoice(context.Background(), challenge.PayInvoiceRequest{Invoice: "lnbcrt1invoice", Amount: 2 * amount.Satoshi})
+	assert.Nil(t, err, err)
+	assert.Equal(t, "2", stub.form.Get("amountSat"))
+}
@@ -0,0 +1,177 @@
+package tests
+
+import (
+	"encoding/json"
+	"fmt"
+	"lsat/auth"
+	"lsat/challenge"
+	"lsat/macaroon"
+	"lsat/middleware"
+	"lsat/mock"
+	"lsat/problem"
+	"lsat/proxy"
+	"lsat/service"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+// readProblem decodes the problem of a response.
+func readProblem(t *testing.T, resp *httptest.ResponseRecorder) problem.Problem {
+	assert.Equal(t, problem.ContentType, resp.Header().Get("Content-Type"))
+	var p problem.Problem
+	assert.Nil(t, json.NewDecoder(resp.Body).Decode(&p))
+	assert.Equal(t, resp.Code, p.Status)
+	return p
+}
+
+// decodeProblem decodes the problem of a response of a server.
+func decodeProblem(t *testing.T, resp *http.Response) problem.Problem {
+	assert.Equal(t, problem.ContentType, resp.Header.Get("Content-Type"))
+	var p problem.Problem
+	assert.Nil(t, json.NewDecoder(resp.Body).Decode(&p))
+	assert.Equal(t, resp.StatusCode, p.Status)
+	return p
+}
+
+func TestProblemFrom(t *testing.T) {
+	problems := []struct {
+		err    error
+		status int
+		code   string
+	}{
+		{fmt.Errorf("%w: no token", auth.ErrInvalidAuth), http.StatusBadRequest, problem.CodeMalformed},
+		{auth.ErrSignature, http.StatusUnauthorized, problem.CodeBadSignature},
+		{auth.ErrPaymentHash, http.StatusUnauthorized, problem.CodeUnpaid},
+		{fmt.Errorf("%w: at noon", service.ErrExpired), http.StatusUnauthorized, problem.CodeExpired},
+		{service.ErrRevoked, http.StatusUnauthorized, problem.CodeRevoked},
+		{fmt.Errorf("%w: image:0", auth.ErrNotGranted), http.StatusForbidden, problem.CodeWrongService},
+		{service.ErrQuotaExhausted, http.StatusTooManyRequests, problem.CodeQuotaExhausted},
+		{fmt.Errorf("%w: %w", auth.ErrChallenge, mock.ErrInjectedFault), http.StatusServiceUnavailable, problem.CodeChallengeFailed},
+		{fmt.Errorf("disk full"), http.StatusInternalServerError, problem.CodeInternal},
+	}
+	for _, p := range problems {
+		got := problem.From(p.err)
+		assert.Equal(t, p.status, got.Status, p.err.Error())
+		assert.Equal(t, p.code, got.Code, p.err.Error())
+		assert.Equal(t, "urn:l402:problem:"+p.code, got.Type)
+	}
+
+	// The details of the internal errors are not sent.
+	assert.Empty(t, problem.From(fmt.Errorf("disk full")).Detail)
+}
+
+func TestMiddlewareProblems(t *testing.T) {
+	image := service.NewService(serviceName, servicePrice)
+	image.FirstPartyCaveats = []service.Caveat{service.Expire{Delay: time.Hour}}
+	image.Conditions = []service.Condition{service.Expire{}}
+	config := service.NewConfig(image)
+	minter := auth.NewMinter(config, secretStore, mock.NewChallenger())
+	handler := middleware.L402(&minter, image.Id())(protected)
+
+	resp := serve(handler, "")
+	assert.Equal(t, http.StatusPaymentRequired, resp.Code)
+	assert.Equal(t, problem.CodePaymentRequired, readProblem(t, resp).Code)
+
+	// The token expires in an hour.
+	token := paidToken(t, &minter, image.Id())
+	config.Clock = func() time.Time { return time.Now().Add(2 * time.Hour) }
+	resp = serve(handler, auth.NewAuthorization(token).String())
+	assert.Equal(t, http.StatusUnauthorized, resp.Code)
+	assert.Equal(t, problem.CodeExpired, readProblem(t, resp).Code)
+
+	// The challenge fails with the Lightning node.
+	node := mock.NewFaultyLightningNode(mock.NewLightningNode(0), mock.FaultConfig{ErrorRate: 1})
+	faulty := auth.NewMinter(config, secretStore, &challenge.ChallengeFactory{LightningNode: node})
+	resp = serve(middleware.L402(&faulty, image.Id())(protected), "")
+	assert.Equal(t, http.StatusServiceUnavailable, resp.Code)
+	assert.Equal(t, problem.CodeChallengeFailed, readProblem(t, resp).Code)
+}
+
+func TestProxyProblems(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	minter := newMiddlewareMinter()
+	server := proxy.L402ProxyServer{Minter: minter}
+	router := server.Router()
+
+	request := func(method, path, authorization string) problem.Problem {
+		req := httptest.NewRequest(method, path, nil)
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		return readProblem(t, resp)
+	}
+
+	assert.Equal(t, problem.CodeInvalidServiceID, request(http.MethodPut, "/service/image", "").Code)
+	assert.Equal(t, problem.CodeUnknownService, request(http.MethodPut, "/service/video:0", "").Code)
+	assert.Equal(t, problem.CodeMalformed, request(http.MethodGet, "/service/image:0", "Bearer abc").Code)
+
+	other := paidToken(t, minter, service.NewId("other", 0))
+	assert.Equal(t, problem.CodeWrongService, request(http.MethodGet, "/service/image:0", auth.NewAuthorization(other).String()).Code)
+
+	token := paidToken(t, minter, testService.Id())
+	token.Macaroon, _ = token.Macaroon.Oven().WithFirstPartyCaveats(macaroon.NewCaveat(macaroon.MethodKey, "GET")).Bake()
+	assert.Equal(t, problem.CodeRequestDenied, request(http.MethodPost, "/service/image:0", auth.NewAuthorization(token).String()).Code)
+}
+
+func TestConditionProblems(t *testing.T) {
+	image := service.NewService(serviceName, servicePrice)
+	image.FirstPartyCaveats = []service.Caveat{service.GenerateID{Name: "token_id"}}
+	quota := &service.Quota{Key: "token_id", Limit: 2}
+	revoked := service.Revoked{Key: "token_id"}
+	image.Conditions = []service.Condition{&revoked, quota}
+	minter := auth.NewMinter(service.NewConfig(image), secretStore, mock.NewChallenger())
+	handler := middleware.L402(&minter, image.Id())(protected)
+
+	// The token is accepted as many times as its quota.
+	token := paidToken(t, &minter, image.Id())
+	authorization := auth.NewAuthorization(token).String()
+	assert.Equal(t, http.StatusOK, serve(handler, authorization).Code)
+	assert.Equal(t, http.StatusOK, serve(handler, authorization).Code)
+	resp := serve(handler, authorization)
+	assert.Equal(t, http.StatusTooManyRequests, resp.Code)
+	assert.Equal(t, problem.CodeQuotaExhausted, readProblem(t, resp).Code)
+
+	// The token is rejected once revoked.
+	id := token.Macaroon.GetValue("token_id")
+	revoked.Values = append(revoked.Values, id.Next())
+	resp = serve(handler, authorization)
+	assert.Equal(t, http.StatusUnauthorized, resp.Code)
+	assert.Equal(t, problem.CodeRevoked, readProblem(t, resp).Code)
+}
+
+func TestCallbackProblems(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	image := service.NewService(serviceName, servicePrice)
+	image.Get = func(any) error { return fmt.Errorf("%w: 3 reads a day", service.ErrQuotaExhausted) }
+	image.Post = func(any) error { return fmt.Errorf("disk full") }
+	minter := auth.NewMinter(service.NewConfig(image), secretStore, mock.NewChallenger())
+	server := proxy.L402ProxyServer{Minter: &minter}
+	router := server.Router()
+	authorization := auth.NewAuthorization(paidToken(t, &minter, image.Id())).String()
+
+	request := func(method string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/service/"+image.Id().String(), nil)
+		req.Header.Set("Authorization", authorization)
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		return resp
+	}
+
+	// The errors of the callbacks are answered with their problems.
+	resp := request(http.MethodGet)
+	assert.Equal(t, http.StatusTooManyRequests, resp.Code)
+	assert.Equal(t, problem.CodeQuotaExhausted, readProblem(t, resp).Code)
+
+	resp = request(http.MethodPost)
+	assert.Equal(t, http.StatusInternalServerError, resp.Code)
+	p := readProblem(t, resp)
+	assert.Equal(t, problem.CodeInternal, p.Code)
+	assert.Empty(t, p.Detail)
+}
@@ -0,0 +1,90 @@
+package tests
+
+import (
+	"fmt"
+	"lsat/auth"
+	"lsat/mock"
+	"lsat/proxy"
+	"lsat/service"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+// callService sends a request to the callbacks of a service on the proxy.
+func callService(router http.Handler, method string, id service.ServiceID, authorization string) int {
+	req := httptest.NewRequest(method, "/service/"+id.String(), nil)
+	req.Header.Set("Authorization", authorization)
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	return resp.Code
+}
+
+func TestProxyCallbacks(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	var calls []string
+	image := service.NewService(serviceName, servicePrice)
+	image.Get = func(any) error { calls = append(calls, "get"); return nil }
+	image.Post = func(any) error { calls = append(calls, "post"); return nil }
+	updates := service.NewService("updates", servicePrice)
+	updates.Post = func(any) error { calls = append(calls, "updates"); return nil }
+
+	minter := auth.NewMinter(service.NewConfig(image, updates), secretStore, mock.NewChallenger())
+	server := proxy.L402ProxyServer{Minter: &minter}
+	router := server.Router()
+
+	// The updates run the Post callback, even without a Get callback.
+	authorization := auth.NewAuthorization(paidToken(t, &minter, image.Id())).String()
+	assert.Equal(t, http.StatusOK, callService(router, http.MethodGet, image.Id(), authorization))
+	assert.Equal(t, http.StatusOK, callService(router, http.MethodPost, image.Id(), authorization))
+
+	authorization = auth.NewAuthorization(paidToken(t, &minter, updates.Id())).String()
+	assert.Equal(t, http.StatusOK, callService(router, http.MethodPost, updates.Id(), authorization))
+	assert.Equal(t, []string{"get", "post", "updates"}, calls)
+}
+
+func TestProxyCallbacksRequireTheService(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	called := false
+	image := service.NewService(serviceName, servicePrice)
+	image.Get = func(any) error { called = true; return nil }
+	image.Post = func(any) error { called = true; return nil }
+	other := service.NewService("other", servicePrice)
+
+	minter := auth.NewMinter(service.NewConfig(image, other), secretStore, mock.NewChallenger())
+	server := proxy.L402ProxyServer{Minter: &minter}
+	router := server.Router()
+
+	// A valid token of another service does not run the callbacks.
+	authorization := auth.NewAuthorization(paidToken(t, &minter, other.Id())).String()
+	assert.Equal(t, http.StatusForbidden, callService(router, http.MethodGet, image.Id(), authorization))
+	assert.Equal(t, http.StatusForbidden, callService(router, http.MethodPost, image.Id(), authorization))
+	assert.False(t, called)
+}
+
+func TestProxyCallbacksReleaseTokens(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	failing := true
+	inference := service.NewService("inference", servicePrice)
+	inference.PerRequest = true
+	inference.Post = func(any) error {
+		if failing {
+			return fmt.Errorf("the model is unavailable")
+		}
+		return nil
+	}
+
+	minter := auth.NewMinter(service.NewConfig(inference), secretStore, mock.NewChallenger())
+	server := proxy.L402ProxyServer{Minter: &minter}
+	router := server.Router()
+
+	// A token sold per request is given back when the callback fails.
+	authorization := auth.NewAuthorization(paidToken(t, &minter, inference.Id())).String()
+	assert.Equal(t, http.StatusInternalServerError, callService(router, http.MethodPost, inference.Id(), authorization))
+	failing = false
+	assert.Equal(t, http.StatusOK, callService(router, http.MethodPost, inference.Id(), authorization))
+	assert.Equal(t, http.StatusPaymentRequired, callService(router, http.MethodPost, inference.Id(), authorization))
+}
@@ -0,0 +1,184 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"lsat/amount"
+	"lsat/auth"
+	"lsat/macaroon"
+	"lsat/mock"
+	"lsat/rates"
+	"lsat/service"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// countingProvider counts the queries and fails when err is set.
+type countingProvider struct {
+	rate  rates.Rate
+	err   error
+	calls int
+}
+
+func (p *countingProvider) Rate(_ context.Context, currency rates.Currency) (rates.Rate, error) {
+	p.calls++
+	if p.err != nil {
+		return rates.Rate{}, p.err
+	}
+	return p.rate, nil
+}
+
+func TestToMilliSatoshis(t *testing.T) {
+	rate := rates.Rate{Currency: rates.USD, Price: 50000}
+
+	// 1 USD at 50000 USD/BTC is 2000 sats.
+	price, err := rate.ToMilliSatoshis(100)
+	assert.Nil(t, err, err)
+	assert.Equal(t, 2000*amount.Satoshi, price)
+
+	// The conversion is rounded up to the millisatoshi.
+	price, _ = rates.Rate{Currency: rates.USD, Price: 30000}.ToMilliSatoshis(1)
+	assert.Equal(t, 33334*amount.MilliSat, price)
+
+	// JPY has no minor unit.
+	price, _ = rates.Rate{Currency: rates.JPY, Price: 10_000_000}.ToMilliSatoshis(100)
+	assert.Equal(t, 1000*amount.Satoshi, price)
+
+	_, err = rates.Rate{Currency: rates.USD}.ToMilliSatoshis(100)
+	assert.ErrorIs(t, err, rates.ErrInvalidRate)
+}
+
+func TestRateEncoding(t *testing.T) {
+	rate := rates.Rate{
+		Currency:  rates.EUR,
+		Price:     61234.56,
+		Timestamp: time.Date(2024, 6, 9, 9, 21, 20, 0, time.UTC),
+	}
+
+	t.Log(rate)
+
+	parsed, err := rates.ParseRate(rate.String())
+	assert.Nil(t, err, err)
+	assert.Equal(t, rate, parsed)
+
+	_, err = rates.ParseRate("61234.56 EUR")
+	assert.NotNil(t, err)
+}
+
+func TestFileProvider(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rates.json")
+	err := os.WriteFile(path, []byte(`{"USD": 65000.5, "EUR": 60000}`), 0644)
+	assert.Nil(t, err, err)
+
+	provider := rates.NewFileProvider(path)
+
+	rate, err := provider.Rate(context.Background(), rates.USD)
+	assert.Nil(t, err, err)
+	assert.Equal(t, 65000.5, rate.Price)
+	assert.Equal(t, rates.USD, rate.Currency)
+	assert.False(t, rate.Timestamp.IsZero())
+
+	_, err = provider.Rate(context.Background(), rates.GBP)
+	assert.ErrorIs(t, err, rates.ErrUnsupportedCurrency)
+}
+
+func TestHTTPProvider(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/prices/BTC-USD/spot" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, `{"data": {"base": "BTC", "currency": "USD", "amount": "64123.45"}}`)
+	}))
+	defer server.Close()
+
+	provider := rates.NewHTTPProvider(server.URL+"/prices/BTC-{currency}/spot", "data.amount")
+
+	rate, err := provider.Rate(context.Background(), rates.USD)
+	assert.Nil(t, err, err)
+	assert.Equal(t, 64123.45, rate.Price)
+
+	_, err = provider.Rate(context.Background(), rates.EUR)
+	assert.NotNil(t, err, "The stub does not quote EUR")
+}
+
+func TestCachedProvider(t *testing.T) {
+	now := time.Now()
+	source := &countingProvider{rate: rates.Rate{Currency: rates.USD, Price: 50000, Timestamp: now}}
+
+	provider := rates.NewCachedProvider(source, time.Minute, time.Hour)
+	provider.Clock = func() time.Time { return now }
+
+	_, err := provider.Rate(context.Background(), rates.USD)
+	assert.Nil(t, err, err)
+	_, err = provider.Rate(context.Background(), rates.USD)
+	assert.Nil(t, err, err)
+	assert.Equal(t, 1, source.calls, "The second rate should be cached")
+
+	// After the TTL, a failing source falls back on the cached rate.
+	now = now.Add(10 * time.Minute)
+	source.err = errors.New("unavailable")
+
+	rate, err := provider.Rate(context.Background(), rates.USD)
+	assert.Nil(t, err, err)
+	assert.Equal(t, 50000.0, rate.Price)
+	assert.Equal(t, 2, source.calls)
+
+	// After the staleness limit, the cached rate is rejected.
+	now = now.Add(2 * time.Hour)
+
+	_, err = provider.Rate(context.Background(), rates.USD)
+	assert.ErrorIs(t, err, rates.ErrStaleRate)
+}
+
+func TestCachedProviderStaleSource(t *testing.T) {
+	source := &countingProvider{rate: rates.Rate{Currency: rates.USD, Price: 50000, Timestamp: time.Now().Add(-2 * time.Hour)}}
+
+	provider := rates.NewCachedProvider(source, time.Minute, time.Hour)
+
+	_, err := provider.Rate(context.Background(), rates.USD)
+	assert.ErrorIs(t, err, rates.ErrStaleRate)
+}
+
+func TestMintFiatService(t *testing.T) {
+	fiatService := service.NewService(serviceName, 0)
+	fiatService.FiatPrice = rates.Price{Amount: 100, Currency: rates.USD}
+
+	config := service.NewConfig(fiatService)
+	source := &countingProvider{rate: rates.Rate{Currency: rates.USD, Price: 50000, Timestamp: time.Now()}}
+
+	minter := auth.NewMinter(config, secretStore, mock.NewChallenger()).WithRateProvider(source)
+
+	preToken, err := minter.MintToken(secretStore.NewUser(), fiatService.Id())
+	assert.Nil(t, err, err)
+
+	t.Log(preToken.Macaroon.ToJSON())
+
+	iter := preToken.Macaroon.GetValue(macaroon.ExchangeRateKey)
+	assert.True(t, iter.HasNext(), "The quoted rate should be recorded in a caveat")
+
+	rate, err := rates.ParseRate(iter.Next())
+	assert.Nil(t, err, err)
+	assert.Equal(t, 50000.0, rate.Price)
+	assert.Equal(t, 2000*amount.Satoshi, preToken.InvoiceResponse.Amount)
+
+	err = minter.AuthMacaroon(&preToken.Macaroon)
+	assert.Nil(t, err, err)
+}
+
+func TestMintFiatServiceWithoutProvider(t *testing.T) {
+	fiatService := service.NewService(serviceName, 0)
+	fiatService.FiatPrice = rates.Price{Amount: 100, Currency: rates.USD}
+
+	minter := auth.NewMinter(service.NewConfig(fiatService), secretStore, mock.NewChallenger())
+
+	_, err := minter.MintToken(secretStore.NewUser(), fiatService.Id())
+	assert.NotNil(t, err, "A fiat price cannot be converted without a provider")
+}
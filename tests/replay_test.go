@@ -0,0 +1,221 @@
+package tests
+
+import (
+	"lsat/auth"
+	"lsat/macaroon"
+	"lsat/middleware"
+	l402echo "lsat/middleware/echo"
+	l402gin "lsat/middleware/gin"
+	"lsat/mock"
+	"lsat/service"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/labstack/echo/v4"
+	"github.com/lightningnetwork/lnd/lntypes"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPerRequestTokens(t *testing.T) {
+	inference := service.NewService("inference", servicePrice)
+	inference.PerRequest = true
+	minter := auth.NewMinter(service.NewConfig(testService, inference), secretStore, mock.NewChallenger())
+
+	// A token sold per request is accepted once, then challenged again.
+	l402 := middleware.L402(&minter, inference.Id())(protected)
+	authorization := auth.NewAuthorization(paidToken(t, &minter, inference.Id())).String()
+	assert.Equal(t, http.StatusOK, serve(l402, authorization).Code)
+
+	replayed := serve(l402, authorization)
+	assert.Equal(t, http.StatusPaymentRequired, replayed.Code)
+	_, err := auth.ParseChallenges(replayed.Header().Values("WWW-Authenticate")...)
+	assert.Nil(t, err, err)
+
+	// The other tokens are reusable.
+	l402 = middleware.L402(&minter, testService.Id())(protected)
+	authorization = auth.NewAuthorization(paidToken(t, &minter, testService.Id())).String()
+	assert.Equal(t, http.StatusOK, serve(l402, authorization).Code)
+	assert.Equal(t, http.StatusOK, serve(l402, authorization).Code)
+}
+
+func TestInvalidTokensAreNotSpent(t *testing.T) {
+	inference := service.NewService("inference", servicePrice)
+	inference.PerRequest = true
+	minter := auth.NewMinter(service.NewConfig(testService, inference), secretStore, mock.NewChallenger())
+
+	// A token presented for another service is rejected without being spent.
+	token := paidToken(t, &minter, inference.Id())
+	_, err := middleware.Authorize(&minter, auth.NewAuthorization(token).String(), testService.Id())
+	assert.ErrorIs(t, err, auth.ErrNotGranted)
+	assert.Nil(t, minter.Redeem(&token))
+	assert.ErrorIs(t, minter.Redeem(&token), auth.ErrSpent)
+}
+
+func TestFileReplayStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "spent")
+	first, second := lntypes.Preimage{1}, lntypes.Preimage{2}
+	hash := first.Hash()
+
+	store, err := auth.OpenFileReplayStore(path)
+	assert.Nil(t, err, err)
+	fresh, err := store.Spend(hash, time.Time{})
+	assert.Nil(t, err, err)
+	assert.True(t, fresh)
+	fresh, _ = store.Spend(hash, time.Time{})
+	assert.False(t, fresh)
+
+	// The spent hashes are kept across restarts.
+	store, err = auth.OpenFileReplayStore(path)
+	assert.Nil(t, err, err)
+	fresh, _ = store.Spend(hash, time.Time{})
+	assert.False(t, fresh)
+	fresh, _ = store.Spend(second.Hash(), time.Time{})
+	assert.True(t, fresh)
+
+	assert.Nil(t, os.WriteFile(path, []byte(hash.String()+"\nnot a hash\n"), 0600))
+	_, err = auth.OpenFileReplayStore(path)
+	assert.ErrorContains(t, err, path+":2")
+}
+
+func TestReplayStoreForgetsExpiredTokens(t *testing.T) {
+	now := time.Now()
+	store := auth.NewMemoryReplayStore()
+	store.Clock = func() time.Time { return now }
+	expiring, lasting := lntypes.Hash{1}, lntypes.Hash{2}
+
+	fresh, _ := store.Spend(expiring, now.Add(time.Minute))
+	assert.True(t, fresh)
+	fresh, _ = store.Spend(lasting, time.Time{})
+	assert.True(t, fresh)
+
+	// The hashes are forgotten once their tokens expire, the others are kept.
+	now = now.Add(2 * time.Minute)
+	fresh, _ = store.Spend(expiring, time.Time{})
+	assert.True(t, fresh)
+	fresh, _ = store.Spend(lasting, time.Time{})
+	assert.False(t, fresh)
+
+	// A released hash can be spent again.
+	assert.Nil(t, store.Release(lasting))
+	fresh, _ = store.Spend(lasting, time.Time{})
+	assert.True(t, fresh)
+}
+
+func TestFileReplayStoreExpiryAndRelease(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "spent")
+	expired, released, kept := lntypes.Hash{1}, lntypes.Hash{2}, lntypes.Hash{3}
+
+	store, err := auth.OpenFileReplayStore(path)
+	assert.Nil(t, err, err)
+	_, err = store.Spend(expired, time.Now().Add(-time.Second))
+	assert.Nil(t, err, err)
+	_, err = store.Spend(released, time.Now().Add(time.Hour))
+	assert.Nil(t, err, err)
+	_, err = store.Spend(kept, time.Now().Add(time.Hour))
+	assert.Nil(t, err, err)
+	assert.Nil(t, store.Release(released))
+
+	// The expired and released hashes are not loaded again.
+	store, err = auth.OpenFileReplayStore(path)
+	assert.Nil(t, err, err)
+	fresh, _ := store.Spend(expired, time.Time{})
+	assert.True(t, fresh)
+	fresh, _ = store.Spend(released, time.Time{})
+	assert.True(t, fresh)
+	fresh, _ = store.Spend(kept, time.Time{})
+	assert.False(t, fresh)
+}
+
+func TestPerRequestTokensReleasedOnUpstreamErrors(t *testing.T) {
+	inference := service.NewService("inference", servicePrice)
+	inference.PerRequest = true
+	minter := auth.NewMinter(service.NewConfig(inference), secretStore, mock.NewChallenger())
+
+	status := http.StatusBadGateway
+	l402 := middleware.L402(&minter, inference.Id())(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	}))
+	authorization := auth.NewAuthorization(paidToken(t, &minter, inference.Id())).String()
+
+	// The token is given back when the upstream fails, and spent when it answers.
+	assert.Equal(t, http.StatusBadGateway, serve(l402, authorization).Code)
+	status = http.StatusOK
+	assert.Equal(t, http.StatusOK, serve(l402, authorization).Code)
+	assert.Equal(t, http.StatusPaymentRequired, serve(l402, authorization).Code)
+}
+
+func TestExpiryOfPerRequestTokens(t *testing.T) {
+	now := time.Now()
+	expiring := service.NewService("inference", servicePrice)
+	expiring.PerRequest = true
+	expiring.FirstPartyCaveats = []service.Caveat{service.Expire{Delay: time.Hour}}
+	expiring.Conditions = []service.Condition{service.Expire{}}
+	lasting := service.NewService("lasting", servicePrice)
+	lasting.PerRequest = true
+
+	// redeem spends a token of a service, and returns whether it is still spent at a time.
+	redeem := func(s service.Service) func(at time.Time) bool {
+		store := auth.NewMemoryReplayStore()
+		minter := auth.NewMinter(service.NewConfig(s), secretStore, mock.NewChallenger()).WithReplayStore(store)
+		token := paidToken(t, &minter, s.Id())
+
+		// The expiries added by the holder are ignored.
+		added := macaroon.NewCaveat(macaroon.ExpiryDateKey, now.Add(time.Minute).Format(time.RFC3339))
+		token.Macaroon, _ = token.Macaroon.Oven().WithFirstPartyCaveats(added).Bake()
+		assert.Nil(t, minter.Redeem(&token))
+
+		return func(at time.Time) bool {
+			store.Clock = func() time.Time { return at }
+			fresh, _ := store.Spend(token.Preimage.Hash(), time.Time{})
+			return !fresh
+		}
+	}
+
+	// The token is spent until the expiry issued by the minter, when its service enforces it.
+	spent := redeem(expiring)
+	assert.True(t, spent(now.Add(10*time.Minute)))
+	assert.False(t, spent(now.Add(2*time.Hour)))
+
+	spent = redeem(lasting)
+	assert.True(t, spent(now.Add(2*time.Hour)))
+}
+
+func TestPerRequestTokensReleasedByAdapters(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	inference := service.NewService("inference", servicePrice)
+	inference.PerRequest = true
+	minter := auth.NewMinter(service.NewConfig(inference), secretStore, mock.NewChallenger())
+
+	failing := true
+	ginRouter := gin.New()
+	ginRouter.GET("/images", l402gin.L402(&minter, inference.Id()), func(c *gin.Context) {
+		if failing {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "upstream"})
+			return
+		}
+		c.String(http.StatusOK, "ok")
+	})
+
+	echoRouter := echo.New()
+	echoRouter.GET("/images", func(c echo.Context) error {
+		if failing {
+			return echo.NewHTTPError(http.StatusBadGateway)
+		}
+		return c.String(http.StatusOK, "ok")
+	}, l402echo.L402(&minter, inference.Id()))
+
+	for name, router := range map[string]http.Handler{"gin": ginRouter, "echo": echoRouter} {
+		authorization := auth.NewAuthorization(paidToken(t, &minter, inference.Id())).String()
+
+		// The token is given back when the handler fails, and spent when it answers.
+		failing = true
+		assert.GreaterOrEqual(t, serve(router, authorization).Code, http.StatusInternalServerError, name)
+		failing = false
+		assert.Equal(t, http.StatusOK, serve(router, authorization).Code, name)
+		assert.Equal(t, http.StatusPaymentRequired, serve(router, authorization).Code, name)
+	}
+}
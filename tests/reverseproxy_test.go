@@ -0,0 +1,204 @@
+package tests
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"lsat/amount"
+	"lsat/auth"
+	"lsat/challenge"
+	"lsat/macaroon"
+	"lsat/mock"
+	"lsat/problem"
+	"lsat/proxy"
+	"lsat/service"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+// newReverseProxy serves a proxy forwarding /api to the upstream handler, for the test service.
+func newReverseProxy(t *testing.T, upstream http.Handler, configure func(*proxy.Route)) (*auth.Minter, string) {
+	gin.SetMode(gin.TestMode)
+
+	backend := httptest.NewServer(upstream)
+	t.Cleanup(backend.Close)
+
+	route, err := proxy.NewRoute(testService.Id(), "/api", backend.URL+"/v1")
+	assert.Nil(t, err, err)
+	if configure != nil {
+		configure(&route)
+	}
+
+	minter := auth.NewMinter(service.NewConfig(testService, service.NewService("other", servicePrice)), secretStore, mock.NewChallenger())
+	server := proxy.L402ProxyServer{Minter: &minter, Routes: []proxy.Route{route}}
+
+	frontend := httptest.NewServer(server.Router())
+	t.Cleanup(frontend.Close)
+	return &minter, frontend.URL
+}
+
+// paidToken mints and pays a token for a service.
+func paidToken(t *testing.T, minter *auth.Minter, id service.ServiceID) macaroon.Token {
+	preToken, err := minter.MintToken(secretStore.NewUser(), id)
+	assert.Nil(t, err, err)
+
+	token, err := preToken.PayWithPolicy(mock.NewLightningNode(10*amount.Satoshi), challenge.InvoicePolicy{Network: mock.Network})
+	assert.Nil(t, err, err)
+	return token
+}
+
+func TestNewRoute(t *testing.T) {
+	route, err := proxy.NewRoute(testService.Id(), "/api/", "http://localhost:3000")
+	assert.Nil(t, err, err)
+	assert.Equal(t, "/api", route.PathPrefix)
+
+	_, err = proxy.NewRoute(testService.Id(), "/", "http://localhost:3000")
+	assert.NotNil(t, err)
+	_, err = proxy.NewRoute(testService.Id(), "api", "http://localhost:3000")
+	assert.NotNil(t, err)
+	_, err = proxy.NewRoute(testService.Id(), "/api", "localhost:3000")
+	assert.NotNil(t, err)
+}
+
+func TestReverseProxyChallenges(t *testing.T) {
+	forwarded := false
+	_, frontend := newReverseProxy(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwarded = true
+	}), nil)
+
+	resp, err := http.Get(frontend + "/api/images")
+	assert.Nil(t, err, err)
+	resp.Body.Close()
+
+	assert.Equal(t, http.StatusPaymentRequired, resp.StatusCode)
+	_, err = auth.ParseChallenges(resp.Header.Values("WWW-Authenticate")...)
+	assert.Nil(t, err, err)
+	assert.False(t, forwarded)
+}
+
+func TestReverseProxyForwards(t *testing.T) {
+	var received *http.Request
+	var body string
+	minter, frontend := newReverseProxy(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r
+		content, _ := io.ReadAll(r.Body)
+		body = string(content)
+		w.Header().Set("X-Upstream", "yes")
+		w.WriteHeader(http.StatusCreated)
+	}), func(route *proxy.Route) {
+		route.Headers = http.Header{"X-Api-Key": {"backend-secret"}}
+	})
+
+	token := paidToken(t, minter, testService.Id())
+
+	req, _ := http.NewRequest(http.MethodPost, frontend+"/api/images/1?size=large", strings.NewReader("payload"))
+	req.Header.Set("Authorization", auth.NewAuthorization(token).String())
+	req.Header.Set("X-L402-Service", "spoofed")
+	req.Header.Set("X-Forwarded-For", "1.2.3.4")
+	resp, err := http.DefaultClient.Do(req)
+	assert.Nil(t, err, err)
+	resp.Body.Close()
+
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	assert.Equal(t, "yes", resp.Header.Get("X-Upstream"))
+	assert.Equal(t, "/v1/images/1", received.URL.Path)
+	assert.Equal(t, "size=large", received.URL.RawQuery)
+	assert.Equal(t, "payload", body)
+
+	// The credentials and the spoofed headers are not forwarded.
+	assert.Empty(t, received.Header.Get("Authorization"))
+	assert.Equal(t, testService.Id().String(), received.Header.Get(proxy.ServiceHeader))
+	assert.Equal(t, "127.0.0.1", received.Header.Get("X-Forwarded-For"))
+	assert.Equal(t, "backend-secret", received.Header.Get("X-Api-Key"))
+}
+
+func TestReverseProxyRejectsOtherServices(t *testing.T) {
+	minter, frontend := newReverseProxy(t, http.NotFoundHandler(), nil)
+
+	token := paidToken(t, minter, service.NewId("other", 0))
+
+	req, _ := http.NewRequest(http.MethodGet, frontend+"/api/images", nil)
+	req.Header.Set("Authorization", auth.NewAuthorization(token).String())
+	resp, err := http.DefaultClient.Do(req)
+	assert.Nil(t, err, err)
+	resp.Body.Close()
+
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+}
+
+func TestReverseProxyStreams(t *testing.T) {
+	release := make(chan struct{})
+	minter, frontend := newReverseProxy(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: first\n\n")
+		w.(http.Flusher).Flush()
+
+		<-release
+		fmt.Fprint(w, "data: second\n\n")
+	}), nil)
+
+	token := paidToken(t, minter, testService.Id())
+
+	req, _ := http.NewRequest(http.MethodGet, frontend+"/api/events", nil)
+	req.Header.Set("Authorization", auth.NewAuthorization(token).String())
+	resp, err := http.DefaultClient.Do(req)
+	assert.Nil(t, err, err)
+	defer resp.Body.Close()
+
+	// The first event arrives while the upstream is still writing.
+	reader := bufio.NewReader(resp.Body)
+	line, err := reader.ReadString('\n')
+	assert.Nil(t, err, err)
+	assert.Equal(t, "data: first\n", line)
+
+	close(release)
+	rest, _ := io.ReadAll(reader)
+	assert.Equal(t, "\ndata: second\n\n", string(rest))
+}
+
+func TestReverseProxyTimeout(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+
+	minter, frontend := newReverseProxy(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}), func(route *proxy.Route) {
+		route.Timeout = 50 * time.Millisecond
+	})
+
+	token := paidToken(t, minter, testService.Id())
+
+	req, _ := http.NewRequest(http.MethodGet, frontend+"/api/slow", nil)
+	req.Header.Set("Authorization", auth.NewAuthorization(token).String())
+	resp, err := http.DefaultClient.Do(req)
+	assert.Nil(t, err, err)
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusGatewayTimeout, resp.StatusCode)
+	assert.Equal(t, problem.CodeUpstreamTimeout, decodeProblem(t, resp).Code)
+}
+
+func TestReverseProxyUnavailable(t *testing.T) {
+	// The upstream drops the connection without answering.
+	minter, frontend := newReverseProxy(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, _, _ := w.(http.Hijacker).Hijack()
+		conn.Close()
+	}), nil)
+
+	token := paidToken(t, minter, testService.Id())
+
+	req, _ := http.NewRequest(http.MethodGet, frontend+"/api/images", nil)
+	req.Header.Set("Authorization", auth.NewAuthorization(token).String())
+	resp, err := http.DefaultClient.Do(req)
+	assert.Nil(t, err, err)
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusBadGateway, resp.StatusCode)
+	assert.Equal(t, problem.CodeUpstreamUnavailable, decodeProblem(t, resp).Code)
+}
@@ -0,0 +1,91 @@
+package tests
+
+import (
+	"lsat/auth"
+	"lsat/config"
+	"lsat/macaroon"
+	"lsat/middleware"
+	"lsat/service"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRouteCaveats(t *testing.T) {
+	minter := newMiddlewareMinter()
+	handler := middleware.L402(minter, testService.Id())(protected)
+
+	// The token is shared for reading the public images only.
+	token := paidToken(t, minter, testService.Id())
+	token.Macaroon, _ = token.Macaroon.Oven().WithFirstPartyCaveats(
+		macaroon.NewCaveat(macaroon.RouteKey, "/images/public/*"),
+		macaroon.NewCaveat(macaroon.MethodKey, "GET,HEAD"),
+	).Bake()
+	authorization := auth.NewAuthorization(token).String()
+
+	requests := []struct {
+		method string
+		path   string
+		status int
+	}{
+		{http.MethodGet, "/images/public/a.png", http.StatusOK},
+		{http.MethodHead, "/images/public/b/c.png", http.StatusOK},
+		{http.MethodPost, "/images/public/a.png", http.StatusForbidden},
+		{http.MethodGet, "/images/private/a.png", http.StatusForbidden},
+		{http.MethodGet, "/images/public/../private/a.png", http.StatusForbidden},
+	}
+	for _, r := range requests {
+		req := httptest.NewRequest(r.method, r.path, nil)
+		req.Header.Set("Authorization", authorization)
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, req)
+		assert.Equal(t, r.status, resp.Code, r.method+" "+r.path)
+	}
+
+	// Without a request, the restricted token is rejected.
+	_, err := middleware.Authorize(minter, authorization, testService.Id())
+	assert.NotNil(t, err)
+	assert.NotNil(t, minter.AuthToken(&token))
+}
+
+func TestRouteMatch(t *testing.T) {
+	route := service.Route{Pattern: "/images/*.png, /status"}
+	match := func(path string) error {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		return route.Verify(service.NewVerificationContext(req, service.ToCaveat(route)))
+	}
+
+	assert.Nil(t, match("/images/a.png"))
+	assert.Nil(t, match("/status"))
+	assert.NotNil(t, match("/images/a.jpg"))
+	assert.NotNil(t, match("/images/public/a.png"))
+	assert.NotNil(t, match("/status/a"))
+
+	// The caveats without a request fail.
+	assert.NotNil(t, route.Satisfy(service.ToCaveat(route)))
+	assert.Nil(t, route.Satisfy())
+}
+
+func TestConfigRouteCaveats(t *testing.T) {
+	data := `services:
+  - name: image
+    price: 1sat
+    caveats:
+      - { type: route, value: "/images/*" }
+      - { type: method, value: "GET,HEAD" }
+lightning:
+  backend: mock
+`
+	cfg, err := config.Parse([]byte(data), config.YAML)
+	assert.Nil(t, err, err)
+	assert.Equal(t, []service.Caveat{
+		service.Route{Pattern: "/images/*"},
+		service.Methods{Methods: []string{"GET", "HEAD"}},
+	}, cfg.Services[0].FirstPartyCaveats)
+
+	_, err = config.Parse([]byte(strings.Replace(data, `value: "/images/*"`, `value: ""`, 1)), config.YAML)
+	assert.ErrorContains(t, err, "services[0].caveats[0].value")
+}
@@ -0,0 +1,185 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"lsat/amount"
+	"lsat/challenge"
+	"lsat/phoenixd"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/websocket"
+)
+
+// newEventServer serves a phoenixd websocket sending the frames written to the channel.
+//
+// An empty frame closes the connection. The incoming payments are listed from a canned body,
+// by pages of 20 payments unless another limit is requested, like phoenixd.
+func newEventServer(t *testing.T, payments string) (chan<- string, *phoenixd.EventStream) {
+	var listed []json.RawMessage
+	if err := json.Unmarshal([]byte(payments), &listed); err != nil {
+		t.Fatal(err)
+	}
+
+	frames := make(chan string)
+
+	mux := http.NewServeMux()
+	mux.Handle("/websocket", websocket.Handler(func(conn *websocket.Conn) {
+		for frame := range frames {
+			if frame == "" {
+				return
+			}
+			websocket.Message.Send(conn, frame)
+		}
+	}))
+	mux.HandleFunc("/payments/incoming", func(w http.ResponseWriter, r *http.Request) {
+		limit, offset := 20, 0
+		if value := r.URL.Query().Get("limit"); value != "" {
+			limit, _ = strconv.Atoi(value)
+		}
+		if value := r.URL.Query().Get("offset"); value != "" {
+			offset, _ = strconv.Atoi(value)
+		}
+		page := listed[min(offset, len(listed)):min(offset+limit, len(listed))]
+		json.NewEncoder(w).Encode(append([]json.RawMessage{}, page...))
+	})
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, password, ok := r.BasicAuth(); !ok || password != phoenixPassword {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+
+	stream := phoenixd.NewEventStream(phoenixd.NewPhoenixClient(server.URL, phoenixPassword))
+	stream.MinBackoff = 10 * time.Millisecond
+	stream.OnError = func(err error) { t.Log(err) }
+	return frames, stream
+}
+
+func paymentFrame(paymentHash string, amountSat uint64, timestamp time.Time) string {
+	return fmt.Sprintf(`{"type": "payment_received", "timestamp": %d, "amountSat": %d, "paymentHash": "%s"}`, timestamp.UnixMilli(), amountSat, paymentHash)
+}
+
+// receive waits for the next event of a stream.
+func receive(t *testing.T, events <-chan phoenixd.PaymentReceived) phoenixd.PaymentReceived {
+	select {
+	case event := <-events:
+		return event
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event received")
+		return phoenixd.PaymentReceived{}
+	}
+}
+
+func TestStreamDeliversPayments(t *testing.T) {
+	frames, stream := newEventServer(t, `[]`)
+	ctx, cancel := context.WithCancel(context.Background())
+	events := stream.Events(ctx)
+
+	now := time.Now()
+	frames <- `{"type": "channel_opened"}`
+	frames <- `not json`
+	frames <- paymentFrame("aa", 10, now)
+	frames <- paymentFrame("aa", 10, now)
+	frames <- paymentFrame("bb", 20, now)
+
+	event := receive(t, events)
+	assert.Equal(t, phoenixd.PaymentReceivedType, event.Type)
+	assert.Equal(t, "aa", event.PaymentHash)
+	assert.Equal(t, uint64(10), event.AmountSat)
+	assert.Equal(t, "bb", receive(t, events).PaymentHash)
+
+	cancel()
+	_, open := <-events
+	assert.False(t, open)
+}
+
+func TestStreamResumesWithoutDuplicates(t *testing.T) {
+	now := time.Now()
+	payments := fmt.Sprintf(`[
+		{"paymentHash": "aa", "isPaid": true, "receivedSat": 10, "completedAt": %d},
+		{"paymentHash": "bb", "isPaid": true, "receivedSat": 20, "completedAt": %d},
+		{"paymentHash": "cc", "isPaid": false}
+	]`, now.UnixMilli(), now.Add(time.Second).UnixMilli())
+
+	frames, stream := newEventServer(t, payments)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events := stream.Events(ctx)
+
+	frames <- paymentFrame("aa", 10, now)
+	assert.Equal(t, "aa", receive(t, events).PaymentHash)
+
+	// bb is paid while disconnected, and is listed on reconnection.
+	frames <- ""
+	assert.Equal(t, "bb", receive(t, events).PaymentHash)
+
+	frames <- paymentFrame("bb", 20, now.Add(time.Second))
+	frames <- paymentFrame("dd", 40, now.Add(2*time.Second))
+	assert.Equal(t, "dd", receive(t, events).PaymentHash)
+}
+
+func TestStreamResumesEveryPage(t *testing.T) {
+	now := time.Now()
+
+	// More payments are received while disconnected than a page holds, newest first.
+	var listed []string
+	for i := 120; i > 0; i-- {
+		listed = append(listed, fmt.Sprintf(`{"paymentHash": "%03d", "isPaid": true, "receivedSat": 1, "completedAt": %d}`,
+			i, now.Add(time.Duration(i)*time.Millisecond).UnixMilli()))
+	}
+
+	frames, stream := newEventServer(t, "["+strings.Join(listed, ",")+"]")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events := stream.Events(ctx)
+
+	frames <- paymentFrame("000", 1, now)
+	assert.Equal(t, "000", receive(t, events).PaymentHash)
+
+	frames <- ""
+	received := map[string]bool{}
+	for i := 0; i < 120; i++ {
+		received[receive(t, events).PaymentHash] = true
+	}
+	assert.Len(t, received, 120)
+}
+
+func TestPhoenixNodeSubscribeInvoices(t *testing.T) {
+	frames, stream := newEventServer(t, `[]`)
+	node := phoenixd.PhoenixNode{Client: stream.Client}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	invoices, err := node.SubscribeInvoices(ctx)
+	assert.Nil(t, err, err)
+
+	pending := challenge.NewPendingChallenges()
+	invoice := challenge.InvoiceResponse{PaymentHash: [32]byte{1}, Amount: 2 * amount.Satoshi}
+	pending.Track(invoice)
+
+	followed := make(chan struct{})
+	go func() {
+		pending.Follow(invoices)
+		close(followed)
+	}()
+
+	frames <- paymentFrame("not a hash", 2, time.Now())
+	frames <- paymentFrame(invoice.PaymentHash.String(), 2, time.Now())
+	// Wait for the frame to be read.
+	frames <- `{"type": "channel_opened"}`
+
+	assert.Eventually(t, func() bool { return pending.IsPaid(invoice.PaymentHash) }, 5*time.Second, 10*time.Millisecond)
+
+	cancel()
+	<-followed
+}
@@ -0,0 +1,80 @@
+package tests
+
+import (
+	"errors"
+	"lsat/macaroon"
+	"lsat/service"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// clientIP is a condition allowing a single client, and storing the services it verified.
+type clientIP struct{ ip string }
+
+func (c clientIP) Verify(ctx *service.VerificationContext) error {
+	verified, _ := ctx.Value("verified").([]string)
+	ctx.SetValue("verified", append(verified, ctx.Service.Name))
+	if ip := ctx.ClientIP(); ip == nil || ip.String() != c.ip {
+		return errors.New("the client is not allowed")
+	}
+	return nil
+}
+
+// maxCaveats is a condition written before the VerificationContext.
+type maxCaveats struct{ max int }
+
+func (m maxCaveats) Satisfy(caveats ...macaroon.Caveat) error {
+	if len(caveats) > m.max {
+		return errors.New("too many caveats")
+	}
+	return nil
+}
+
+func TestVerificationContext(t *testing.T) {
+	image := service.NewService(serviceName, servicePrice)
+	image.Conditions = []service.Condition{clientIP{ip: "192.0.2.1"}, service.Adapt(maxCaveats{max: 2})}
+	config := service.NewConfig(image)
+
+	caveats := []macaroon.Caveat{macaroon.NewCaveat(macaroon.ServiceKey, image.Id().String())}
+	req := httptest.NewRequest(http.MethodGet, "/images", nil)
+	ctx := service.NewVerificationContext(req, caveats...)
+	assert.Nil(t, config.Verify(ctx))
+	assert.Equal(t, []string{serviceName}, ctx.Value("verified"))
+
+	req.RemoteAddr = "198.51.100.1:1234"
+	assert.NotNil(t, config.Verify(service.NewVerificationContext(req, caveats...)))
+
+	// Without a request, the client is unknown.
+	assert.NotNil(t, config.VerifyCaveats(caveats...))
+
+	// The adapted condition sees the caveats.
+	req.RemoteAddr = "192.0.2.1:1234"
+	caveats = append(caveats, macaroon.NewCaveat("a", "1"), macaroon.NewCaveat("b", "2"))
+	assert.NotNil(t, config.Verify(service.NewVerificationContext(req, caveats...)))
+}
+
+func TestVerificationClock(t *testing.T) {
+	image := service.NewService(serviceName, servicePrice)
+	image.Conditions = []service.Condition{service.Expire{}, service.NotBefore{}}
+	config := service.NewConfig(image)
+
+	now := time.Now()
+	caveats := []macaroon.Caveat{
+		macaroon.NewCaveat(macaroon.ServiceKey, image.Id().String()),
+		macaroon.NewCaveat(macaroon.NotBeforeKey, now.Add(time.Hour).Format(time.RFC3339)),
+		macaroon.NewCaveat(macaroon.ExpiryDateKey, now.Add(2*time.Hour).Format(time.RFC3339)),
+	}
+	assert.NotNil(t, config.VerifyCaveats(caveats...))
+
+	// The clock of the config is the default of the verifications.
+	config.Clock = func() time.Time { return now.Add(90 * time.Minute) }
+	assert.Nil(t, config.VerifyCaveats(caveats...))
+
+	ctx := service.NewVerificationContext(nil, caveats...)
+	ctx.Clock = func() time.Time { return now.Add(3 * time.Hour) }
+	assert.NotNil(t, config.Verify(ctx))
+}
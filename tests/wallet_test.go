@@ -0,0 +1,214 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"lsat/amount"
+	"lsat/auth"
+	"lsat/challenge"
+	"lsat/mock"
+	"lsat/service"
+	"lsat/wallet"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func assertRefusal(t *testing.T, err error, reason wallet.RefusalReason) *wallet.Refusal {
+	var refusal *wallet.Refusal
+	if assert.True(t, errors.As(err, &refusal), "expected a Refusal, got %v", err) {
+		assert.Equal(t, reason, refusal.Reason, refusal.Error())
+	}
+	return refusal
+}
+
+// invoiceOf creates an invoice of the given amount on a fresh mock node.
+func invoiceOf(t *testing.T, price amount.MilliSatoshi) challenge.PayInvoiceRequest {
+	invoice, err := mock.NewLightningNode(0).CreateInvoice(context.Background(), challenge.CreateInvoiceRequest{Amount: price})
+	assert.Nil(t, err, err)
+	return challenge.PayInvoiceRequest{Invoice: invoice.Invoice}
+}
+
+func TestWalletMaxPayment(t *testing.T) {
+	w := wallet.NewWallet(mock.NewLightningNode(100*amount.Satoshi), wallet.Policy{
+		MaxPayment: 10 * amount.Satoshi,
+		Network:    mock.Network,
+	}, &wallet.MemoryLedger{})
+
+	_, err := w.Pay(context.Background(), "api.example.com", invoiceOf(t, 10*amount.Satoshi))
+	assert.Nil(t, err, err)
+
+	_, err = w.Pay(context.Background(), "api.example.com", invoiceOf(t, 11*amount.Satoshi))
+	refusal := assertRefusal(t, err, wallet.ReasonMaxPayment)
+	assert.Equal(t, 11*amount.Satoshi, refusal.Amount)
+	assert.Equal(t, 10*amount.Satoshi, refusal.Limit)
+}
+
+func TestWalletDailyBudget(t *testing.T) {
+	now := time.Now()
+	w := wallet.NewWallet(mock.NewLightningNode(100*amount.Satoshi), wallet.Policy{
+		DailyBudget: 25 * amount.Satoshi,
+		Network:     mock.Network,
+	}, &wallet.MemoryLedger{})
+	w.Clock = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		_, err := w.Pay(context.Background(), "a.example.com", invoiceOf(t, 10*amount.Satoshi))
+		assert.Nil(t, err, err)
+	}
+
+	_, err := w.Pay(context.Background(), "b.example.com", invoiceOf(t, 10*amount.Satoshi))
+	refusal := assertRefusal(t, err, wallet.ReasonDailyBudget)
+	assert.Equal(t, 20*amount.Satoshi, refusal.Spent)
+
+	// The budget is rolling, the payments are forgotten after 24 hours.
+	now = now.Add(25 * time.Hour)
+
+	_, err = w.Pay(context.Background(), "b.example.com", invoiceOf(t, 10*amount.Satoshi))
+	assert.Nil(t, err, err)
+}
+
+func TestWalletHosts(t *testing.T) {
+	w := wallet.NewWallet(mock.NewLightningNode(100*amount.Satoshi), wallet.Policy{
+		AllowedHosts: []string{"api.example.com", "*.images.net", "Pay.example.org:443"},
+		HostCaps:     map[string]amount.MilliSatoshi{"api.example.com": 15 * amount.Satoshi},
+		Network:      mock.Network,
+	}, &wallet.MemoryLedger{})
+
+	_, err := w.Pay(context.Background(), "evil.com", invoiceOf(t, amount.Satoshi))
+	assertRefusal(t, err, wallet.ReasonHostNotAllowed)
+
+	_, err = w.Pay(context.Background(), "cdn.images.net:443", invoiceOf(t, amount.Satoshi))
+	assert.Nil(t, err, err)
+
+	// The entries are normalized like the hosts.
+	_, err = w.Pay(context.Background(), "pay.example.org", invoiceOf(t, amount.Satoshi))
+	assert.Nil(t, err, err)
+
+	_, err = w.Pay(context.Background(), "API.example.com:8080", invoiceOf(t, 10*amount.Satoshi))
+	assert.Nil(t, err, err)
+
+	_, err = w.Pay(context.Background(), "api.example.com", invoiceOf(t, 10*amount.Satoshi))
+	refusal := assertRefusal(t, err, wallet.ReasonHostCap)
+	assert.Equal(t, "api.example.com", refusal.Host)
+	assert.Equal(t, 10*amount.Satoshi, refusal.Spent)
+}
+
+func TestWalletLedgerPersistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ledger.jsonl")
+
+	ledger, err := wallet.NewFileLedger(path)
+	assert.Nil(t, err, err)
+
+	policy := wallet.Policy{DailyBudget: 15 * amount.Satoshi, Network: mock.Network}
+	node := mock.NewLightningNode(100 * amount.Satoshi)
+
+	_, err = wallet.NewWallet(node, policy, ledger).Pay(context.Background(), "api.example.com", invoiceOf(t, 10*amount.Satoshi))
+	assert.Nil(t, err, err)
+
+	// The budget survives a restart of the wallet.
+	ledger, err = wallet.NewFileLedger(path)
+	assert.Nil(t, err, err)
+
+	payments, _ := ledger.Payments(time.Time{})
+	assert.Len(t, payments, 1)
+	assert.True(t, payments[0].Settled)
+
+	_, err = wallet.NewWallet(node, policy, ledger).Pay(context.Background(), "api.example.com", invoiceOf(t, 10*amount.Satoshi))
+	assertRefusal(t, err, wallet.ReasonDailyBudget)
+}
+
+func TestWalletRecordsFailures(t *testing.T) {
+	ledger := &wallet.MemoryLedger{}
+	w := wallet.NewWallet(mock.NewLightningNode(amount.Satoshi), wallet.Policy{Network: mock.Network}, ledger)
+
+	_, err := w.Pay(context.Background(), "api.example.com", invoiceOf(t, 10*amount.Satoshi))
+	assert.ErrorIs(t, err, mock.ErrInsufficientFunds)
+
+	payments, _ := ledger.Payments(time.Time{})
+	assert.Len(t, payments, 1)
+	assert.False(t, payments[0].Settled)
+	assert.NotEmpty(t, payments[0].Error)
+}
+
+func TestWalletPayToken(t *testing.T) {
+	minter := auth.NewMinter(service.NewConfig(service.NewService(serviceName, servicePrice)), secretStore, mock.NewChallenger())
+
+	preToken, err := minter.MintToken(secretStore.NewUser(), service.NewId(serviceName, 0))
+	assert.Nil(t, err, err)
+
+	w := wallet.NewWallet(mock.NewLightningNode(10*amount.Satoshi), wallet.Policy{
+		MaxPayment: amount.Satoshi,
+		Network:    mock.Network,
+	}, &wallet.MemoryLedger{})
+
+	token, err := w.PayToken("localhost:8080", preToken)
+	assert.Nil(t, err, err)
+
+	err = minter.AuthToken(&token)
+	assert.Nil(t, err, err)
+
+	_, err = w.PayToken("localhost:8080", preToken)
+	assert.NotNil(t, err, "A paid invoice cannot be paid twice")
+}
+
+func TestWalletCountsPendingPayments(t *testing.T) {
+	ledger := &wallet.MemoryLedger{}
+	node := mock.NewFaultyLightningNode(mock.NewLightningNode(100*amount.Satoshi), mock.FaultConfig{LostResponseRate: 1})
+	w := wallet.NewWallet(node, wallet.Policy{
+		HostCaps: map[string]amount.MilliSatoshi{"API.Example.com:443": 15 * amount.Satoshi},
+		Network:  mock.Network,
+	}, ledger)
+
+	request := invoiceOf(t, 10*amount.Satoshi)
+	_, err := w.Pay(context.Background(), "api.example.com", request)
+	assert.ErrorIs(t, err, challenge.ErrPaymentInFlight)
+
+	payments, _ := ledger.Payments(time.Time{})
+	assert.Len(t, payments, 1)
+	assert.True(t, payments[0].Pending)
+
+	// The payment may have settled, retrying it cannot exceed the cap.
+	_, err = w.Pay(context.Background(), "API.example.com", invoiceOf(t, 10*amount.Satoshi))
+	refusal := assertRefusal(t, err, wallet.ReasonHostCap)
+	assert.Equal(t, 10*amount.Satoshi, refusal.Spent)
+
+	// Once confirmed failed, the payment is no longer counted.
+	invoice, err := challenge.DecodeInvoice(request.Invoice, mock.Network)
+	assert.Nil(t, err, err)
+	assert.Nil(t, w.Resolve(*invoice.PaymentHash, false))
+
+	total, onHost, err := w.Spent("api.example.com")
+	assert.Nil(t, err, err)
+	assert.Zero(t, total)
+	assert.Zero(t, onHost)
+}
+
+func TestWalletResolveKeepsBudgetWindow(t *testing.T) {
+	now := time.Now()
+	ledger := &wallet.MemoryLedger{}
+	node := mock.NewFaultyLightningNode(mock.NewLightningNode(100*amount.Satoshi), mock.FaultConfig{LostResponseRate: 1})
+	w := wallet.NewWallet(node, wallet.Policy{DailyBudget: 15 * amount.Satoshi, Network: mock.Network}, ledger)
+	w.Clock = func() time.Time { return now }
+
+	request := invoiceOf(t, 10*amount.Satoshi)
+	_, err := w.Pay(context.Background(), "api.example.com", request)
+	assert.ErrorIs(t, err, challenge.ErrPaymentInFlight)
+
+	// The payment settled a day later still counts from when it was made.
+	now = now.Add(23 * time.Hour)
+	invoice, err := challenge.DecodeInvoice(request.Invoice, mock.Network)
+	assert.Nil(t, err, err)
+	assert.Nil(t, w.Resolve(*invoice.PaymentHash, true))
+
+	total, _, err := w.Spent("api.example.com")
+	assert.Nil(t, err, err)
+	assert.Equal(t, 10*amount.Satoshi, total)
+
+	now = now.Add(2 * time.Hour)
+	total, _, err = w.Spent("api.example.com")
+	assert.Nil(t, err, err)
+	assert.Zero(t, total)
+}
@@ -0,0 +1,158 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"lsat/amount"
+	"lsat/auth"
+	"lsat/challenge"
+	"lsat/mock"
+	"lsat/phoenixd"
+	"lsat/phoenixd/webhook"
+	"lsat/proxy"
+	"lsat/service"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lightningnetwork/lnd/lntypes"
+	"github.com/stretchr/testify/assert"
+)
+
+const webhookSecret = "webhook-secret"
+
+// postEvent posts a payment_received event signed with a secret.
+func postEvent(handler http.Handler, secret string, event phoenixd.PaymentReceived) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(event)
+	request := httptest.NewRequest(http.MethodPost, "/webhook/phoenixd", bytes.NewReader(body))
+	request.Header.Set(webhook.SignatureHeader, webhook.Sign(secret, body))
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+	return recorder
+}
+
+func TestWebhookSignature(t *testing.T) {
+	body := []byte(`{"type":"payment_received"}`)
+	signature := webhook.Sign(webhookSecret, body)
+
+	assert.Nil(t, webhook.Verify(webhookSecret, body, signature))
+	assert.ErrorIs(t, webhook.Verify("other", body, signature), webhook.ErrInvalidSignature)
+	assert.ErrorIs(t, webhook.Verify(webhookSecret, []byte(`{}`), signature), webhook.ErrInvalidSignature)
+	assert.ErrorIs(t, webhook.Verify(webhookSecret, body, "not hex"), webhook.ErrInvalidSignature)
+	assert.ErrorIs(t, webhook.Verify(webhookSecret, body, ""), webhook.ErrMissingSignature)
+}
+
+func TestWebhookFanOut(t *testing.T) {
+	handler := webhook.NewHandler(webhookSecret)
+
+	var first, second []phoenixd.PaymentReceived
+	handler.Subscribe(func(event phoenixd.PaymentReceived) { first = append(first, event) })
+	unsubscribe := handler.Subscribe(func(event phoenixd.PaymentReceived) { second = append(second, event) })
+
+	event := phoenixd.PaymentReceived{Type: phoenixd.PaymentReceivedType, AmountSat: 10, PaymentHash: "aa", ExternalId: "order-1"}
+	assert.Equal(t, http.StatusOK, postEvent(handler, webhookSecret, event).Code)
+
+	unsubscribe()
+	assert.Equal(t, http.StatusOK, postEvent(handler, webhookSecret, event).Code)
+
+	assert.Len(t, first, 2)
+	assert.Len(t, second, 1)
+	assert.Equal(t, "order-1", first[0].ExternalId)
+	assert.Equal(t, uint64(10), first[0].AmountSat)
+}
+
+func TestWebhookRejectsForgedEvents(t *testing.T) {
+	handler := webhook.NewHandler(webhookSecret)
+
+	received := 0
+	handler.Subscribe(func(phoenixd.PaymentReceived) { received++ })
+
+	event := phoenixd.PaymentReceived{Type: phoenixd.PaymentReceivedType, AmountSat: 10, PaymentHash: "aa"}
+	assert.Equal(t, http.StatusUnauthorized, postEvent(handler, "forged", event).Code)
+
+	// Other event types are acknowledged but not delivered.
+	assert.Equal(t, http.StatusOK, postEvent(handler, webhookSecret, phoenixd.PaymentReceived{Type: "channel_opened"}).Code)
+
+	assert.Equal(t, 0, received)
+}
+
+func TestWebhookSettlesPendingChallenges(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	pending := challenge.NewPendingChallenges()
+	challenger := &challenge.TrackingChallenger{Challenger: mock.NewChallenger(), Pending: pending}
+	minter := auth.NewMinter(service.NewConfig(testService), secretStore, challenger)
+	server := proxy.L402ProxyServer{Minter: &minter, Pending: pending, Webhook: webhook.NewHandler(webhookSecret)}
+	router := server.Router()
+
+	invoice, err := challenger.Challenge(context.Background(), 2*amount.Satoshi)
+	assert.Nil(t, err, err)
+	assert.False(t, pending.IsPaid(invoice.PaymentHash))
+
+	// An underpayment does not settle the challenge.
+	event := phoenixd.PaymentReceived{Type: phoenixd.PaymentReceivedType, AmountSat: 1, PaymentHash: invoice.PaymentHash.String()}
+	assert.Equal(t, http.StatusOK, postEvent(router, webhookSecret, event).Code)
+	assert.False(t, pending.IsPaid(invoice.PaymentHash))
+
+	event.AmountSat = 2
+	assert.Equal(t, http.StatusOK, postEvent(router, webhookSecret, event).Code)
+	assert.True(t, pending.IsPaid(invoice.PaymentHash))
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, fmt.Sprintf("/challenge/%s", invoice.PaymentHash), nil))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), `"paid":true`)
+}
+
+func TestWebhookSubscribesOnce(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	pending := challenge.NewPendingChallenges()
+	settled := 0
+	pending.OnSettle = func(challenge.ChallengeStatus) { settled++ }
+	challenger := &challenge.TrackingChallenger{Challenger: mock.NewChallenger(), Pending: pending}
+	minter := auth.NewMinter(service.NewConfig(testService), secretStore, challenger)
+	hook := webhook.NewHandler(webhookSecret)
+	server := proxy.L402ProxyServer{Minter: &minter, Pending: pending, Webhook: hook}
+	server.Router()
+	server.Router()
+
+	received := 0
+	hook.Subscribe(func(phoenixd.PaymentReceived) { received++ })
+
+	invoice, err := challenger.Challenge(context.Background(), amount.Satoshi)
+	assert.Nil(t, err, err)
+	event := phoenixd.PaymentReceived{Type: phoenixd.PaymentReceivedType, AmountSat: 1, PaymentHash: invoice.PaymentHash.String()}
+	assert.Equal(t, http.StatusOK, postEvent(hook, webhookSecret, event).Code)
+
+	assert.Equal(t, 1, received)
+	assert.Equal(t, 1, settled)
+}
+
+func TestPendingChallengesExpire(t *testing.T) {
+	now := time.Now()
+	pending := challenge.NewPendingChallenges()
+	pending.Clock = func() time.Time { return now }
+	pending.TTL = time.Hour
+
+	old := challenge.InvoiceResponse{PaymentHash: lntypes.Hash{1}, Amount: amount.Satoshi}
+	pending.Track(old)
+
+	now = now.Add(30 * time.Minute)
+	pending.Track(challenge.InvoiceResponse{PaymentHash: lntypes.Hash{2}, Amount: amount.Satoshi})
+	_, ok := pending.Status(old.PaymentHash)
+	assert.True(t, ok)
+
+	// The challenges older than the TTL are pruned as new ones are tracked.
+	now = now.Add(45 * time.Minute)
+	pending.Track(challenge.InvoiceResponse{PaymentHash: lntypes.Hash{3}, Amount: amount.Satoshi})
+	_, ok = pending.Status(old.PaymentHash)
+	assert.False(t, ok)
+	_, ok = pending.Status(lntypes.Hash{2})
+	assert.True(t, ok)
+}
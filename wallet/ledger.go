@@ -0,0 +1,123 @@
+package wallet
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"lsat/amount"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Payment is an entry of the payment ledger.
+type Payment struct {
+	Time        time.Time           `json:"time"`
+	Host        string              `json:"host"`
+	Amount      amount.MilliSatoshi `json:"amount_msat"`
+	PaymentHash string              `json:"payment_hash"`
+	Settled     bool                `json:"settled"`
+	Pending     bool                `json:"pending,omitempty"` // Whether the outcome is unknown, see Wallet.Resolve.
+	Error       string              `json:"error,omitempty"`
+}
+
+// Ledger records the payments made by a wallet.
+type Ledger interface {
+	// Record appends a payment to the ledger.
+	Record(Payment) error
+
+	// Payments returns the payments made since the given time.
+	Payments(since time.Time) ([]Payment, error)
+}
+
+// MemoryLedger keeps the payments in memory.
+type MemoryLedger struct {
+	mu       sync.Mutex
+	payments []Payment
+}
+
+func (l *MemoryLedger) Record(payment Payment) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.payments = append(l.payments, payment)
+	return nil
+}
+
+func (l *MemoryLedger) Payments(since time.Time) ([]Payment, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var payments []Payment
+	for _, payment := range l.payments {
+		if !payment.Time.Before(since) {
+			payments = append(payments, payment)
+		}
+	}
+	return payments, nil
+}
+
+// FileLedger persists the payments in a file, one JSON object per line.
+type FileLedger struct {
+	MemoryLedger
+	path string
+}
+
+// Open the ledger stored at the given path, creating it if needed.
+func NewFileLedger(path string) (*FileLedger, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+
+	ledger := &FileLedger{path: path}
+
+	file, err := os.OpenFile(path, os.O_RDONLY|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open the ledger: %v", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		var payment Payment
+		if err := json.Unmarshal(scanner.Bytes(), &payment); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal the payment at line %d: %v", line, err)
+		}
+		ledger.payments = append(ledger.payments, payment)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return ledger, nil
+}
+
+// Record appends the payment to the file before keeping it in memory.
+func (l *FileLedger) Record(payment Payment) error {
+	data, err := json.Marshal(payment)
+	if err != nil {
+		return fmt.Errorf("failed to marshal payment: %v", err)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	file, err := os.OpenFile(l.path, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open the ledger: %v", err)
+	}
+	defer file.Close()
+
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write payment to the ledger: %v", err)
+	}
+
+	l.payments = append(l.payments, payment)
+	return nil
+}
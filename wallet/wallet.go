@@ -0,0 +1,295 @@
+// Package wallet enforces a spending policy on the payments of L402 challenges.
+package wallet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"lsat/amount"
+	"lsat/challenge"
+	"lsat/macaroon"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/lightningnetwork/lnd/lntypes"
+)
+
+// The window of the daily budgets.
+const budgetWindow = 24 * time.Hour
+
+// Policy limits the payments of a wallet. A zero limit disables it.
+type Policy struct {
+	MaxPayment   amount.MilliSatoshi            // The maximum amount of a single payment.
+	DailyBudget  amount.MilliSatoshi            // The maximum spent over the last 24 hours.
+	AllowedHosts []string                       // The hosts that can be paid, any host if empty. "*.example.com" matches subdomains.
+	HostCaps     map[string]amount.MilliSatoshi // The maximum spent on a host over the last 24 hours.
+	Network      *chaincfg.Params               // The network of the invoices, mainnet if nil.
+}
+
+// RefusalReason explains why a payment was refused.
+type RefusalReason string
+
+const (
+	ReasonMaxPayment     RefusalReason = "max_payment"
+	ReasonDailyBudget    RefusalReason = "daily_budget"
+	ReasonHostNotAllowed RefusalReason = "host_not_allowed"
+	ReasonHostCap        RefusalReason = "host_cap"
+	ReasonInvalidInvoice RefusalReason = "invalid_invoice"
+)
+
+// Refusal is returned when a payment breaks the policy.
+//
+// It carries what is needed to ask a human to approve the payment.
+type Refusal struct {
+	Reason RefusalReason       // The rule that refused the payment.
+	Host   string              // The host asking for the payment.
+	Amount amount.MilliSatoshi // The amount of the payment.
+	Limit  amount.MilliSatoshi // The limit that would be exceeded.
+	Spent  amount.MilliSatoshi // The amount already spent against the limit.
+}
+
+func (r *Refusal) Error() string {
+	switch r.Reason {
+	case ReasonHostNotAllowed:
+		return fmt.Sprintf("payment refused: the host %s is not allowed", r.Host)
+	case ReasonInvalidInvoice:
+		return fmt.Sprintf("payment refused: the invoice of %s is invalid", r.Host)
+	default:
+		return fmt.Sprintf("payment refused (%s): %s to %s exceeds the limit of %s, %s already spent",
+			r.Reason, r.Amount, r.Host, r.Limit, r.Spent)
+	}
+}
+
+// Wallet pays invoices through a LightningNode within the limits of a policy.
+//
+// Every payment is recorded in the ledger, which is also used to compute the
+// spending over the rolling window of the budgets.
+type Wallet struct {
+	Clock func() time.Time // The clock of the budgets, defaults to time.Now.
+
+	node   challenge.LightningNode
+	policy Policy
+	ledger Ledger
+	mu     sync.Mutex
+}
+
+// Create a new Wallet.
+func NewWallet(node challenge.LightningNode, policy Policy, ledger Ledger) *Wallet {
+	// The hosts of the caps are normalized as the hosts of the payments.
+	if policy.HostCaps != nil {
+		caps := make(map[string]amount.MilliSatoshi, len(policy.HostCaps))
+		for host, limit := range policy.HostCaps {
+			caps[normalizeHost(host)] = limit
+		}
+		policy.HostCaps = caps
+	}
+	return &Wallet{node: node, policy: policy, ledger: ledger}
+}
+
+func (w *Wallet) now() time.Time {
+	if w.Clock != nil {
+		return w.Clock()
+	}
+	return time.Now()
+}
+
+func (w *Wallet) network() *chaincfg.Params {
+	if w.policy.Network != nil {
+		return w.policy.Network
+	}
+	return &chaincfg.MainNetParams
+}
+
+// normalizeHost lowercases the host and removes its port.
+func normalizeHost(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.ToLower(host)
+}
+
+// isAllowed checks the host against the allowlist, whose entries are normalized like the host.
+func (w *Wallet) isAllowed(host string) bool {
+	if len(w.policy.AllowedHosts) == 0 {
+		return true
+	}
+
+	host = normalizeHost(host)
+	for _, allowed := range w.policy.AllowedHosts {
+		allowed = normalizeHost(allowed)
+		if allowed == host {
+			return true
+		}
+		if strings.HasPrefix(allowed, "*.") && strings.HasSuffix(host, allowed[1:]) {
+			return true
+		}
+	}
+	return false
+}
+
+// Spent returns the amount settled over the last 24 hours, in total and for the host.
+//
+// The pending payments, whose outcome is unknown, are counted until they are resolved as
+// failed, so that retrying a payment cannot exceed the budgets.
+func (w *Wallet) Spent(host string) (total amount.MilliSatoshi, onHost amount.MilliSatoshi, err error) {
+	host = normalizeHost(host)
+
+	payments, err := w.ledger.Payments(w.now().Add(-budgetWindow))
+	if err != nil {
+		return 0, 0, err
+	}
+
+	// The last entry of a payment is its outcome.
+	latest := make(map[string]int)
+	for i, payment := range payments {
+		if payment.PaymentHash != "" {
+			latest[payment.PaymentHash] = i
+		}
+	}
+
+	for i, payment := range payments {
+		if j, ok := latest[payment.PaymentHash]; ok && j != i {
+			continue
+		}
+		if !payment.Settled && !payment.Pending {
+			continue
+		}
+		total += payment.Amount
+		if payment.Host == host {
+			onHost += payment.Amount
+		}
+	}
+	return total, onHost, nil
+}
+
+// Resolve records the outcome of a pending payment, once it is known from the node.
+func (w *Wallet) Resolve(paymentHash lntypes.Hash, settled bool) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	payments, err := w.ledger.Payments(w.now().Add(-budgetWindow))
+	if err != nil {
+		return err
+	}
+	for i := len(payments) - 1; i >= 0; i-- {
+		payment := payments[i]
+		if payment.PaymentHash != paymentHash.String() {
+			continue
+		}
+		if !payment.Pending {
+			return nil
+		}
+		// The payment keeps its time, so that resolving it does not restart its budget window.
+		payment.Settled, payment.Pending = settled, false
+		return w.ledger.Record(payment)
+	}
+	return fmt.Errorf("no pending payment %s", paymentHash)
+}
+
+// Whether the outcome of a failed payment is unknown, so that it may still settle.
+func ambiguous(err error) bool {
+	var netErr net.Error
+	return errors.Is(err, challenge.ErrPaymentInFlight) ||
+		errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, context.Canceled) ||
+		errors.As(err, &netErr)
+}
+
+// check returns a Refusal if the payment breaks the policy.
+func (w *Wallet) check(host string, price amount.MilliSatoshi) error {
+	if !w.isAllowed(host) {
+		return &Refusal{Reason: ReasonHostNotAllowed, Host: host, Amount: price}
+	}
+
+	if w.policy.MaxPayment > 0 && price > w.policy.MaxPayment {
+		return &Refusal{Reason: ReasonMaxPayment, Host: host, Amount: price, Limit: w.policy.MaxPayment}
+	}
+
+	total, onHost, err := w.Spent(host)
+	if err != nil {
+		return err
+	}
+
+	if w.policy.DailyBudget > 0 && total+price > w.policy.DailyBudget {
+		return &Refusal{Reason: ReasonDailyBudget, Host: host, Amount: price, Limit: w.policy.DailyBudget, Spent: total}
+	}
+
+	if limit, ok := w.policy.HostCaps[host]; ok && onHost+price > limit {
+		return &Refusal{Reason: ReasonHostCap, Host: host, Amount: price, Limit: limit, Spent: onHost}
+	}
+
+	return nil
+}
+
+// Pay an invoice requested by the host.
+//
+// The payments are serialized so that concurrent payments cannot overspend the budgets.
+func (w *Wallet) Pay(ctx context.Context, host string, req challenge.PayInvoiceRequest) (challenge.PayInvoiceResponse, error) {
+	host = normalizeHost(host)
+
+	invoice, err := challenge.DecodeInvoice(req.Invoice, w.network())
+	if err != nil {
+		return challenge.PayInvoiceResponse{}, &Refusal{Reason: ReasonInvalidInvoice, Host: host}
+	}
+
+	price := req.Amount
+	if invoice.MilliSat != nil {
+		price = amount.FromLnwire(*invoice.MilliSat)
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if err := w.check(host, price); err != nil {
+		return challenge.PayInvoiceResponse{}, err
+	}
+
+	response, err := w.node.PayInvoice(ctx, req)
+
+	payment := Payment{
+		Time:    w.now(),
+		Host:    host,
+		Amount:  price,
+		Settled: err == nil,
+		Pending: err != nil && ambiguous(err),
+	}
+	if invoice.PaymentHash != nil {
+		payment.PaymentHash = lntypes.Hash(*invoice.PaymentHash).String()
+	}
+	if err != nil {
+		payment.Error = err.Error()
+	}
+
+	if recordErr := w.ledger.Record(payment); recordErr != nil && err == nil {
+		return response, recordErr
+	}
+
+	return response, err
+}
+
+// PayToken verifies and pays the invoice of a pre-token requested by the host.
+func (w *Wallet) PayToken(host string, token macaroon.PreToken) (macaroon.Token, error) {
+	return token.PayWithPolicy(w.ForHost(host), challenge.InvoicePolicy{Network: w.network(), Clock: w.Clock})
+}
+
+// ForHost returns a LightningNode paying the invoices of the host through the wallet.
+func (w *Wallet) ForHost(host string) challenge.LightningNode {
+	return &hostNode{wallet: w, host: host}
+}
+
+// A LightningNode bound to a host.
+type hostNode struct {
+	wallet *Wallet
+	host   string
+}
+
+func (n *hostNode) PayInvoice(ctx context.Context, req challenge.PayInvoiceRequest) (challenge.PayInvoiceResponse, error) {
+	return n.wallet.Pay(ctx, n.host, req)
+}
+
+func (n *hostNode) CreateInvoice(ctx context.Context, req challenge.CreateInvoiceRequest) (challenge.InvoiceResponse, error) {
+	return n.wallet.node.CreateInvoice(ctx, req)
+}